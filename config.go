@@ -0,0 +1,387 @@
+package engines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrInvalidConfig = errors.New("invalid engine config")
+
+//ConfigError list every problem found in a config, it match ErrInvalidConfig with errors.Is
+type ConfigError struct {
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+
+	return fmt.Sprintf("%v: %s", ErrInvalidConfig, strings.Join(err.Problems, "; "))
+}
+
+func (err *ConfigError) Is(target error) bool {
+
+	return target == ErrInvalidConfig
+}
+
+//PoolConfig describe a pool, either by URL or by Adapter and the other fields.
+//${VAR} in every field is expanded from environment variables, a $ that is not followed by { is kept.
+type PoolConfig struct {
+	//URL of the pool, see adapter.RegisterScheme
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	//Adapter url scheme of the pool when URL is not set, like mongodb, redis, rediscluster, firestore, file or mem
+	Adapter string `json:"adapter,omitempty" yaml:"adapter,omitempty"`
+	//Hosts host:port of the servers, or the project of firestore
+	Hosts    []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	User     string   `json:"user,omitempty" yaml:"user,omitempty"`
+	Password string   `json:"password,omitempty" yaml:"password,omitempty"`
+	//Database mongo database, redis db or root folder of file
+	Database string `json:"database,omitempty" yaml:"database,omitempty"`
+	//Settings options of the url like clients, pool_size or ping_timeout, they override the options of URL
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+	//Routes prefixes of collections, keys or paths those are routed to the pool, see Engine.RouteDocument
+	Routes []string `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+//DefaultPoolName name of the pool of a kind that is the default pool of the engine of NewFromConfig
+const DefaultPoolName = engine.DefaultPoolName
+
+//Config describe the named pools of an engine. The pool named DefaultPoolName of a kind is the pool returned
+//by GetDocumentPool, GetMemPool or GetFilePool, the only pool of a kind is the default pool as well.
+//OpenPools open every pool by name without an engine.
+//
+//	document:
+//	  default:
+//	    url: mongodb://localhost:27017/app?clients=2
+//	  analytics:
+//	    adapter: mongodb
+//	    hosts: [analytics:27017]
+//	    database: analytics
+//	    routes: [analytics_, report_]
+//	mem:
+//	  session:
+//	    adapter: redis
+//	    hosts: [localhost:6379]
+//	    password: ${REDIS_PASSWORD}
+//	    settings:
+//	      ping_timeout: 5s
+type Config struct {
+	Document map[string]PoolConfig `json:"document,omitempty" yaml:"document,omitempty"`
+	Mem      map[string]PoolConfig `json:"mem,omitempty" yaml:"mem,omitempty"`
+	File     map[string]PoolConfig `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+//ParseConfig parse config from yaml, json is parsed as well because it is yaml
+func ParseConfig(data []byte) (*Config, error) {
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+
+		return nil, &ConfigError{Problems: []string{err.Error()}}
+	}
+	return config, nil
+}
+
+//LoadConfig read config from a .yaml, .yml or .json file
+func LoadConfig(path string) (*Config, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+
+		config := &Config{}
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(config); err != nil {
+
+			return nil, &ConfigError{Problems: []string{fmt.Sprintf("%s: %v", path, err)}}
+		}
+		return config, nil
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+//envReference a ${VAR} reference in a config field
+var envReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+//expandEnv replace ${VAR} references by environment variables, other $ are kept so passwords can have them
+func expandEnv(value string) string {
+
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+
+		return os.Getenv(reference[2 : len(reference)-1])
+	})
+}
+
+//url build url of pool
+func (pool PoolConfig) url() (string, error) {
+
+	expand := expandEnv
+
+	u := &url.URL{}
+
+	if pool.URL != "" {
+
+		var err error = nil
+		if u, err = url.Parse(expand(pool.URL)); err != nil {
+
+			return "", err
+		}
+	} else {
+
+		hosts := make([]string, len(pool.Hosts))
+		for i, host := range pool.Hosts {
+			hosts[i] = expand(host)
+		}
+		u.Scheme = expand(pool.Adapter)
+		u.Host = strings.Join(hosts, ",")
+
+		if pool.Database != "" {
+			u.Path = "/" + strings.TrimPrefix(expand(pool.Database), "/")
+		}
+		if pool.Password != "" {
+			u.User = url.UserPassword(expand(pool.User), expand(pool.Password))
+		} else if pool.User != "" {
+			u.User = url.User(expand(pool.User))
+		}
+	}
+	if len(pool.Settings) > 0 {
+
+		query := u.Query()
+		for name, value := range pool.Settings {
+			query.Set(name, expand(fmt.Sprint(value)))
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+//validate append problems of pool to problems
+func (pool PoolConfig) validate(path string, provides func(openers adapter.SchemeOpeners) bool, problems []string) []string {
+
+	if pool.URL != "" && (pool.Adapter != "" || len(pool.Hosts) > 0 || pool.User != "" || pool.Password != "" || pool.Database != "") {
+
+		return append(problems, path+": url can not be used with adapter, hosts, user, password or database")
+	}
+	if pool.URL == "" && pool.Adapter == "" {
+
+		return append(problems, path+": url or adapter is required")
+	}
+	rawURL, err := pool.url()
+	if err != nil {
+
+		return append(problems, fmt.Sprintf("%s: invalid url: %v", path, err))
+	}
+	u, _ := url.Parse(rawURL)
+
+	openers, ok := adapter.LookupScheme(u.Scheme)
+	if !ok {
+
+		return append(problems, fmt.Sprintf("%s: unknown adapter %q", path, u.Scheme))
+	}
+	if !provides(openers) {
+
+		return append(problems, fmt.Sprintf("%s: adapter %q can not be used for this kind of pool", path, u.Scheme))
+	}
+	return problems
+}
+
+func sortedNames(pools map[string]PoolConfig) []string {
+
+	names := []string{}
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateKind(kind string, pools map[string]PoolConfig, provides func(openers adapter.SchemeOpeners) bool, problems []string) []string {
+
+	for _, name := range sortedNames(pools) {
+
+		if name == "" {
+
+			problems = append(problems, kind+": pool name is empty")
+			continue
+		}
+		problems = pools[name].validate(kind+"."+name, provides, problems)
+	}
+	if _, ok := pools[DefaultPoolName]; len(pools) > 1 && !ok {
+
+		problems = append(problems, fmt.Sprintf("%s: one of %d pools must be named %s", kind, len(pools), DefaultPoolName))
+	}
+	return problems
+}
+
+//Validate check config without opening pools, it return a *ConfigError that list every problem
+func (config *Config) Validate() error {
+
+	problems := []string{}
+
+	problems = validateKind("document", config.Document, func(openers adapter.SchemeOpeners) bool { return openers.Document != nil }, problems)
+	problems = validateKind("mem", config.Mem, func(openers adapter.SchemeOpeners) bool { return openers.Mem != nil }, problems)
+	problems = validateKind("file", config.File, func(openers adapter.SchemeOpeners) bool { return openers.File != nil }, problems)
+
+	if len(problems) > 0 {
+
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+//Pools the pools of a config by name
+type Pools struct {
+	Document map[string]engine.DocumentPool
+	Mem      map[string]engine.MemPool
+	File     map[string]engine.FilePool
+}
+
+//Close close every pool, it return the first error after trying to close all pools
+func (pools *Pools) Close(ctx context.Context) error {
+
+	lifecycles := []engine.Lifecycle{}
+	for _, pool := range pools.Document {
+		lifecycles = append(lifecycles, pool)
+	}
+	for _, pool := range pools.Mem {
+		lifecycles = append(lifecycles, pool)
+	}
+	for _, pool := range pools.File {
+		lifecycles = append(lifecycles, pool)
+	}
+	var first error = nil
+	for _, pool := range lifecycles {
+		if err := pool.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+//OpenPools validate config and open every pool of it by name.
+//Pools those were opened are closed if one fail to open.
+func OpenPools(config *Config) (*Pools, error) {
+
+	if err := config.Validate(); err != nil {
+
+		return nil, err
+	}
+	pools := &Pools{
+		Document: map[string]engine.DocumentPool{},
+		Mem:      map[string]engine.MemPool{},
+		File:     map[string]engine.FilePool{},
+	}
+	fail := func(kind string, name string, err error) (*Pools, error) {
+
+		pools.Close(context.Background())
+		return nil, fmt.Errorf("open %s pool %s: %w", kind, name, err)
+	}
+
+	for _, name := range sortedNames(config.Document) {
+
+		rawURL, _ := config.Document[name].url()
+		pool, err := adapter.OpenDocumentPool(rawURL)
+		if err != nil {
+
+			return fail("document", name, err)
+		}
+		pools.Document[name] = pool
+	}
+	for _, name := range sortedNames(config.Mem) {
+
+		rawURL, _ := config.Mem[name].url()
+		pool, err := adapter.OpenMemPool(rawURL)
+		if err != nil {
+
+			return fail("mem", name, err)
+		}
+		pools.Mem[name] = pool
+	}
+	for _, name := range sortedNames(config.File) {
+
+		rawURL, _ := config.File[name].url()
+		pool, err := adapter.OpenFilePool(rawURL)
+		if err != nil {
+
+			return fail("file", name, err)
+		}
+		pools.File[name] = pool
+	}
+	return pools, nil
+}
+
+//NewFromConfig validate config, open its pools and return a new engine that is independent of GetEngine.
+//Pools those were opened are closed if one fail to open.
+func NewFromConfig(config *Config) (*engine.Engine, error) {
+
+	pools, err := OpenPools(config)
+	if err != nil {
+
+		return nil, err
+	}
+	eng := &engine.Engine{}
+
+	for name, pool := range pools.Document {
+
+		eng.RegisterDocumentPool(name, pool)
+		if len(pools.Document) == 1 {
+			eng.RegisterDocumentPool(DefaultPoolName, pool)
+		}
+		for _, prefix := range config.Document[name].Routes {
+			eng.RouteDocument(prefix, name)
+		}
+	}
+	for name, pool := range pools.Mem {
+
+		eng.RegisterMemPool(name, pool)
+		if len(pools.Mem) == 1 {
+			eng.RegisterMemPool(DefaultPoolName, pool)
+		}
+		for _, prefix := range config.Mem[name].Routes {
+			eng.RouteMem(prefix, name)
+		}
+	}
+	for name, pool := range pools.File {
+
+		eng.RegisterFilePool(name, pool)
+		if len(pools.File) == 1 {
+			eng.RegisterFilePool(DefaultPoolName, pool)
+		}
+		for _, prefix := range config.File[name].Routes {
+			eng.RouteFile(prefix, name)
+		}
+	}
+	return eng, nil
+}
+
+//NewFromConfigFile load config from a .yaml, .yml or .json file and build engine with it, see NewFromConfig
+func NewFromConfigFile(path string) (*engine.Engine, error) {
+
+	config, err := LoadConfig(path)
+	if err != nil {
+
+		return nil, err
+	}
+	return NewFromConfig(config)
+}
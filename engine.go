@@ -1,27 +1,116 @@
 package engines
 
 import (
+	"context"
+	"sync"
+
 	"github.com/tapvanvn/godbengine/engine"
 )
 
 var uniqueEngine *engine.Engine = nil
 
-//InitEngineFunc init engine function
+var engineMux sync.Mutex
+
+//initMux serialize init of the default engine, InitEngineFunc is called without holding engineMux
+var initMux sync.Mutex
+
+var namedEngines = map[string]*engine.Engine{}
+
+//InitEngineFunc init engine function, it is called by GetEngine to init the default engine.
+//It can use the registry functions but must not call GetEngine, or FromContext with a context that has no engine.
+//Prefer making engines with engine.NewEngine or NewFromConfig and SetEngine.
 var InitEngineFunc func(*engine.Engine) = nil
 
-//GetEngine engine
+func defaultEngine() *engine.Engine {
+
+	engineMux.Lock()
+	defer engineMux.Unlock()
+
+	return uniqueEngine
+}
+
+//GetEngine get the default engine, it is made with InitEngineFunc on the first call. It is safe for concurrent use.
 func GetEngine() *engine.Engine {
 
-	if uniqueEngine == nil {
+	if eng := defaultEngine(); eng != nil {
+
+		return eng
+	}
+	initMux.Lock()
+	defer initMux.Unlock()
+
+	if eng := defaultEngine(); eng != nil {
+
+		return eng
+	}
+	testEngine := &engine.Engine{}
 
-		testEngine := &engine.Engine{}
+	if InitEngineFunc != nil {
 
-		if InitEngineFunc != nil {
+		InitEngineFunc(testEngine)
+	}
 
-			InitEngineFunc(testEngine)
-		}
+	engineMux.Lock()
+	defer engineMux.Unlock()
+
+	//InitEngineFunc may have set the default engine with SetEngine
+	if uniqueEngine == nil {
 
 		uniqueEngine = testEngine
 	}
 	return uniqueEngine
 }
+
+//SetEngine replace the default engine, nil make GetEngine init a new one with InitEngineFunc
+func SetEngine(eng *engine.Engine) {
+
+	engineMux.Lock()
+	defer engineMux.Unlock()
+
+	uniqueEngine = eng
+}
+
+//New make an engine that is independent of the default engine and init it with initFunc
+func New(initFunc func(*engine.Engine)) *engine.Engine {
+
+	eng := &engine.Engine{}
+
+	if initFunc != nil {
+
+		initFunc(eng)
+	}
+	return eng
+}
+
+//RegisterEngine register eng with name, nil unregister it
+func RegisterEngine(name string, eng *engine.Engine) {
+
+	engineMux.Lock()
+	defer engineMux.Unlock()
+
+	if eng == nil {
+
+		delete(namedEngines, name)
+		return
+	}
+	namedEngines[name] = eng
+}
+
+//GetEngineNamed get engine registered with name, nil if there is none
+func GetEngineNamed(name string) *engine.Engine {
+
+	engineMux.Lock()
+	defer engineMux.Unlock()
+
+	return namedEngines[name]
+}
+
+//FromContext get engine of ctx, see engine.WithEngine, or the default engine if ctx has none
+func FromContext(ctx context.Context) *engine.Engine {
+
+	if eng := engine.FromContext(ctx); eng != nil {
+
+		return eng
+	}
+	return GetEngine()
+}
@@ -1,7 +1,94 @@
 package adapter
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine"
+)
+
 var __measurement bool = false
 
+//SetMeasurement print duration of every operation of pools those have no observer, see engine.Observer
 func SetMeasurement(active bool) {
 	__measurement = active
 }
+
+var ErrInvalidConnectionString = errors.New("invalid connection string")
+var ErrConnect = errors.New("can not connect")
+
+//InitError is returned by Init of pools. It match its Kind (ErrInvalidConnectionString or ErrConnect)
+//with errors.Is and unwrap to the error that cause it.
+type InitError struct {
+	Kind    error
+	Adapter string
+	Err     error
+}
+
+func (err *InitError) Error() string {
+
+	return fmt.Sprintf("%s: %v: %v", err.Adapter, err.Kind, err.Err)
+}
+
+func (err *InitError) Unwrap() error {
+
+	return err.Err
+}
+
+func (err *InitError) Is(target error) bool {
+
+	return target == err.Kind
+}
+
+func newInvalidConnectionString(adapter string, format string, args ...interface{}) error {
+
+	return &InitError{Kind: ErrInvalidConnectionString, Adapter: adapter, Err: fmt.Errorf(format, args...)}
+}
+
+func newConnectError(adapter string, err error) error {
+
+	return &InitError{Kind: ErrConnect, Adapter: adapter, Err: err}
+}
+
+//parseNumClient split the optional [numClient] suffix of a server in connection string
+func parseNumClient(client string) (string, int, error) {
+
+	begin := strings.Index(client, "[")
+	if begin < 0 {
+
+		return client, 1, nil
+	}
+	end := strings.Index(client, "]")
+	if end < begin {
+
+		return "", 0, fmt.Errorf("%s has no closing ]", client)
+	}
+	numClient, err := strconv.Atoi(client[begin+1 : end])
+	if err != nil || numClient < 1 {
+
+		return "", 0, fmt.Errorf("%s has invalid number of client", client)
+	}
+	return client[:begin], numClient, nil
+}
+
+//pingOnInit ping pool with timeout when timeout is set, pool is closed if ping fail
+func pingOnInit(adapter string, pool engine.Lifecycle, timeout time.Duration) error {
+
+	if timeout <= 0 {
+
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := pool.Ping(ctx); err != nil {
+
+		pool.Close(context.Background())
+		return newConnectError(adapter, err)
+	}
+	return nil
+}
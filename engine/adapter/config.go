@@ -0,0 +1,102 @@
+package adapter
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tapvanvn/godbengine/engine"
+)
+
+//Config urls of the pools of an engine, see RegisterScheme. A pool is nil when its url is empty.
+type Config struct {
+	Document string
+	Mem      string
+	File     string
+}
+
+//envReference a ${VAR} reference in an url
+var envReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+//expandEnv replace ${VAR} references by environment variables, other $ are kept verbatim
+func expandEnv(value string) string {
+
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+
+		return os.Getenv(reference[2 : len(reference)-1])
+	})
+}
+
+//ConfigFromEnv read urls from PREFIX_DOCUMENT_URL, PREFIX_MEM_URL and PREFIX_FILE_URL.
+//${VAR} in urls are expanded so secrets can be kept in their own variables,
+//a $ that is not followed by { is kept so passwords and query strings can have it.
+func ConfigFromEnv(prefix string) Config {
+
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+
+		prefix += "_"
+	}
+	return Config{
+		Document: expandEnv(os.Getenv(prefix + "DOCUMENT_URL")),
+		Mem:      expandEnv(os.Getenv(prefix + "MEM_URL")),
+		File:     expandEnv(os.Getenv(prefix + "FILE_URL")),
+	}
+}
+
+//Open open the pools of config, pools those were opened are closed if one fail
+func (config Config) Open() (engine.MemPool, engine.DocumentPool, engine.FilePool, error) {
+
+	var memPool engine.MemPool = nil
+	var documentPool engine.DocumentPool = nil
+	var filePool engine.FilePool = nil
+	var err error = nil
+
+	if config.Mem != "" {
+
+		if memPool, err = OpenMemPool(config.Mem); err != nil {
+
+			return nil, nil, nil, err
+		}
+	}
+	if config.Document != "" {
+
+		if documentPool, err = OpenDocumentPool(config.Document); err != nil {
+
+			closePools(memPool)
+			return nil, nil, nil, err
+		}
+	}
+	if config.File != "" {
+
+		if filePool, err = OpenFilePool(config.File); err != nil {
+
+			closePools(memPool, documentPool)
+			return nil, nil, nil, err
+		}
+	}
+	return memPool, documentPool, filePool, nil
+}
+
+//InitEngine open the pools of config and init eng with them
+func (config Config) InitEngine(eng *engine.Engine) error {
+
+	memPool, documentPool, filePool, err := config.Open()
+	if err != nil {
+
+		return err
+	}
+	eng.Init(memPool, documentPool, filePool)
+	return nil
+}
+
+func closePools(pools ...engine.Lifecycle) {
+
+	for _, pool := range pools {
+
+		if pool != nil {
+
+			pool.Close(context.Background())
+		}
+	}
+}
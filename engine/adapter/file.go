@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -14,6 +15,8 @@ var ErrDBEngineNotADirectory = errors.New("Path is not a valid directory")
 var ErrDBEngineInvalidPath = errors.New("invalid path")
 
 type FileClient struct {
+	loggable
+	observable
 	absolutePath string
 }
 
@@ -37,7 +40,34 @@ func (client *FileClient) init(absolutePath string) {
 	//TODO: check absolutePath must be existed directory and have the read/write permission
 }
 
-func (client *FileClient) Read(path string) (*[]byte, error) {
+//Ping check if root folder is still a directory
+func (client *FileClient) Ping(ctx context.Context) error {
+	fileInfo, err := os.Stat(client.absolutePath)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return ErrDBEngineNotADirectory
+	}
+	return nil
+}
+
+//Close nothing to release
+func (client *FileClient) Close(ctx context.Context) error {
+	return nil
+}
+
+func (client *FileClient) Read(path string) (content *[]byte, err error) {
+
+	_, done := client.observe(context.Background(), "file", "read", "", path)
+	defer func() {
+		size := 0
+		if content != nil {
+			size = len(*content)
+		}
+		done(err, size)
+	}()
+
 	if !filepath.IsAbs(path) {
 
 		return nil, ErrDBEngineNotAbsolutePath
@@ -56,7 +86,10 @@ func (client *FileClient) Read(path string) (*[]byte, error) {
 	return &bytes, nil
 }
 
-func (client *FileClient) Write(path string, content *[]byte) error {
+func (client *FileClient) Write(path string, content *[]byte) (err error) {
+
+	_, done := client.observe(context.Background(), "file", "write", "", path)
+	defer func() { done(err, 0) }()
 
 	if !filepath.IsAbs(path) {
 
@@ -102,12 +135,52 @@ func (client *FileClient) Write(path string, content *[]byte) error {
 
 	realPath := fmt.Sprintf("%s%s", client.absolutePath, path)
 
-	fmt.Println("real path:", realPath)
+	client.getLogger().Debug("file write", "path", path)
 
-	return ioutil.WriteFile(realPath, *content, 0755)
+	return writeFileAtomic(realPath, *content, 0755)
 }
 
-func (client *FileClient) Delete(path string) error {
+//writeFileAtomic write content to a temp file in the same folder then rename it to path,
+//so a reader never see a half written file
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+
+	dir, name := filepath.Split(path)
+
+	file, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Chmod(tempPath, perm); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+func (client *FileClient) Delete(path string) (err error) {
+
+	_, done := client.observe(context.Background(), "file", "delete", "", path)
+	defer func() { done(err, 0) }()
 
 	if !filepath.IsAbs(path) {
 
@@ -1,11 +1,14 @@
 package adapter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tapvanvn/godbengine/engine"
@@ -14,16 +17,48 @@ import (
 //FileDocDB simulate a folder as document db
 //Just support limited function like get, put, delete
 type FileDocDB struct {
+	loggable
+	observable
 	fileClient *FileClient
+	mux        sync.Mutex
+	indexes    map[string][]engine.IndexSpec
+	//WatchInterval polling interval of Watch, default is 1 second
+	WatchInterval time.Duration
 }
 
-//Init init pool from connection string
+//Init init pool from connection string, transactions those were interrupted are rolled back
 func (db *FileDocDB) Init(connectionString string) error {
 	client, err := NewFileClient(connectionString)
 	if err != nil {
-		return err
+		return &InitError{Kind: ErrInvalidConnectionString, Adapter: "file", Err: err}
 	}
 	db.fileClient = client
+	db.fileClient.SetLogger(db.logger)
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.recoverJournals()
+}
+
+//SetLogger set logger of db and its file client
+func (db *FileDocDB) SetLogger(logger engine.Logger) {
+
+	db.logger = logger
+	if db.fileClient != nil {
+		db.fileClient.SetLogger(logger)
+	}
+}
+
+//Ping check if root folder is still a directory
+func (db *FileDocDB) Ping(ctx context.Context) error {
+
+	return db.fileClient.Ping(ctx)
+}
+
+//Close nothing to release
+func (db *FileDocDB) Close(ctx context.Context) error {
+
 	return nil
 }
 
@@ -33,9 +68,10 @@ func (db *FileDocDB) Put(collection string, document engine.Document) error {
 	return db.PutRaw(collection, document.GetID(), document)
 }
 
-func (db *FileDocDB) PutRaw(collection string, id string, document interface{}) error {
+func (db *FileDocDB) PutRaw(collection string, id string, document interface{}) (err error) {
 
-	path := fmt.Sprintf("/%s/%s.json", collection, id)
+	_, done := db.observe(context.Background(), "file_docdb", "put", collection, id)
+	defer func() { done(err, 0) }()
 
 	content, err := json.Marshal(document)
 
@@ -43,10 +79,82 @@ func (db *FileDocDB) PutRaw(collection string, id string, document interface{})
 
 		return err
 	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.putContent(collection, id, content)
+}
+
+//putContent caller must hold the db lock
+func (db *FileDocDB) putContent(collection string, id string, content []byte) error {
+
+	if !isValidCollectionName(collection) {
+
+		return ErrDBEngineInvalidPath
+	}
+	path := fmt.Sprintf("/%s/%s.json", collection, id)
+
+	if err := db.checkUniqueContent(collection, id, content); err != nil {
+
+		return err
+	}
 	return db.fileClient.Write(path, &content)
 }
 
-func (db *FileDocDB) Get(collection string, id string, document interface{}) error {
+//Update apply update operators on document, the document is read, modified and written back while holding the db lock.
+func (db *FileDocDB) Update(collection string, id string, update engine.Update) (err error) {
+
+	_, done := db.observe(context.Background(), "file_docdb", "update", collection, id)
+	defer func() { done(err, 0) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.update(collection, id, update)
+}
+
+//update caller must hold the db lock
+func (db *FileDocDB) update(collection string, id string, update engine.Update) error {
+
+	if update.IsEmpty() {
+
+		return engine.InvalidUpdate
+	}
+	if !isValidCollectionName(collection) {
+
+		return ErrDBEngineInvalidPath
+	}
+	path := fmt.Sprintf("/%s/%s.json", collection, id)
+
+	document := map[string]interface{}{}
+
+	content, err := db.fileClient.Read(path)
+	if err == nil {
+		if err := json.Unmarshal(*content, &document); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := update.Apply(document); err != nil {
+		return err
+	}
+	if err := db.checkUnique(collection, id, document); err != nil {
+		return err
+	}
+	newContent, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return db.fileClient.Write(path, &newContent)
+}
+
+func (db *FileDocDB) Get(collection string, id string, document interface{}) (err error) {
+	_, done := db.observe(context.Background(), "file_docdb", "get", collection, id)
+	defer func() { done(err, documentSize(err)) }()
+	if !isValidCollectionName(collection) {
+		return ErrDBEngineInvalidPath
+	}
 	path := fmt.Sprintf("/%s/%s.json", collection, id)
 	content, err := db.fileClient.Read(path)
 	if err != nil {
@@ -55,8 +163,15 @@ func (db *FileDocDB) Get(collection string, id string, document interface{}) err
 	return json.Unmarshal(*content, document)
 }
 
-func (db *FileDocDB) Del(collection string, id string) error {
+func (db *FileDocDB) Del(collection string, id string) (err error) {
+	_, done := db.observe(context.Background(), "file_docdb", "del", collection, id)
+	defer func() { done(err, 0) }()
+	if !isValidCollectionName(collection) {
+		return ErrDBEngineInvalidPath
+	}
 	path := fmt.Sprintf("/%s/%s.json", collection, id)
+	db.mux.Lock()
+	defer db.mux.Unlock()
 	return db.fileClient.Delete(path)
 }
 
@@ -64,46 +179,578 @@ func (db *FileDocDB) IsNoRecordError(err error) bool {
 	return os.IsNotExist(err)
 }
 
-//all query in transaction must be all done or all fail.
-func (db *FileDocDB) MakeTransaction() engine.DBTransaction {
+//all query in transaction must be all done or all fail. Options are ignored.
+func (db *FileDocDB) MakeTransaction(options ...*engine.TransactionOptions) engine.DBTransaction {
 
 	return &FileDocTransaction{
-		db:    db,
-		items: make([]FileDocDBTransactionItem, 0),
+		db: db,
 	}
 }
 
-//Query query
+//RunInTransaction run fn while holding the db lock, writes of fn are committed with a journal like Commit.
+//Options are ignored.
+func (db *FileDocDB) RunInTransaction(ctx context.Context, fn func(tx engine.DBTransaction) error, options ...*engine.TransactionOptions) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	transaction := &FileDocTransaction{
+		db:     db,
+		locked: true,
+	}
+	if err := fn(transaction); err != nil {
+		return err
+	}
+	return transaction.commit()
+}
+
+//Query query by scanning the collection
 func (db *FileDocDB) Query(query engine.DBQuery) engine.DBQueryResult {
-	log.Panic(engine.NotImplement)
+
+	_, done := db.observe(context.Background(), "file_docdb", "query", query.Collection, "")
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	result := db.query(query)
+	done(result.Error(), int(result.Count()))
+	return result
+}
+
+//query caller must hold the db lock
+func (db *FileDocDB) query(query engine.DBQuery) engine.DBQueryResult {
+
+	documents := []map[string]interface{}{}
+
+	err := db.scan(query, func(id string, path string, document map[string]interface{}) error {
+		documents = append(documents, document)
+		return nil
+	})
+	return makeLocalDocQueryResult(query, documents, err)
+}
+
+//UpdateWhere apply update to all documents that match query by scanning the collection
+func (db *FileDocDB) UpdateWhere(query engine.DBQuery, update engine.Update) (count int64, err error) {
+
+	_, done := db.observe(context.Background(), "file_docdb", "updatewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+
+	if update.IsEmpty() {
+
+		return 0, engine.InvalidUpdate
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	err = db.scan(query, func(id string, path string, document map[string]interface{}) error {
+
+		if err := update.Apply(document); err != nil {
+			return err
+		}
+		if err := db.checkUnique(query.Collection, id, document); err != nil {
+			return err
+		}
+		content, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		if err := db.fileClient.Write(path, &content); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+//DeleteWhere delete all documents that match query by scanning the collection
+func (db *FileDocDB) DeleteWhere(query engine.DBQuery) (count int64, err error) {
+
+	_, done := db.observe(context.Background(), "file_docdb", "deletewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	err = db.scan(query, func(id string, path string, document map[string]interface{}) error {
+
+		if err := db.fileClient.Delete(path); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+//scan call process for every document in query collection that match query condition
+func (db *FileDocDB) scan(query engine.DBQuery, process func(id string, path string, document map[string]interface{}) error) error {
+
+	if !isValidCollectionName(query.Collection) {
+		return ErrDBEngineInvalidPath
+	}
+	ids, err := db.GetAllDocumentIDs(query.Collection)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, id := range ids {
+
+		path := fmt.Sprintf("/%s/%s.json", query.Collection, id)
+
+		content, err := db.fileClient.Read(path)
+		if err != nil {
+			return err
+		}
+		document := map[string]interface{}{}
+		if err := json.Unmarshal(*content, &document); err != nil {
+			return err
+		}
+		if !query.Match(document) {
+			continue
+		}
+		if err := process(id, path, document); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+//statCollection get modified time of all documents in collection
+func (db *FileDocDB) statCollection(collection string) (map[string]time.Time, error) {
+
+	if !isValidCollectionName(collection) {
+		return nil, ErrDBEngineInvalidPath
+	}
+	result := map[string]time.Time{}
+
+	files, err := os.ReadDir(db.GetCollectionPath(collection))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	for _, file := range files {
+
+		fname := file.Name()
+		if file.IsDir() || !strings.HasSuffix(fname, ".json") || len(fname) <= 5 {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		result[fname[:len(fname)-5]] = info.ModTime()
+	}
+	return result, nil
+}
+
+//Watch observe collection by polling modified time of document files every WatchInterval.
+//Resume token is the time of a poll, on resume documents those are modified after the token
+//are reported as update events, deletes happened before resume can not be detected.
+//With a query the documents are read on the first poll, a delete is reported if the document matched on its last read.
+func (db *FileDocDB) Watch(ctx context.Context, collection string, query *engine.DBQuery, resumeToken string) <-chan engine.ChangeEvent {
+
+	events := make(chan engine.ChangeEvent)
+
+	interval := db.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		defer close(events)
+
+		send := func(event engine.ChangeEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		var resumeAfter *time.Time = nil
+		if resumeToken != "" {
+			nano, err := strconv.ParseInt(resumeToken, 10, 64)
+			if err != nil {
+				send(engine.ChangeEvent{Collection: collection, Err: err})
+				return
+			}
+			resumeTime := time.Unix(0, nano)
+			resumeAfter = &resumeTime
+		}
+		known := map[string]time.Time{}
+		//matched ids of documents those matched query when they were read
+		matched := map[string]bool{}
+		isFirst := true
+
+		for {
+			pollTime := time.Now()
+			current, err := db.statCollection(collection)
+			if err != nil {
+				send(engine.ChangeEvent{Collection: collection, Err: err})
+				return
+			}
+			token := strconv.FormatInt(pollTime.UnixNano(), 10)
+
+			ids := []string{}
+			for id := range current {
+				ids = append(ids, id)
+			}
+			for id := range known {
+				if _, ok := current[id]; !ok {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+
+			for _, id := range ids {
+
+				modTime, existed := current[id]
+				lastModTime, wasKnown := known[id]
+				changeType := ""
+
+				if !existed {
+					changeType = engine.ChangeDelete
+				} else if isFirst {
+					if resumeAfter != nil && modTime.After(*resumeAfter) {
+						changeType = engine.ChangeUpdate
+					}
+				} else if !wasKnown {
+					changeType = engine.ChangeInsert
+				} else if !modTime.Equal(lastModTime) {
+					changeType = engine.ChangeUpdate
+				}
+				if changeType == engine.ChangeDelete {
+					if query != nil && !matched[id] {
+						continue
+					}
+					delete(matched, id)
+				}
+				//documents those exist before the watch are read to know if they match query
+				seed := changeType == "" && isFirst && query != nil
+				if changeType == "" && !seed {
+					continue
+				}
+				var document map[string]interface{} = nil
+				if changeType != engine.ChangeDelete {
+					content, err := db.fileClient.Read(fmt.Sprintf("/%s/%s.json", collection, id))
+					if err != nil {
+						if os.IsNotExist(err) {
+							//deleted after stat, it will be reported on next poll
+							if wasKnown {
+								current[id] = lastModTime
+							} else {
+								delete(current, id)
+							}
+							continue
+						}
+						send(engine.ChangeEvent{Collection: collection, Err: err})
+						return
+					}
+					document = map[string]interface{}{}
+					if err := json.Unmarshal(*content, &document); err != nil {
+						send(engine.ChangeEvent{Collection: collection, Err: err})
+						return
+					}
+					if query != nil {
+						if !query.Match(document) {
+							delete(matched, id)
+							continue
+						}
+						matched[id] = true
+					}
+					if seed {
+						continue
+					}
+				}
+				event := engine.MakeChangeEvent(changeType, collection, id, document, nil)
+				event.ResumeToken = token
+				if !send(event) {
+					return
+				}
+			}
+			known = current
+			isFirst = false
+
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+//CleanPagingInfo nothing to clean, paging is computed on every query
 func (db *FileDocDB) CleanPagingInfo(query engine.DBQuery) {
-	log.Panic(engine.NotImplement)
+
 }
 
 //MARK: Work with collection
 func (db *FileDocDB) DelCollection(collection string) error {
-	path := fmt.Sprintf("/%s", collection)
-	return db.fileClient.Delete(path)
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	return db.delCollection(collection)
+}
+
+//isValidCollectionName names those start with a dot are reserved for the journal and index folders
+func isValidCollectionName(collection string) bool {
+	return collection != "" && !strings.HasPrefix(collection, ".") && !strings.ContainsAny(collection, "/\\")
 }
 
+//delCollection caller must hold the db lock
+func (db *FileDocDB) delCollection(collection string) error {
+	if !isValidCollectionName(collection) {
+		return ErrDBEngineInvalidPath
+	}
+	//collection folder is not empty so it can not be deleted by fileClient.Delete
+	if err := os.RemoveAll(db.GetCollectionPath(collection)); err != nil {
+		return err
+	}
+	delete(db.indexes, collection)
+	if err := db.fileClient.Delete(getIndexPath(collection)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//CreateCollection create collection folder
 func (db *FileDocDB) CreateCollection(collection string) error {
-	return engine.NotImplement
+	if !isValidCollectionName(collection) {
+		return ErrDBEngineInvalidPath
+	}
+	return os.MkdirAll(db.GetCollectionPath(collection), 0755)
 }
 
-type FileDocDBTransactionItem struct {
-	command    string
-	collection string
-	document   interface{}
-	id         string
+//MARK: Work with index
+
+//fileDocIndex persistent form of index, partial condition can not be persisted
+type fileDocIndex struct {
+	Name        string              `json:"name"`
+	Fields      []engine.IndexField `json:"fields"`
+	Unique      bool                `json:"unique"`
+	Sparse      bool                `json:"sparse"`
+	TTL         bool                `json:"ttl"`
+	ExpireAfter time.Duration       `json:"expire_after"`
+}
+
+func getIndexPath(collection string) string {
+
+	return fmt.Sprintf("/.indexes/%s.json", collection)
 }
 
-//MongoTransaction apply DBTransaction
+//loadIndexes load indexes of collection from index file, caller must hold the db lock
+func (db *FileDocDB) loadIndexes(collection string) ([]engine.IndexSpec, error) {
+
+	if !isValidCollectionName(collection) {
+		return nil, ErrDBEngineInvalidPath
+	}
+	if db.indexes == nil {
+		db.indexes = map[string][]engine.IndexSpec{}
+	}
+	if indexes, ok := db.indexes[collection]; ok {
+		return indexes, nil
+	}
+	indexes := []engine.IndexSpec{}
+	content, err := db.fileClient.Read(getIndexPath(collection))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		stored := []fileDocIndex{}
+		if err := json.Unmarshal(*content, &stored); err != nil {
+			return nil, err
+		}
+		for _, index := range stored {
+			indexes = append(indexes, engine.IndexSpec{
+				Name:        index.Name,
+				Fields:      index.Fields,
+				Unique:      index.Unique,
+				Sparse:      index.Sparse,
+				TTL:         index.TTL,
+				ExpireAfter: index.ExpireAfter,
+			})
+		}
+	}
+	db.indexes[collection] = indexes
+	return indexes, nil
+}
+
+//saveIndexes write indexes of collection to index file, caller must hold the db lock
+func (db *FileDocDB) saveIndexes(collection string, indexes []engine.IndexSpec) error {
+
+	stored := []fileDocIndex{}
+	for _, index := range indexes {
+		stored = append(stored, fileDocIndex{
+			Name:        index.GetName(),
+			Fields:      index.Fields,
+			Unique:      index.Unique,
+			Sparse:      index.Sparse,
+			TTL:         index.TTL,
+			ExpireAfter: index.ExpireAfter,
+		})
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := db.fileClient.Write(getIndexPath(collection), &content); err != nil {
+		return err
+	}
+	db.indexes[collection] = indexes
+	return nil
+}
+
+//checkUniqueContent check unique indexes for a json encoded document
+func (db *FileDocDB) checkUniqueContent(collection string, id string, content []byte) error {
+
+	indexes, err := db.loadIndexes(collection)
+	if err != nil || len(indexes) == 0 {
+		return err
+	}
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return err
+	}
+	return db.checkUnique(collection, id, document)
+}
+
+//checkUnique scan collection to find another document that has the same key in an unique index, caller must hold the db lock
+func (db *FileDocDB) checkUnique(collection string, id string, document map[string]interface{}) error {
+
+	indexes, err := db.loadIndexes(collection)
+	if err != nil {
+		return err
+	}
+	keys := map[string]string{}
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+		if key, ok := index.Key(document); ok {
+			keys[index.GetName()] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	query := engine.MakeDBQuery(collection, false)
+
+	return db.scan(query, func(otherID string, path string, other map[string]interface{}) error {
+
+		if otherID == id {
+			return nil
+		}
+		for _, index := range indexes {
+			key, ok := keys[index.GetName()]
+			if !ok {
+				continue
+			}
+			if otherKey, ok := index.Key(other); ok && otherKey == key {
+				return fmt.Errorf("%w: %s.%s %s", engine.DuplicateKey, collection, index.GetName(), key)
+			}
+		}
+		return nil
+	})
+}
+
+//EnsureIndex record index in /.indexes folder. Only unique option is honored, partial and ttl index are not supported.
+func (db *FileDocDB) EnsureIndex(collection string, index engine.IndexSpec) error {
+
+	if err := index.Validate(); err != nil {
+		return err
+	}
+	if index.Partial != nil {
+		return fmt.Errorf("%w: file db does not support partial index", engine.InvalidIndex)
+	}
+	if index.TTL {
+		return fmt.Errorf("%w: file db does not expire documents", engine.InvalidIndex)
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	indexes, err := db.loadIndexes(collection)
+	if err != nil {
+		return err
+	}
+	name := index.GetName()
+	for _, existed := range indexes {
+		if existed.GetName() == name {
+			return nil
+		}
+	}
+	if index.Unique {
+		//existing documents must not violate new index
+		keys := map[string]string{}
+		query := engine.MakeDBQuery(collection, false)
+		err := db.scan(query, func(id string, path string, document map[string]interface{}) error {
+			key, ok := index.Key(document)
+			if !ok {
+				return nil
+			}
+			if otherID, existed := keys[key]; existed {
+				return fmt.Errorf("%w: %s.%s %s and %s", engine.DuplicateKey, collection, name, otherID, id)
+			}
+			keys[key] = id
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	newIndexes := append([]engine.IndexSpec{}, indexes...)
+
+	return db.saveIndexes(collection, append(newIndexes, index))
+}
+
+//ListIndexes list indexes of collection
+func (db *FileDocDB) ListIndexes(collection string) ([]engine.IndexSpec, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	indexes, err := db.loadIndexes(collection)
+	if err != nil {
+		return nil, err
+	}
+	return append([]engine.IndexSpec{}, indexes...), nil
+}
+
+//DropIndex remove index from index file
+func (db *FileDocDB) DropIndex(collection string, name string) error {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	indexes, err := db.loadIndexes(collection)
+	if err != nil {
+		return err
+	}
+	remains := []engine.IndexSpec{}
+	for _, index := range indexes {
+		if index.GetName() != name {
+			remains = append(remains, index)
+		}
+	}
+	return db.saveIndexes(collection, remains)
+}
+
+//FileDocTransaction apply DBTransaction
 type FileDocTransaction struct {
-	items []FileDocDBTransactionItem
-	db    *FileDocDB
+	engine.TransactionBuffer
+	db *FileDocDB
+	//locked transaction is run by RunInTransaction which already hold the db lock
+	locked bool
 }
 
 //MARK: MongoTransaction
@@ -113,45 +760,129 @@ func (transaction *FileDocTransaction) Begin() {
 
 }
 
-//Put dbtransaction put
-func (transaction *FileDocTransaction) Put(collection string, document engine.Document) {
+//Get dbtransaction get
+func (transaction *FileDocTransaction) Get(collection string, id string, document interface{}) error {
 
-	transaction.items = append(transaction.items, FileDocDBTransactionItem{command: "put", collection: collection, id: document.GetID(), document: document})
+	return transaction.db.Get(collection, id, document)
 }
-func (transaction *FileDocTransaction) PutRaw(collection string, id string, document interface{}) {
 
-	transaction.items = append(transaction.items, FileDocDBTransactionItem{command: "put", collection: collection, id: id, document: document})
+//Query dbtransaction query
+func (transaction *FileDocTransaction) Query(query engine.DBQuery) engine.DBQueryResult {
+
+	if transaction.locked {
+		return transaction.db.query(query)
+	}
+	return transaction.db.Query(query)
 }
 
-//Del dbtransaction delete
-func (transaction *FileDocTransaction) Del(collection string, id string) {
+//Commit dbtransaction commit. Previous content of all touched documents is written to a journal
+//before applying items, if an item fail every change is rolled back from the journal.
+//A journal left by a crash is rolled back on Init.
+//It does nothing inside RunInTransaction which commit when fn return.
+func (transaction *FileDocTransaction) Commit() (err error) {
 
-	transaction.items = append(transaction.items, FileDocDBTransactionItem{command: "del", collection: collection, id: id})
-}
+	if transaction.locked {
+		return nil
+	}
+	_, done := transaction.db.observe(context.Background(), "file_docdb", "transcommit", "", "")
+	defer func() { done(err, transaction.Len()) }()
 
-//Commit dbtransaction commit
-func (transaction *FileDocTransaction) Commit() error {
+	transaction.db.getLogger().Debug("file transaction commit", "items", transaction.Len())
 
-	now := time.Now()
+	db := transaction.db
 
-	fmt.Println("dbtransaction commit")
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	for _, item := range transaction.items {
+	return transaction.commit()
+}
 
-		if item.command == "put" {
+//commit caller must hold the db lock
+func (transaction *FileDocTransaction) commit() error {
 
-			transaction.db.PutRaw(item.collection, item.id, item.document)
+	if transaction.IsClosed() {
+		return engine.TransactionClosed
+	}
+	db := transaction.db
 
-		} else if item.command == "del" {
+	paths := []string{}
+	for _, item := range transaction.Items() {
+		if !isValidCollectionName(item.Collection) {
+			return ErrDBEngineInvalidPath
+		}
+		switch item.Command {
+		case engine.TransactionCreateCollection:
+			//an empty folder that is left by a rollback does no harm so it is not journaled
+		case engine.TransactionDelCollection:
+			ids, err := db.GetAllDocumentIDs(item.Collection)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, id := range ids {
+				paths = append(paths, fmt.Sprintf("/%s/%s.json", item.Collection, id))
+			}
+			paths = append(paths, getIndexPath(item.Collection))
+		default:
+			paths = append(paths, fmt.Sprintf("/%s/%s.json", item.Collection, item.ID))
+		}
+	}
+	journal, err := db.beginJournal(paths)
+	if err != nil {
+		return err
+	}
+	err = transaction.apply()
 
-			transaction.db.Del(item.collection, item.id)
+	if err != nil {
+		//index files may be restored so cached indexes are reloaded
+		for _, item := range transaction.Items() {
+			delete(db.indexes, item.Collection)
+		}
+		if rollbackErr := db.rollbackJournal(journal); rollbackErr != nil {
+			return fmt.Errorf("%w: %v, rollback fail: %v", ErrFileDocTransactionFail, err, rollbackErr)
 		}
+		return fmt.Errorf("%w: %v", ErrFileDocTransactionFail, err)
 	}
+	return db.endJournal(journal)
+}
+
+//apply caller must hold the db lock
+func (transaction *FileDocTransaction) apply() error {
 
-	if __measurement {
+	db := transaction.db
 
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb transcommit %0.2fms\n", float32(delta)/1_000_000)
+	for _, item := range transaction.Items() {
+
+		if item.Command == engine.TransactionPut {
+
+			content, err := json.Marshal(item.Document)
+			if err != nil {
+				return err
+			}
+			if err := db.putContent(item.Collection, item.ID, content); err != nil {
+				return err
+			}
+		} else if item.Command == engine.TransactionUpdate {
+
+			if err := db.update(item.Collection, item.ID, item.Document.(engine.Update)); err != nil {
+				return err
+			}
+		} else if item.Command == engine.TransactionDel {
+
+			path := fmt.Sprintf("/%s/%s.json", item.Collection, item.ID)
+			if err := db.fileClient.Delete(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		} else if item.Command == engine.TransactionCreateCollection {
+
+			if err := db.CreateCollection(item.Collection); err != nil {
+				return err
+			}
+		} else if item.Command == engine.TransactionDelCollection {
+
+			if err := db.delCollection(item.Collection); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
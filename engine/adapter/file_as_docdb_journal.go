@@ -0,0 +1,123 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+var ErrFileDocTransactionFail = errors.New("file db transaction fail and is rolled back")
+
+//fileDocBackup content of a document before transaction
+type fileDocBackup struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	Content []byte `json:"content"`
+}
+
+//fileDocJournal write ahead journal of a transaction, it is stored in /.journal folder
+type fileDocJournal struct {
+	ID      string          `json:"id"`
+	Backups []fileDocBackup `json:"backups"`
+}
+
+func getJournalPath(id string) string {
+
+	return fmt.Sprintf("/.journal/%s.json", id)
+}
+
+//beginJournal backup documents at paths and write the journal, caller must hold the db lock
+func (db *FileDocDB) beginJournal(paths []string) (*fileDocJournal, error) {
+
+	journal := &fileDocJournal{ID: uuid.New().String(), Backups: []fileDocBackup{}}
+
+	backed := map[string]bool{}
+
+	for _, path := range paths {
+
+		if backed[path] {
+			continue
+		}
+		backed[path] = true
+
+		backup := fileDocBackup{Path: path}
+
+		content, err := db.fileClient.Read(path)
+		if err == nil {
+			backup.Existed = true
+			backup.Content = *content
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		journal.Backups = append(journal.Backups, backup)
+	}
+	content, err := json.Marshal(journal)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.fileClient.Write(getJournalPath(journal.ID), &content); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+//rollbackJournal restore backups then remove the journal, caller must hold the db lock.
+//The journal is kept if a backup can not be restored so it is retried on next Init.
+func (db *FileDocDB) rollbackJournal(journal *fileDocJournal) error {
+
+	for i := len(journal.Backups) - 1; i >= 0; i-- {
+
+		backup := journal.Backups[i]
+
+		if backup.Existed {
+			content := backup.Content
+			if err := db.fileClient.Write(backup.Path, &content); err != nil {
+				return err
+			}
+		} else if err := db.fileClient.Delete(backup.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return db.endJournal(journal)
+}
+
+//endJournal remove the journal, caller must hold the db lock
+func (db *FileDocDB) endJournal(journal *fileDocJournal) error {
+
+	return db.fileClient.Delete(getJournalPath(journal.ID))
+}
+
+//recoverJournals roll back every transaction those were interrupted, caller must hold the db lock
+func (db *FileDocDB) recoverJournals() error {
+
+	files, err := os.ReadDir(db.fileClient.absolutePath + "/.journal")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, file := range files {
+
+		fname := file.Name()
+		if file.IsDir() || len(fname) <= 5 || fname[len(fname)-5:] != ".json" {
+			continue
+		}
+		content, err := db.fileClient.Read("/.journal/" + fname)
+		if err != nil {
+			return err
+		}
+		journal := &fileDocJournal{}
+		if err := json.Unmarshal(*content, journal); err != nil {
+			//journal is written atomically so it is never half written
+			return err
+		}
+		if err := db.rollbackJournal(journal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,13 +2,17 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/tapvanvn/gocondition"
 	"github.com/tapvanvn/godbengine/engine"
 	"google.golang.org/api/iterator"
 	_ "google.golang.org/api/iterator"
@@ -37,9 +41,15 @@ func (client *FirestoreClient) getCollection(collectionName string) *firestore.C
 
 //FirestorePool pool implement DocumentPool
 type FirestorePool struct {
+	loggable
+	observable
 	database        string
 	clients         []*FirestoreClient
 	roundRobinCount int
+	indexes         map[string][]engine.IndexSpec
+	indexMux        sync.Mutex
+	//PingTimeout when it's set Init read a document with every client and fail with ErrConnect if a read fail
+	PingTimeout time.Duration
 }
 
 //First get first client
@@ -56,46 +66,108 @@ func (pool *FirestorePool) SelectRobin() *FirestoreClient {
 	return pool.clients[pool.roundRobinCount]
 }
 
-//Init init pool from connection string
-func (pool *FirestorePool) Init(connectionString string) error {
+//FirestoreProject a project and the credential file of its client, the default credentials are used when CredentialPath is empty
+type FirestoreProject struct {
+	ProjectID      string
+	CredentialPath string
+}
 
-	clients := strings.Split(connectionString, ",")
+//FirestoreOptions typed options of FirestorePool
+type FirestoreOptions struct {
+	Projects []FirestoreProject
+	//PingTimeout override PingTimeout of the pool when it's set
+	PingTimeout time.Duration
+}
 
-	pool.database = "default"
+//Init init pool from connection string projectID:credentialPath,...
+func (pool *FirestorePool) Init(connectionString string) error {
 
-	for _, client := range clients {
+	options := FirestoreOptions{}
 
+	for _, client := range strings.Split(connectionString, ",") {
+
+		client = strings.TrimSpace(client)
 		pos := strings.Index(client, ":")
 		if pos > -1 {
 			projectID := client[:pos]
 			credentialPath := client[pos+1:]
-			firestoreClient, err := firestore.NewClient(context.TODO(), projectID, option.WithCredentialsFile(credentialPath))
-			if err != nil {
-				log.Fatalf("Failed to create client: %v", err)
+			if projectID == "" || credentialPath == "" {
+				return newInvalidConnectionString("firestore", "%s must be projectID:credentialPath", client)
 			}
-
-			engClient := &FirestoreClient{}
-			engClient.init(firestoreClient)
-			pool.clients = append(pool.clients, engClient)
+			options.Projects = append(options.Projects, FirestoreProject{ProjectID: projectID, CredentialPath: credentialPath})
 		} else {
+			options.Projects = append(options.Projects, FirestoreProject{ProjectID: client})
+		}
+	}
+	return pool.InitWithOptions(options)
+}
 
-			projectID := client
+//InitWithOptions init pool from typed options
+func (pool *FirestorePool) InitWithOptions(options FirestoreOptions) error {
 
-			firestoreClient, err := firestore.NewClient(context.TODO(), projectID)
-			if err != nil {
-				log.Fatalf("Failed to create client: %v", err)
-			}
+	if len(options.Projects) == 0 {
+		return newInvalidConnectionString("firestore", "no project")
+	}
+	pool.database = "default"
+
+	for _, project := range options.Projects {
 
-			engClient := &FirestoreClient{}
-			engClient.init(firestoreClient)
-			pool.clients = append(pool.clients, engClient)
+		if project.ProjectID == "" {
+			pool.Close(context.Background())
+			return newInvalidConnectionString("firestore", "empty project id")
+		}
+		clientOptions := []option.ClientOption{}
+		if project.CredentialPath != "" {
+			clientOptions = append(clientOptions, option.WithCredentialsFile(project.CredentialPath))
+		}
+		firestoreClient, err := firestore.NewClient(context.TODO(), project.ProjectID, clientOptions...)
+		if err != nil {
+			pool.Close(context.Background())
+			return newConnectError("firestore", err)
 		}
+
+		engClient := &FirestoreClient{}
+		engClient.init(firestoreClient)
+		pool.clients = append(pool.clients, engClient)
+	}
+	if options.PingTimeout > 0 {
+		pool.PingTimeout = options.PingTimeout
 	}
-	fmt.Println("firestore pool ", len(pool.clients), " clients.")
+	pool.getLogger().Info("firestore pool init", "clients", len(pool.clients))
+
+	return pingOnInit("firestore", pool, pool.PingTimeout)
+}
+
+//Ping read a document that is not existed on every client, firestore has no ping api
+func (pool *FirestorePool) Ping(ctx context.Context) error {
+
+	for _, client := range pool.clients {
+
+		_, err := client.client.Collection("__ping").Doc("__ping").Get(ctx)
+
+		if err != nil && status.Code(err) != codes.NotFound {
 
+			return err
+		}
+	}
 	return nil
 }
 
+//Close close every client
+func (pool *FirestorePool) Close(ctx context.Context) error {
+
+	var firstErr error = nil
+
+	for _, client := range pool.clients {
+
+		if err := client.client.Close(); err != nil && firstErr == nil {
+
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 //MARK: PagingHelper
 type FirestorePagingItem struct {
 	pageSize       int
@@ -313,90 +385,215 @@ func (result FirestoreQueryResult) GetOne(document interface{}) error {
 }
 
 //MARK: Transaction
-type FirestoreTransactionItem struct {
-	command    string
-	collection string
-	document   interface{}
-	id         string
-}
 
 //Begin dbtransaction begin
 func (transaction *FirestoreTransaction) Begin() {
 
 }
 
-//Put dbtransaction put
-func (transaction *FirestoreTransaction) Put(collection string, document engine.Document) {
+//Get dbtransaction get, document is read outside of transaction if it's not run by RunInTransaction
+func (transaction *FirestoreTransaction) Get(collection string, id string, document interface{}) error {
 
-	transaction.items = append(transaction.items, FirestoreTransactionItem{command: "put", collection: collection, document: document, id: document.GetID()})
-}
-func (transaction *FirestoreTransaction) PutRaw(collection string, id string, document interface{}) {
+	col := transaction.client.getCollection(collection)
+
+	var doc *firestore.DocumentSnapshot = nil
+	var err error = nil
 
-	transaction.items = append(transaction.items, FirestoreTransactionItem{command: "put", collection: collection, document: document, id: id})
+	if transaction.fsTransaction != nil {
+		doc, err = transaction.fsTransaction.Get(col.Doc(id))
+	} else {
+		doc, err = col.Doc(id).Get(context.TODO())
+	}
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return engine.NoDocument
+		}
+		return err
+	}
+	return doc.DataTo(document)
 }
 
-//Del dbtransaction delete
-func (transaction *FirestoreTransaction) Del(collection string, id string) {
+//Query dbtransaction query, condition is limited like buildFirestoreQuery and Count is not supported.
+func (transaction *FirestoreTransaction) Query(query engine.DBQuery) engine.DBQueryResult {
 
-	transaction.items = append(transaction.items, FirestoreTransactionItem{command: "del", collection: collection, id: id})
+	ctx := context.TODO()
+	queryResult := &FirestoreQueryResult{SelectOne: query.SelectOne, Ctx: ctx}
+
+	col := transaction.client.getCollection(query.Collection)
+
+	fsQuery, err := transaction.pool.buildFirestoreQuery(col.Query, query.Condition)
+	if err != nil {
+		queryResult.Err = err
+		return queryResult
+	}
+	for _, sort := range query.SortFields {
+
+		if sort.Inscrease {
+			fsQuery = fsQuery.OrderBy(sort.Field, firestore.Asc)
+		} else {
+			fsQuery = fsQuery.OrderBy(sort.Field, firestore.Desc)
+		}
+	}
+	if query.SelectOne {
+
+		fsQuery = fsQuery.Limit(1)
+
+	} else if paging := query.GetPaging(); paging != nil && paging.PageSize > 0 {
+
+		fsQuery = fsQuery.Offset(paging.PageNum * paging.PageSize).Limit(paging.PageSize)
+	}
+	if transaction.fsTransaction != nil {
+		queryResult.Iter = transaction.fsTransaction.Documents(fsQuery)
+	} else {
+		queryResult.Iter = fsQuery.Documents(ctx)
+	}
+	queryResult.isAvailable = true
+	return queryResult
 }
 
-//Commit dbtransaction commit
-func (transaction *FirestoreTransaction) Commit() error {
-	now := time.Now()
+//Commit dbtransaction commit, it does nothing inside RunInTransaction which commit when fn return
+func (transaction *FirestoreTransaction) Commit() (err error) {
+
+	if transaction.fsTransaction != nil {
+
+		return nil
+	}
+	if transaction.IsClosed() {
+
+		return engine.TransactionClosed
+	}
+	ctx, done := transaction.pool.observe(context.Background(), "firestore", "transcommit", "", "")
+	defer func() { done(err, transaction.Len()) }()
+
 	batch := transaction.client.client.Batch()
 
-	ctx := context.Background()
+	if transaction.options.Timeout > 0 {
+
+		var cancel context.CancelFunc = nil
+		ctx, cancel = context.WithTimeout(ctx, transaction.options.Timeout)
+		defer cancel()
+	}
+
+	err = transaction.apply(func(col *firestore.CollectionRef) ([]*firestore.DocumentRef, error) {
+		return col.DocumentRefs(ctx).GetAll()
+	}, func(doc *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+		batch.Set(doc, data, opts...)
+		return nil
+	}, func(doc *firestore.DocumentRef) error {
+		batch.Delete(doc)
+		return nil
+	})
+	if err != nil {
+
+		return err
+	}
+	_, err = batch.Commit(ctx)
+	return err
+}
+
+//ErrFirestoreTooManyWrites firestore commit at most __firestore_batch_limit writes atomically, deleting a collection count one write per document
+var ErrFirestoreTooManyWrites = errors.New("firestore transaction has too many writes")
+
+//apply write items by set and del, so they can be written to a batch or a transaction.
+//Firestore has no collection drop so documents of deleted collections are listed by list and deleted one by one,
+//every list is done before the first write as firestore transaction require.
+func (transaction *FirestoreTransaction) apply(list func(col *firestore.CollectionRef) ([]*firestore.DocumentRef, error), set func(doc *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error, del func(doc *firestore.DocumentRef) error) error {
 
-	for _, item := range transaction.items {
+	collectionDocs := map[string][]*firestore.DocumentRef{}
+	numWrite := 0
 
-		col := transaction.client.getCollection(item.collection)
+	for _, item := range transaction.Items() {
+
+		switch item.Command {
+		case engine.TransactionCreateCollection:
+		case engine.TransactionDelCollection:
+			if _, ok := collectionDocs[item.Collection]; !ok {
+				docs, err := list(transaction.client.getCollection(item.Collection))
+				if err != nil {
+					return err
+				}
+				collectionDocs[item.Collection] = docs
+			}
+			numWrite += len(collectionDocs[item.Collection])
+		default:
+			numWrite++
+		}
+	}
+	if numWrite > __firestore_batch_limit {
+
+		return fmt.Errorf("%w: %d writes", ErrFirestoreTooManyWrites, numWrite)
+	}
+	for _, item := range transaction.Items() {
+
+		col := transaction.client.getCollection(item.Collection)
 
 		if col == nil {
 
 			return errors.New("get collection fail")
 		}
 
-		if item.command == "put" {
+		if item.Command == engine.TransactionPut {
+
+			if err := set(col.Doc(item.ID), item.Document); err != nil {
+
+				return err
+			}
+		} else if item.Command == engine.TransactionUpdate {
+
+			update := item.Document.(engine.Update)
+
+			data, paths, err := buildFirestoreUpdate(&update)
+			if err != nil {
+
+				return err
+			}
+			if err := set(col.Doc(item.ID), data, firestore.Merge(paths...)); err != nil {
+
+				return err
+			}
+		} else if item.Command == engine.TransactionDel {
 
-			batch.Set(col.Doc(item.id), item.document)
+			if err := del(col.Doc(item.ID)); err != nil {
 
-		} else if item.command == "del" {
+				return err
+			}
+		} else if item.Command == engine.TransactionDelCollection {
 
-			batch.Delete(col.Doc(item.id))
+			for _, doc := range collectionDocs[item.Collection] {
 
-		} else if item.command == "del_collection" {
+				if err := del(doc); err != nil {
 
-			return engine.NotImplement
+					return err
+				}
+			}
 		}
 	}
-	_, err := batch.Commit(ctx)
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb transcommit %0.2fms\n", float32(delta)/1_000_000)
-	}
-	return err
+	return nil
 }
 
 //MARK: Pool
 
 //FirestoreTransaction apply DBTransaction
 type FirestoreTransaction struct {
+	engine.TransactionBuffer
 	database string
+	pool     *FirestorePool
 	client   *FirestoreClient
-	items    []FirestoreTransactionItem
+	options  engine.TransactionOptions
+	//fsTransaction is set when transaction is run by RunInTransaction
+	fsTransaction *firestore.Transaction
 }
 
 //Get get document
-func (pool *FirestorePool) Get(collection string, id string, document interface{}) error {
+func (pool *FirestorePool) Get(collection string, id string, document interface{}) (err error) {
 
-	now := time.Now()
+	ctx, done := pool.observe(context.Background(), "firestore", "get", collection, id)
+	defer func() { done(err, documentSize(err)) }()
 	col := pool.First().getCollection(collection)
 
 	if col == nil {
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
 	doc, err := col.Doc(id).Get(ctx)
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
@@ -406,10 +603,6 @@ func (pool *FirestorePool) Get(collection string, id string, document interface{
 		}
 	}
 	doc.DataTo(document)
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb get %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
-	}
 	return nil
 }
 
@@ -419,44 +612,117 @@ func (pool *FirestorePool) Put(collection string, document engine.Document) erro
 	return pool.PutRaw(collection, document.GetID(), document)
 }
 
-func (pool *FirestorePool) PutRaw(collection string, id string, document interface{}) error {
-	now := time.Now()
+func (pool *FirestorePool) PutRaw(collection string, id string, document interface{}) (err error) {
+	ctx, done := pool.observe(context.Background(), "firestore", "put", collection, id)
+	defer func() { done(err, 0) }()
 	col := pool.First().getCollection(collection)
 	if col == nil {
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
-	_, err := col.Doc(id).Set(ctx, document)
+	_, err = col.Doc(id).Set(ctx, document)
 	if err != nil {
 		return err
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb put %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
-	}
 	return nil
 }
 
 //Del delete document
-func (pool *FirestorePool) Del(collection string, id string) error {
-	now := time.Now()
+func (pool *FirestorePool) Del(collection string, id string) (err error) {
+	ctx, done := pool.observe(context.Background(), "firestore", "del", collection, id)
+	defer func() { done(err, 0) }()
 	col := pool.First().getCollection(collection)
 
 	if col == nil {
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
-	_, err := col.Doc(id).Delete(ctx)
+	_, err = col.Doc(id).Delete(ctx)
 	if err != nil {
 		return err
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb del %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
+	return nil
+}
+
+//Update apply update operators on document
+func (pool *FirestorePool) Update(collection string, id string, update engine.Update) (err error) {
+	ctx, done := pool.observe(context.Background(), "firestore", "update", collection, id)
+	defer func() { done(err, 0) }()
+	col := pool.First().getCollection(collection)
+	if col == nil {
+		return errors.New("get collection fail")
+	}
+	data, paths, err := buildFirestoreUpdate(&update)
+	if err != nil {
+		return err
+	}
+	//Set with merge paths instead of DocumentRef.Update so the document is created if it's not existed
+	_, err = col.Doc(id).Set(ctx, data, firestore.Merge(paths...))
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
+//buildFirestoreUpdate convert update to data and field paths for Set with merge.
+//Firestore has no transform that allow duplicated element so push return NotImplement.
+func buildFirestoreUpdate(update *engine.Update) (map[string]interface{}, []firestore.FieldPath, error) {
+
+	if update.IsEmpty() {
+
+		return nil, nil, engine.InvalidUpdate
+	}
+	data := map[string]interface{}{}
+	paths := []firestore.FieldPath{}
+
+	for _, operation := range update.Operations {
+
+		var value interface{} = nil
+
+		switch operation.Operator {
+		case engine.UpdateSet:
+			value = operation.Value
+		case engine.UpdateUnset:
+			value = firestore.Delete
+		case engine.UpdateInc:
+			value = firestore.Increment(operation.Value)
+		case engine.UpdatePush:
+			return nil, nil, fmt.Errorf("%w: push on firestore, use add to set", engine.NotImplement)
+		case engine.UpdateAddToSet:
+			value = firestore.ArrayUnion(operation.Value)
+		case engine.UpdatePull:
+			value = firestore.ArrayRemove(operation.Value)
+		case engine.UpdateMin:
+			value = firestore.FieldTransformMinimum(operation.Value)
+		case engine.UpdateMax:
+			value = firestore.FieldTransformMaximum(operation.Value)
+		case engine.UpdateCurrentDate:
+			value = firestore.ServerTimestamp
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown operator %s", engine.InvalidUpdate, operation.Operator)
+		}
+		path := firestore.FieldPath(strings.Split(operation.Field, "."))
+		current := data
+		for _, part := range path[:len(path)-1] {
+			child, ok := current[part]
+			if !ok {
+				child = map[string]interface{}{}
+				current[part] = child
+			}
+			childMap, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: conflict field %s", engine.InvalidUpdate, operation.Field)
+			}
+			current = childMap
+		}
+		last := path[len(path)-1]
+		if _, ok := current[last]; ok {
+			return nil, nil, fmt.Errorf("%w: conflict field %s", engine.InvalidUpdate, operation.Field)
+		}
+		current[last] = value
+		paths = append(paths, path)
+	}
+	return data, paths, nil
+}
+
 type FirestoreQueryItem struct {
 	isComplete bool
 	fsquery    firestore.Query
@@ -511,6 +777,125 @@ func (pool *FirestorePool) fetchQueryWithOutPaging(query engine.DBQuery) (firest
 	return fsQuery, true
 }
 
+//buildFirestoreQuery translate query condition to firestore query.
+//Firestore only support "and" of simple filters so "or" rule set and +=, +<, +>, regex operators are rejected.
+func (pool *FirestorePool) buildFirestoreQuery(fsQuery firestore.Query, ruleSet *gocondition.RuleSet) (firestore.Query, error) {
+
+	if ruleSet == nil {
+
+		return fsQuery, nil
+	}
+	if ruleSet.IsOr() && len(ruleSet.Children) > 1 {
+
+		return fsQuery, fmt.Errorf("%w: firestore does not support or condition", engine.InvalidQuery)
+	}
+	for _, child := range ruleSet.Children {
+
+		switch filterItem := child.(type) {
+		case *engine.DBFilterItem:
+			switch filterItem.Operator {
+			case "=":
+				fsQuery = fsQuery.Where(filterItem.Field, "==", filterItem.FieldValue)
+			case "!=", ">", ">=", "<", "<=", "in":
+				fsQuery = fsQuery.Where(filterItem.Field, filterItem.Operator, filterItem.FieldValue)
+			default:
+				return fsQuery, fmt.Errorf("%w: firestore does not support operator %s", engine.InvalidQuery, filterItem.Operator)
+			}
+		case *gocondition.RuleSet:
+			var err error = nil
+			if fsQuery, err = pool.buildFirestoreQuery(fsQuery, filterItem); err != nil {
+				return fsQuery, err
+			}
+		default:
+			return fsQuery, engine.InvalidQuery
+		}
+	}
+	return fsQuery, nil
+}
+
+//__firestore_batch_limit maximum number of writes in one batch
+const __firestore_batch_limit = 500
+
+//MaxBatchSize firestore limit a batch to 500 writes
+func (pool *FirestorePool) MaxBatchSize() int {
+
+	return __firestore_batch_limit
+}
+
+//writeWhere call write on a batch for every document that match query, batches are committed every __firestore_batch_limit writes.
+//Documents in committed batches stay written if a later batch fail.
+func (pool *FirestorePool) writeWhere(query engine.DBQuery, write func(batch *firestore.WriteBatch, doc *firestore.DocumentRef)) (int64, error) {
+
+	client := pool.First()
+	col := client.getCollection(query.Collection)
+	if col == nil {
+		return 0, errors.New("get collection fail")
+	}
+	fsQuery, err := pool.buildFirestoreQuery(col.Query, query.Condition)
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.TODO()
+	iter := fsQuery.Select().Documents(ctx)
+	defer iter.Stop()
+
+	count := int64(0)
+	pending := 0
+	batch := client.client.Batch()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		write(batch, doc.Ref)
+		pending++
+
+		if pending == __firestore_batch_limit {
+			if _, err := batch.Commit(ctx); err != nil {
+				return count, err
+			}
+			count += int64(pending)
+			pending = 0
+			batch = client.client.Batch()
+		}
+	}
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			return count, err
+		}
+		count += int64(pending)
+	}
+	return count, nil
+}
+
+//UpdateWhere apply update to all documents that match query
+func (pool *FirestorePool) UpdateWhere(query engine.DBQuery, update engine.Update) (count int64, err error) {
+	_, done := pool.observe(context.Background(), "firestore", "updatewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+	data, paths, err := buildFirestoreUpdate(&update)
+	if err != nil {
+		return 0, err
+	}
+	count, err = pool.writeWhere(query, func(batch *firestore.WriteBatch, doc *firestore.DocumentRef) {
+		batch.Set(doc, data, firestore.Merge(paths...))
+	})
+	return count, err
+}
+
+//DeleteWhere delete all documents that match query
+func (pool *FirestorePool) DeleteWhere(query engine.DBQuery) (count int64, err error) {
+	_, done := pool.observe(context.Background(), "firestore", "deletewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+	count, err = pool.writeWhere(query, func(batch *firestore.WriteBatch, doc *firestore.DocumentRef) {
+		batch.Delete(doc)
+	})
+	return count, err
+}
+
 //Query query document
 func (pool *FirestorePool) Query(query engine.DBQuery) engine.DBQueryResult {
 	//now := time.Now()
@@ -574,6 +959,101 @@ func (pool *FirestorePool) Query(query engine.DBQuery) engine.DBQueryResult {
 	return queryResult
 }
 
+//Watch observe collection with firestore snapshot listener, query is applied natively.
+//Resume token is the read time of a snapshot, firestore can not replay deletes so
+//on resume only documents those are updated after the token are reported as update events.
+//Delete event is also sent when a document stop matching query.
+func (pool *FirestorePool) Watch(ctx context.Context, collection string, query *engine.DBQuery, resumeToken string) <-chan engine.ChangeEvent {
+
+	events := make(chan engine.ChangeEvent)
+
+	go func() {
+		defer close(events)
+
+		fail := func(err error) {
+			event := engine.ChangeEvent{Collection: collection, Err: err}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+		send := func(event engine.ChangeEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		var resumeAfter *time.Time = nil
+		if resumeToken != "" {
+			nano, err := strconv.ParseInt(resumeToken, 10, 64)
+			if err != nil {
+				fail(err)
+				return
+			}
+			resumeTime := time.Unix(0, nano)
+			resumeAfter = &resumeTime
+		}
+		col := pool.First().getCollection(collection)
+		if col == nil {
+			fail(errors.New("get collection fail"))
+			return
+		}
+		fsQuery := col.Query
+		if query != nil {
+			var err error = nil
+			if fsQuery, err = pool.buildFirestoreQuery(fsQuery, query.Condition); err != nil {
+				fail(err)
+				return
+			}
+		}
+		iter := fsQuery.Snapshots(ctx)
+		defer iter.Stop()
+
+		isFirst := true
+		for {
+			snapshot, err := iter.Next()
+			if err != nil {
+				if ctx.Err() == nil {
+					fail(err)
+				}
+				return
+			}
+			token := strconv.FormatInt(snapshot.ReadTime.UnixNano(), 10)
+
+			for _, change := range snapshot.Changes {
+
+				doc := change.Doc
+				changeType := engine.ChangeUpdate
+
+				if isFirst {
+					//first snapshot contain all current documents
+					if resumeAfter == nil || !doc.UpdateTime.After(*resumeAfter) {
+						continue
+					}
+				} else if change.Kind == firestore.DocumentAdded {
+					changeType = engine.ChangeInsert
+				} else if change.Kind == firestore.DocumentRemoved {
+					changeType = engine.ChangeDelete
+				}
+				var document map[string]interface{} = nil
+				if changeType != engine.ChangeDelete {
+					document = doc.Data()
+				}
+				event := engine.MakeChangeEvent(changeType, collection, doc.Ref.ID, document, doc.DataTo)
+				event.ResumeToken = token
+
+				if !send(event) {
+					return
+				}
+			}
+			isFirst = false
+		}
+	}()
+	return events
+}
+
 func (pool *FirestorePool) CleanPagingInfo(query engine.DBQuery) {
 	if query.GetPaging() != nil {
 		helperItem := getPagingHelper(query)
@@ -587,21 +1067,88 @@ func (pool *FirestorePool) IsNoRecordError(err error) bool {
 	return err == engine.NoDocument
 }
 
-//MakeTransaction create new transaction
-func (pool *FirestorePool) MakeTransaction() engine.DBTransaction {
+//IsTransientError check if err has a grpc code that google recommend to retry
+func (pool *FirestorePool) IsTransientError(err error) bool {
+
+	if err == nil || err == engine.NoDocument {
+
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+//MakeTransaction create new transaction, firestore has no read concern or write concern so only Timeout of options is used
+func (pool *FirestorePool) MakeTransaction(options ...*engine.TransactionOptions) engine.DBTransaction {
 
 	trans := FirestoreTransaction{client: pool.SelectRobin(),
-		items: make([]FirestoreTransactionItem, 0)}
+		pool:    pool,
+		options: engine.MergeTransactionOptions(options...)}
 
 	return &trans
 }
 
+//RunInTransaction run fn inside a firestore transaction, firestore retry fn when the transaction is aborted by contention.
+//Writes are buffered until fn return so firestore rule that reads come before writes is always kept.
+//Only Timeout of options is used.
+func (pool *FirestorePool) RunInTransaction(ctx context.Context, fn func(tx engine.DBTransaction) error, options ...*engine.TransactionOptions) error {
+
+	transactionOptions := engine.MergeTransactionOptions(options...)
+
+	if transactionOptions.Timeout > 0 {
+
+		var cancel context.CancelFunc = nil
+		ctx, cancel = context.WithTimeout(ctx, transactionOptions.Timeout)
+		defer cancel()
+	}
+	client := pool.SelectRobin()
+
+	return client.client.RunTransaction(ctx, func(ctx context.Context, fsTransaction *firestore.Transaction) error {
+
+		transaction := &FirestoreTransaction{client: client,
+			pool:          pool,
+			options:       transactionOptions,
+			fsTransaction: fsTransaction}
+
+		if err := fn(transaction); err != nil {
+
+			return err
+		}
+		if transaction.IsClosed() {
+
+			return engine.TransactionClosed
+		}
+		list := func(col *firestore.CollectionRef) ([]*firestore.DocumentRef, error) {
+			docs, err := fsTransaction.Documents(col.Select()).GetAll()
+			if err != nil {
+				return nil, err
+			}
+			refs := []*firestore.DocumentRef{}
+			for _, doc := range docs {
+				refs = append(refs, doc.Ref)
+			}
+			return refs, nil
+		}
+		return transaction.apply(list, fsTransaction.Set, func(doc *firestore.DocumentRef) error {
+			return fsTransaction.Delete(doc)
+		})
+	})
+}
+
 //TODO: Work on paging helper refresh cache system.
 
 //MARK: Woking with collection
+//DelCollection delete every document of collection in batches, batches those were committed stay deleted if a later one fail.
+//Use DBTransaction.DelCollection to delete a small collection atomically.
 func (pool *FirestorePool) DelCollection(collection string) error {
 
-	return engine.NotImplement
+	_, err := pool.writeWhere(engine.MakeDBQuery(collection, false), func(batch *firestore.WriteBatch, doc *firestore.DocumentRef) {
+		batch.Delete(doc)
+	})
+	return err
 }
 func (pool *FirestorePool) CreateCollection(collection string) error {
 
@@ -609,6 +1156,142 @@ func (pool *FirestorePool) CreateCollection(collection string) error {
 	return nil
 }
 
+//MARK: Work with index
+
+//EnsureIndex validate index and record it so it can be exported by IndexesJSON.
+//Firestore indexes are deployed with firebase cli, they can not be created from client library.
+//Unique and partial index are not supported. Firestore indexes are sparse by nature.
+//TTL index must have ExpireAfter 0 because firestore delete document at the time stored in the field.
+func (pool *FirestorePool) EnsureIndex(collection string, index engine.IndexSpec) error {
+
+	if err := index.Validate(); err != nil {
+		return err
+	}
+	if index.Unique {
+		return fmt.Errorf("%w: firestore does not support unique index", engine.InvalidIndex)
+	}
+	if index.Partial != nil {
+		return fmt.Errorf("%w: firestore does not support partial index", engine.InvalidIndex)
+	}
+	if index.TTL && index.ExpireAfter != 0 {
+		return fmt.Errorf("%w: firestore ttl index must have ExpireAfter 0", engine.InvalidIndex)
+	}
+	pool.indexMux.Lock()
+	defer pool.indexMux.Unlock()
+
+	if pool.indexes == nil {
+		pool.indexes = map[string][]engine.IndexSpec{}
+	}
+	name := index.GetName()
+	for _, existed := range pool.indexes[collection] {
+		if existed.GetName() == name {
+			return nil
+		}
+	}
+	pool.indexes[collection] = append(pool.indexes[collection], index)
+	return nil
+}
+
+//ListIndexes list indexes those are recorded by EnsureIndex
+func (pool *FirestorePool) ListIndexes(collection string) ([]engine.IndexSpec, error) {
+
+	pool.indexMux.Lock()
+	defer pool.indexMux.Unlock()
+
+	indexes := []engine.IndexSpec{}
+	indexes = append(indexes, pool.indexes[collection]...)
+	return indexes, nil
+}
+
+//DropIndex remove a recorded index
+func (pool *FirestorePool) DropIndex(collection string, name string) error {
+
+	pool.indexMux.Lock()
+	defer pool.indexMux.Unlock()
+
+	if _, ok := pool.indexes[collection]; !ok {
+		return nil
+	}
+	remains := []engine.IndexSpec{}
+	for _, index := range pool.indexes[collection] {
+		if index.GetName() != name {
+			remains = append(remains, index)
+		}
+	}
+	pool.indexes[collection] = remains
+	return nil
+}
+
+type firestoreIndexField struct {
+	FieldPath string `json:"fieldPath"`
+	Order     string `json:"order"`
+}
+
+type firestoreIndex struct {
+	CollectionGroup string                `json:"collectionGroup"`
+	QueryScope      string                `json:"queryScope"`
+	Fields          []firestoreIndexField `json:"fields"`
+}
+
+type firestoreFieldOverride struct {
+	CollectionGroup string           `json:"collectionGroup"`
+	FieldPath       string           `json:"fieldPath"`
+	TTL             bool             `json:"ttl"`
+	Indexes         []firestoreIndex `json:"indexes"`
+}
+
+type firestoreIndexes struct {
+	Indexes        []firestoreIndex         `json:"indexes"`
+	FieldOverrides []firestoreFieldOverride `json:"fieldOverrides"`
+}
+
+//IndexesJSON emit recorded indexes in firestore.indexes.json format.
+//Single field indexes are created automatically by firestore so only composite indexes and ttl policies are emitted.
+func (pool *FirestorePool) IndexesJSON() ([]byte, error) {
+
+	pool.indexMux.Lock()
+	defer pool.indexMux.Unlock()
+
+	result := firestoreIndexes{
+		Indexes:        []firestoreIndex{},
+		FieldOverrides: []firestoreFieldOverride{},
+	}
+	collections := []string{}
+	for collection := range pool.indexes {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	for _, collection := range collections {
+
+		for _, index := range pool.indexes[collection] {
+
+			if index.TTL {
+				result.FieldOverrides = append(result.FieldOverrides, firestoreFieldOverride{
+					CollectionGroup: collection,
+					FieldPath:       index.Fields[0].Field,
+					TTL:             true,
+					Indexes:         []firestoreIndex{},
+				})
+				continue
+			}
+			if len(index.Fields) < 2 {
+				continue
+			}
+			fsIndex := firestoreIndex{CollectionGroup: collection, QueryScope: "COLLECTION"}
+			for _, field := range index.Fields {
+				order := "ASCENDING"
+				if !field.Inscrease {
+					order = "DESCENDING"
+				}
+				fsIndex.Fields = append(fsIndex.Fields, firestoreIndexField{FieldPath: field.Field, Order: order})
+			}
+			result.Indexes = append(result.Indexes, fsIndex)
+		}
+	}
+	return json.MarshalIndent(result, "", "  ")
+}
+
 func (pool *FirestorePool) CollectVaryInt(collection string, field string) (map[string]int, error) {
 	return nil, engine.NotImplement
 }
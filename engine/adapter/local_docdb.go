@@ -0,0 +1,892 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/tapvanvn/godbengine/engine"
+)
+
+// This LocalDocDB design for testing on local only. On production or multiple user system considering using others.
+// Documents are stored as json so they are decoded the same way as FileDocDB.
+type LocalDocDB struct {
+	observable
+	collections map[string]map[string][]byte
+	indexes     map[string][]engine.IndexSpec
+	mux         sync.Mutex
+	watchers    []*localDocWatcher
+	pending     []localChange
+	history     []localChange
+	sequence    int64
+}
+
+//__local_doc_history number of recent change events those can be resumed
+const __local_doc_history = 1024
+
+var ErrResumeTokenExpired = errors.New("resume token is expired")
+
+//Init init pool, connection string is ignored
+func (db *LocalDocDB) Init(connectionString string) error {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	db.collections = map[string]map[string][]byte{}
+	db.indexes = map[string][]engine.IndexSpec{}
+	return nil
+}
+
+//Ping local db is always reachable
+func (db *LocalDocDB) Ping(ctx context.Context) error {
+
+	return nil
+}
+
+//Close nothing to release, watchers are stopped by their contexts
+func (db *LocalDocDB) Close(ctx context.Context) error {
+
+	return nil
+}
+
+//getCollection get collection, caller must hold the db lock
+func (db *LocalDocDB) getCollection(collection string, create bool) map[string][]byte {
+
+	col, ok := db.collections[collection]
+	if !ok && create {
+		col = map[string][]byte{}
+		db.collections[collection] = col
+	}
+	return col
+}
+
+func decodeLocalDocument(content []byte) (map[string]interface{}, error) {
+
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+//Put insert a document
+func (db *LocalDocDB) Put(collection string, document engine.Document) error {
+
+	return db.PutRaw(collection, document.GetID(), document)
+}
+
+func (db *LocalDocDB) PutRaw(collection string, id string, document interface{}) (err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "put", collection, id)
+	defer func() { done(err, 0) }()
+
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	return db.putContent(collection, id, content)
+}
+
+//putContent caller must hold the db lock
+func (db *LocalDocDB) putContent(collection string, id string, content []byte) error {
+
+	if len(db.indexes[collection]) > 0 {
+
+		document, err := decodeLocalDocument(content)
+		if err != nil {
+			return err
+		}
+		if err := db.checkUnique(collection, id, document); err != nil {
+			return err
+		}
+	}
+	col := db.getCollection(collection, true)
+	changeType := engine.ChangeInsert
+	if _, existed := col[id]; existed {
+		changeType = engine.ChangeUpdate
+	}
+	col[id] = content
+	db.record(changeType, collection, id, content, nil)
+	return nil
+}
+
+func (db *LocalDocDB) Get(collection string, id string, document interface{}) (err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "get", collection, id)
+	defer func() { done(err, documentSize(err)) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.get(collection, id, document)
+}
+
+//get caller must hold the db lock
+func (db *LocalDocDB) get(collection string, id string, document interface{}) error {
+
+	content, ok := db.getCollection(collection, false)[id]
+	if !ok {
+		return engine.NoDocument
+	}
+	return json.Unmarshal(content, document)
+}
+
+func (db *LocalDocDB) Del(collection string, id string) (err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "del", collection, id)
+	defer func() { done(err, 0) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	db.delete(collection, id)
+	return nil
+}
+
+//delete caller must hold the db lock
+func (db *LocalDocDB) delete(collection string, id string) {
+
+	col := db.getCollection(collection, false)
+	if before, existed := col[id]; existed {
+		delete(col, id)
+		db.record(engine.ChangeDelete, collection, id, nil, before)
+	}
+}
+
+//Update apply update operators on document
+func (db *LocalDocDB) Update(collection string, id string, update engine.Update) (err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "update", collection, id)
+	defer func() { done(err, 0) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	return db.update(collection, id, update)
+}
+
+//update caller must hold the db lock
+func (db *LocalDocDB) update(collection string, id string, update engine.Update) error {
+
+	if update.IsEmpty() {
+		return engine.InvalidUpdate
+	}
+	document := map[string]interface{}{}
+
+	if content, ok := db.getCollection(collection, false)[id]; ok {
+		var err error = nil
+		if document, err = decodeLocalDocument(content); err != nil {
+			return err
+		}
+	}
+	if err := update.Apply(document); err != nil {
+		return err
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return db.putContent(collection, id, content)
+}
+
+func (db *LocalDocDB) IsNoRecordError(err error) bool {
+
+	return err == engine.NoDocument
+}
+
+//MakeTransaction create new transaction, options are ignored because items are applied at once while holding the db lock
+func (db *LocalDocDB) MakeTransaction(options ...*engine.TransactionOptions) engine.DBTransaction {
+
+	return &LocalDocTransaction{
+		db: db,
+	}
+}
+
+//RunInTransaction run fn while holding the db lock so reads and writes of fn are isolated from other callers.
+//Options are ignored.
+func (db *LocalDocDB) RunInTransaction(ctx context.Context, fn func(tx engine.DBTransaction) error, options ...*engine.TransactionOptions) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	transaction := &LocalDocTransaction{
+		db:     db,
+		locked: true,
+	}
+	if err := fn(transaction); err != nil {
+		return err
+	}
+	return transaction.commit()
+}
+
+//scan call process for every document in collection that match query condition, caller must hold the db lock
+func (db *LocalDocDB) scan(query engine.DBQuery, process func(id string, document map[string]interface{}) error) error {
+
+	for id, content := range db.getCollection(query.Collection, false) {
+
+		document, err := decodeLocalDocument(content)
+		if err != nil {
+			return err
+		}
+		if !query.Match(document) {
+			continue
+		}
+		if err := process(id, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Query query
+func (db *LocalDocDB) Query(query engine.DBQuery) engine.DBQueryResult {
+
+	_, done := db.observe(context.Background(), "local_docdb", "query", query.Collection, "")
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	result := db.query(query)
+	done(result.Err, int(result.Total))
+	return result
+}
+
+//query caller must hold the db lock
+func (db *LocalDocDB) query(query engine.DBQuery) *LocalDocQueryResult {
+
+	documents := []map[string]interface{}{}
+
+	err := db.scan(query, func(id string, document map[string]interface{}) error {
+		documents = append(documents, document)
+		return nil
+	})
+	return makeLocalDocQueryResult(query, documents, err)
+}
+
+//makeLocalDocQueryResult sort and page documents those match query
+func makeLocalDocQueryResult(query engine.DBQuery, documents []map[string]interface{}, err error) *LocalDocQueryResult {
+
+	result := &LocalDocQueryResult{SelectOne: query.SelectOne, isAvailable: true, Err: err}
+
+	if result.Err != nil {
+		return result
+	}
+	query.SortDocuments(documents)
+
+	result.Total = int64(len(documents))
+
+	if query.SelectOne {
+		if len(documents) == 0 {
+			result.Err = engine.NoDocument
+		} else {
+			documents = documents[:1]
+		}
+	} else if paging := query.GetPaging(); paging != nil && paging.PageSize > 0 {
+		begin := paging.PageNum * paging.PageSize
+		end := begin + paging.PageSize
+		if begin > len(documents) {
+			begin = len(documents)
+		}
+		if end > len(documents) {
+			end = len(documents)
+		}
+		documents = documents[begin:end]
+	}
+	result.documents = documents
+	return result
+}
+
+//CleanPagingInfo nothing to clean on local db
+func (db *LocalDocDB) CleanPagingInfo(query engine.DBQuery) {
+
+}
+
+//UpdateWhere apply update to all documents that match query
+func (db *LocalDocDB) UpdateWhere(query engine.DBQuery, update engine.Update) (count int64, err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "updatewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	ids := []string{}
+	err = db.scan(query, func(id string, document map[string]interface{}) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := db.update(query.Collection, id, update); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+//DeleteWhere delete all documents that match query
+func (db *LocalDocDB) DeleteWhere(query engine.DBQuery) (count int64, err error) {
+
+	_, done := db.observe(context.Background(), "local_docdb", "deletewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	ids := []string{}
+	err = db.scan(query, func(id string, document map[string]interface{}) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		db.delete(query.Collection, id)
+	}
+	return int64(len(ids)), nil
+}
+
+//MARK: Work with collection
+func (db *LocalDocDB) CreateCollection(collection string) error {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	db.getCollection(collection, true)
+	return nil
+}
+
+func (db *LocalDocDB) DelCollection(collection string) error {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	db.delCollection(collection)
+	return nil
+}
+
+//delCollection caller must hold the db lock
+func (db *LocalDocDB) delCollection(collection string) {
+
+	for id, before := range db.getCollection(collection, false) {
+		db.record(engine.ChangeDelete, collection, id, nil, before)
+	}
+	delete(db.collections, collection)
+	delete(db.indexes, collection)
+}
+
+//MARK: Work with index
+
+//checkUnique caller must hold the db lock
+func (db *LocalDocDB) checkUnique(collection string, id string, document map[string]interface{}) error {
+
+	query := engine.MakeDBQuery(collection, false)
+
+	for _, index := range db.indexes[collection] {
+
+		if !index.Unique {
+			continue
+		}
+		key, ok := index.Key(document)
+		if !ok {
+			continue
+		}
+		err := db.scan(query, func(otherID string, other map[string]interface{}) error {
+			if otherID == id {
+				return nil
+			}
+			if otherKey, ok := index.Key(other); ok && otherKey == key {
+				return fmt.Errorf("%w: %s.%s %s", engine.DuplicateKey, collection, index.GetName(), key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//EnsureIndex add index, only unique, sparse and partial options are honored, ttl index is not supported
+func (db *LocalDocDB) EnsureIndex(collection string, index engine.IndexSpec) error {
+
+	if err := index.Validate(); err != nil {
+		return err
+	}
+	if index.TTL {
+		return fmt.Errorf("%w: local db does not expire documents", engine.InvalidIndex)
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	name := index.GetName()
+	for _, existed := range db.indexes[collection] {
+		if existed.GetName() == name {
+			return nil
+		}
+	}
+	if index.Unique {
+		keys := map[string]string{}
+		err := db.scan(engine.MakeDBQuery(collection, false), func(id string, document map[string]interface{}) error {
+			key, ok := index.Key(document)
+			if !ok {
+				return nil
+			}
+			if otherID, existed := keys[key]; existed {
+				return fmt.Errorf("%w: %s.%s %s and %s", engine.DuplicateKey, collection, name, otherID, id)
+			}
+			keys[key] = id
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	db.indexes[collection] = append(db.indexes[collection], index)
+	return nil
+}
+
+func (db *LocalDocDB) ListIndexes(collection string) ([]engine.IndexSpec, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return append([]engine.IndexSpec{}, db.indexes[collection]...), nil
+}
+
+func (db *LocalDocDB) DropIndex(collection string, name string) error {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	remains := []engine.IndexSpec{}
+	for _, index := range db.indexes[collection] {
+		if index.GetName() != name {
+			remains = append(remains, index)
+		}
+	}
+	db.indexes[collection] = remains
+	return nil
+}
+
+//MARK: Collect vary
+
+func (db *LocalDocDB) collectVary(query engine.DBQuery, field string, isInt bool) (map[string]int, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	resultMap := map[string]int{}
+
+	err := db.scan(query, func(id string, document map[string]interface{}) error {
+
+		value, ok := engine.GetField(document, field)
+		if !ok {
+			return nil
+		}
+		if isInt {
+			number, ok := value.(float64)
+			if !ok {
+				return nil
+			}
+			resultMap[strconv.FormatInt(int64(number), 10)]++
+		} else {
+			str, ok := value.(string)
+			if !ok {
+				return nil
+			}
+			resultMap[str]++
+		}
+		return nil
+	})
+	return resultMap, err
+}
+
+func (db *LocalDocDB) CollectVaryInt(collection string, field string) (map[string]int, error) {
+	return db.collectVary(engine.MakeDBQuery(collection, false), field, true)
+}
+func (db *LocalDocDB) CollectVaryString(collection string, field string) (map[string]int, error) {
+	return db.collectVary(engine.MakeDBQuery(collection, false), field, false)
+}
+func (db *LocalDocDB) CollectVaryQueryInt(query engine.DBQuery, field string) (map[string]int, error) {
+	return db.collectVary(query, field, true)
+}
+func (db *LocalDocDB) CollectVaryQueryString(query engine.DBQuery, field string) (map[string]int, error) {
+	return db.collectVary(query, field, false)
+}
+
+//MARK: Watch
+
+//localChange a change event with the document before a delete, so deletes can be matched with the query of a watcher
+type localChange struct {
+	event  engine.ChangeEvent
+	before map[string]interface{}
+}
+
+type localDocWatcher struct {
+	collection string
+	query      *engine.DBQuery
+	mux        sync.Mutex
+	queue      []engine.ChangeEvent
+	signal     chan bool
+}
+
+func (watcher *localDocWatcher) push(change localChange) {
+
+	event := change.event
+	if event.Collection != watcher.collection {
+		return
+	}
+	document := event.Document
+	if event.Type == engine.ChangeDelete {
+		document = change.before
+	}
+	if document != nil && watcher.query != nil && !watcher.query.Match(document) {
+		return
+	}
+	watcher.mux.Lock()
+	watcher.queue = append(watcher.queue, event)
+	watcher.mux.Unlock()
+
+	select {
+	case watcher.signal <- true:
+	default:
+	}
+}
+
+func (watcher *localDocWatcher) pop() []engine.ChangeEvent {
+
+	watcher.mux.Lock()
+	defer watcher.mux.Unlock()
+
+	queue := watcher.queue
+	watcher.queue = nil
+	return queue
+}
+
+//record add a change event to pending list, before is the content of a deleted document. Caller must hold the db lock
+func (db *LocalDocDB) record(changeType string, collection string, id string, content []byte, before []byte) {
+
+	change := localChange{}
+	var document map[string]interface{} = nil
+	if content != nil {
+		document, _ = decodeLocalDocument(content)
+	}
+	if before != nil {
+		change.before, _ = decodeLocalDocument(before)
+	}
+	change.event = engine.MakeChangeEvent(changeType, collection, id, document, nil)
+	db.pending = append(db.pending, change)
+}
+
+//flush publish pending change events to watchers, caller must hold the db lock
+func (db *LocalDocDB) flush() {
+
+	for _, change := range db.pending {
+
+		db.sequence++
+		change.event.ResumeToken = strconv.FormatInt(db.sequence, 10)
+
+		db.history = append(db.history, change)
+		if len(db.history) > __local_doc_history {
+			db.history = db.history[len(db.history)-__local_doc_history:]
+		}
+		for _, watcher := range db.watchers {
+			watcher.push(change)
+		}
+	}
+	db.pending = nil
+}
+
+//Watch observe collection with in process notification, resume token is the sequence number
+//of an event. Only the last 1024 events can be resumed.
+func (db *LocalDocDB) Watch(ctx context.Context, collection string, query *engine.DBQuery, resumeToken string) <-chan engine.ChangeEvent {
+
+	events := make(chan engine.ChangeEvent)
+
+	watcher := &localDocWatcher{
+		collection: collection,
+		query:      query,
+		signal:     make(chan bool, 1),
+	}
+	var resumeErr error = nil
+
+	db.mux.Lock()
+	if resumeToken != "" {
+		sequence, err := strconv.ParseInt(resumeToken, 10, 64)
+		if err != nil {
+			resumeErr = err
+		} else if sequence < db.sequence-int64(len(db.history)) {
+			resumeErr = ErrResumeTokenExpired
+		} else if sequence < db.sequence {
+			for _, change := range db.history[len(db.history)-int(db.sequence-sequence):] {
+				watcher.push(change)
+			}
+		}
+	}
+	if resumeErr == nil {
+		db.watchers = append(db.watchers, watcher)
+	}
+	db.mux.Unlock()
+
+	go func() {
+		defer close(events)
+
+		if resumeErr != nil {
+			select {
+			case events <- engine.ChangeEvent{Collection: collection, Err: resumeErr}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		defer db.unwatch(watcher)
+
+		for {
+			for _, event := range watcher.pop() {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-watcher.signal:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+func (db *LocalDocDB) unwatch(watcher *localDocWatcher) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for i, registered := range db.watchers {
+		if registered == watcher {
+			db.watchers = append(db.watchers[:i], db.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+//MARK: LocalDocQueryResult
+
+//LocalDocQueryResult result of query
+type LocalDocQueryResult struct {
+	SelectOne   bool
+	Err         error
+	Total       int64
+	isAvailable bool
+	documents   []map[string]interface{}
+	cursor      int
+}
+
+//Close close
+func (result *LocalDocQueryResult) Close() {
+
+	result.isAvailable = false
+	result.documents = nil
+}
+
+//IsAvailable check if isavailable
+func (result *LocalDocQueryResult) IsAvailable() bool {
+	return result.isAvailable
+}
+
+//Error implement get Error
+func (result *LocalDocQueryResult) Error() error {
+	return result.Err
+}
+
+//Count count total document
+func (result *LocalDocQueryResult) Count() int64 {
+	return result.Total
+}
+
+func decodeLocalResult(source map[string]interface{}, document interface{}) error {
+
+	content, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, document)
+}
+
+//Next get next document
+func (result *LocalDocQueryResult) Next(document interface{}) error {
+
+	if result.SelectOne {
+		return errors.New("select on cursor while requested single query")
+	}
+	if result.cursor >= len(result.documents) {
+		return engine.NoDocument
+	}
+	result.cursor++
+	return decodeLocalResult(result.documents[result.cursor-1], document)
+}
+
+//GetOne get single result document
+func (result *LocalDocQueryResult) GetOne(document interface{}) error {
+
+	if !result.SelectOne {
+		return errors.New("get single result while requested many document query")
+	}
+	if len(result.documents) == 0 {
+		return engine.NoDocument
+	}
+	return decodeLocalResult(result.documents[0], document)
+}
+
+//MARK: LocalDocTransaction
+
+//LocalDocTransaction apply DBTransaction, items are applied while holding the db lock and rolled back if one fail
+type LocalDocTransaction struct {
+	engine.TransactionBuffer
+	db *LocalDocDB
+	//locked transaction is run by RunInTransaction which already hold the db lock
+	locked bool
+}
+
+//Begin dbtransaction begin
+func (transaction *LocalDocTransaction) Begin() {
+
+}
+
+//Get dbtransaction get
+func (transaction *LocalDocTransaction) Get(collection string, id string, document interface{}) error {
+
+	if transaction.locked {
+		return transaction.db.get(collection, id, document)
+	}
+	return transaction.db.Get(collection, id, document)
+}
+
+//Query dbtransaction query
+func (transaction *LocalDocTransaction) Query(query engine.DBQuery) engine.DBQueryResult {
+
+	if transaction.locked {
+		return transaction.db.query(query)
+	}
+	return transaction.db.Query(query)
+}
+
+//Commit dbtransaction commit, it does nothing inside RunInTransaction which commit when fn return
+func (transaction *LocalDocTransaction) Commit() (err error) {
+
+	if transaction.locked {
+		return nil
+	}
+	db := transaction.db
+
+	_, done := db.observe(context.Background(), "local_docdb", "transcommit", "", "")
+	defer func() { done(err, transaction.Len()) }()
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	defer db.flush()
+
+	return transaction.commit()
+}
+
+//commit caller must hold the db lock
+func (transaction *LocalDocTransaction) commit() error {
+
+	if transaction.IsClosed() {
+		return engine.TransactionClosed
+	}
+	db := transaction.db
+
+	//documents content are never modified in place so a shallow copy is enough to roll back
+	backup := map[string]map[string][]byte{}
+	backupIndexes := map[string][]engine.IndexSpec{}
+
+	for _, item := range transaction.Items() {
+
+		if _, ok := backup[item.Collection]; ok {
+			continue
+		}
+		backupIndexes[item.Collection] = db.indexes[item.Collection]
+		col, existed := db.collections[item.Collection]
+		if !existed {
+			backup[item.Collection] = nil
+			continue
+		}
+		clone := make(map[string][]byte, len(col))
+		for id, content := range col {
+			clone[id] = content
+		}
+		backup[item.Collection] = clone
+	}
+	err := transaction.apply()
+
+	if err != nil {
+		db.pending = nil
+		for collection, col := range backup {
+			if col == nil {
+				delete(db.collections, collection)
+			} else {
+				db.collections[collection] = col
+			}
+			if indexes := backupIndexes[collection]; indexes == nil {
+				delete(db.indexes, collection)
+			} else {
+				db.indexes[collection] = indexes
+			}
+		}
+	}
+	return err
+}
+
+//apply caller must hold the db lock
+func (transaction *LocalDocTransaction) apply() error {
+
+	db := transaction.db
+
+	for _, item := range transaction.Items() {
+
+		switch item.Command {
+		case engine.TransactionPut:
+			content, err := json.Marshal(item.Document)
+			if err != nil {
+				return err
+			}
+			if err := db.putContent(item.Collection, item.ID, content); err != nil {
+				return err
+			}
+		case engine.TransactionUpdate:
+			if err := db.update(item.Collection, item.ID, item.Document.(engine.Update)); err != nil {
+				return err
+			}
+		case engine.TransactionDel:
+			db.delete(item.Collection, item.ID)
+		case engine.TransactionCreateCollection:
+			db.getCollection(item.Collection, true)
+		case engine.TransactionDelCollection:
+			db.delCollection(item.Collection)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,13 @@
 package adapter
 
 import (
+	"context"
 	"errors"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/tapvanvn/godbengine/engine"
 )
 
 // This LocalMemDB design for testing on local only. On production or multiple user system considering using others.
@@ -16,6 +18,7 @@ type value struct {
 var LocalMemErrNil = errors.New("localmemdb: nil")
 
 type LocalMemDB struct {
+	observable
 	storageString map[string]string
 	storageInt64  map[string]int64
 	expire        map[string]int64
@@ -32,22 +35,38 @@ func (memdb *LocalMemDB) Init(connectionString string) error {
 	return nil
 }
 
+//Ping local memdb is always reachable
+func (memdb *LocalMemDB) Ping(ctx context.Context) error {
+	return nil
+}
+
+//Close nothing to release
+func (memdb *LocalMemDB) Close(ctx context.Context) error {
+	return nil
+}
+
 //Set set key
-func (memdb *LocalMemDB) Set(key string, value string) error {
+func (memdb *LocalMemDB) Set(key string, value string) (err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "set", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxString.Lock()
 	defer memdb.muxString.Unlock()
 	memdb.storageString[key] = value
 	return nil
 }
 
-func (memdb *LocalMemDB) SetInt(key string, value int64) error {
+func (memdb *LocalMemDB) SetInt(key string, value int64) (err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "setint", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	memdb.storageInt64[key] = value
 	return nil
 }
 
-func (memdb *LocalMemDB) IncrInt(key string) (int64, error) {
+func (memdb *LocalMemDB) IncrInt(key string) (result int64, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "incrint", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	value := int64(0)
@@ -59,7 +78,9 @@ func (memdb *LocalMemDB) IncrInt(key string) (int64, error) {
 	return value, nil
 }
 
-func (memdb *LocalMemDB) DecrInt(key string) (int64, error) {
+func (memdb *LocalMemDB) DecrInt(key string) (result int64, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "decrint", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	value := int64(0)
@@ -71,7 +92,9 @@ func (memdb *LocalMemDB) DecrInt(key string) (int64, error) {
 	return value, nil
 }
 
-func (memdb *LocalMemDB) IncrIntBy(key string, num int64) (int64, error) {
+func (memdb *LocalMemDB) IncrIntBy(key string, num int64) (result int64, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "incrintby", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	value := int64(0)
@@ -83,7 +106,9 @@ func (memdb *LocalMemDB) IncrIntBy(key string, num int64) (int64, error) {
 	return value, nil
 }
 
-func (memdb *LocalMemDB) DecrIntBy(key string, num int64) (int64, error) {
+func (memdb *LocalMemDB) DecrIntBy(key string, num int64) (result int64, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "decrintby", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	value := int64(0)
@@ -116,7 +141,9 @@ func (memdb *LocalMemDB) DecrIntByShading(key string, num int64) (int64, error)
 }
 
 //SetExpire set key with expire
-func (memdb *LocalMemDB) SetExpire(key string, value string, d time.Duration) error {
+func (memdb *LocalMemDB) SetExpire(key string, value string, d time.Duration) (err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "setexpire", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxString.Lock()
 	defer memdb.muxString.Unlock()
 	memdb.storageString[key] = value
@@ -127,7 +154,9 @@ func (memdb *LocalMemDB) SetExpire(key string, value string, d time.Duration) er
 
 	return nil
 }
-func (memdb *LocalMemDB) SetIntExpire(key string, value int64, d time.Duration) error {
+func (memdb *LocalMemDB) SetIntExpire(key string, value int64, d time.Duration) (err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "setintexpire", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	memdb.storageInt64[key] = value
@@ -148,7 +177,9 @@ func (memdb *LocalMemDB) SetIntExpireShading(key string, value int64, d time.Dur
 //MARK: GET FUNCTIONS
 
 //Get get from key
-func (memdb *LocalMemDB) Get(key string) (string, error) {
+func (memdb *LocalMemDB) Get(key string) (result string, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "get", "", key)
+	defer func() { done(err, len(result)) }()
 	memdb.muxString.Lock()
 	defer memdb.muxString.Unlock()
 	if val, ok := memdb.storageString[key]; ok {
@@ -167,7 +198,9 @@ func (memdb *LocalMemDB) Get(key string) (string, error) {
 	}
 	return "", LocalMemErrNil
 }
-func (memdb *LocalMemDB) GetInt(key string) (int64, error) {
+func (memdb *LocalMemDB) GetInt(key string) (result int64, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "getint", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxInt64.Lock()
 	defer memdb.muxInt64.Unlock()
 	if val, ok := memdb.storageInt64[key]; ok {
@@ -195,7 +228,9 @@ func (memdb *LocalMemDB) GetIntShading(key string) (int64, error) {
 //MARK: DEL FUNCTIONS
 
 //Del delete a key
-func (memdb *LocalMemDB) Del(key string) error {
+func (memdb *LocalMemDB) Del(key string) (err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "del", "", key)
+	defer func() { done(err, 0) }()
 	memdb.muxString.Lock()
 	delete(memdb.storageString, key)
 	memdb.muxString.Unlock()
@@ -212,9 +247,80 @@ func (memdb *LocalMemDB) DelShading(key string) error {
 
 //MARK: QUERY FUNCTIONS
 
-//find all key in pattern
-func (memdb *LocalMemDB) FindKey(keyPattern string) ([]string, error) {
-	return nil, engine.NotImplement
+//FindKey find keys those are not expired and match keyPattern, keyPattern is a glob pattern as in redis
+func (memdb *LocalMemDB) FindKey(keyPattern string) (keys []string, err error) {
+	_, done := memdb.observe(context.Background(), "local_memdb", "findkey", "", keyPattern)
+	defer func() { done(err, len(keys)) }()
+
+	matcher, err := globToRegexp(keyPattern)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+
+	memdb.muxString.Lock()
+	memdb.muxInt64.Lock()
+	memdb.muxExpire.Lock()
+	defer memdb.muxString.Unlock()
+	defer memdb.muxInt64.Unlock()
+	defer memdb.muxExpire.Unlock()
+
+	found := map[string]bool{}
+	for key := range memdb.storageString {
+		found[key] = true
+	}
+	for key := range memdb.storageInt64 {
+		found[key] = true
+	}
+	keys = []string{}
+	for key := range found {
+		if exp, ok := memdb.expire[key]; ok && now >= exp {
+			continue
+		}
+		if matcher.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//globToRegexp convert glob pattern with *, ?, [...] and \ escape to regexp
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+
+	expression := strings.Builder{}
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch char := pattern[i]; char {
+		case '*':
+			expression.WriteString("(?s:.*)")
+		case '?':
+			expression.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				expression.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + strings.ReplaceAll(class[1:], "\\", "\\\\")
+			} else {
+				class = strings.ReplaceAll(class, "\\", "\\\\")
+			}
+			expression.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expression.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
 }
 
 func (pool *LocalMemDB) IsNotExistedError(err error) bool {
@@ -0,0 +1,19 @@
+package adapter
+
+import "github.com/tapvanvn/godbengine/engine"
+
+//loggable is embedded by pools to implement engine.LoggerSetter, pools are silent until a logger is set
+type loggable struct {
+	logger engine.Logger
+}
+
+//SetLogger set logger of pool
+func (pool *loggable) SetLogger(logger engine.Logger) {
+
+	pool.logger = logger
+}
+
+func (pool *loggable) getLogger() engine.Logger {
+
+	return engine.LoggerOrNop(pool.logger)
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +19,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 //MARK: Mongo Client
@@ -28,19 +33,15 @@ type MongoClient struct {
 	collections map[string]*mongo.Collection
 }
 
-//MongoTransactionItem transaction item
-type MongoTransactionItem struct {
-	command    string
-	collection string
-	document   interface{}
-	id         string
-}
-
 //MongoTransaction apply DBTransaction
 type MongoTransaction struct {
+	engine.TransactionBuffer
 	database    string
+	pool        *MongoPool
 	mongoClient *MongoClient
-	items       []MongoTransactionItem
+	options     engine.TransactionOptions
+	//session is set when transaction is run by RunInTransaction, reads are done inside the session
+	session mongo.SessionContext
 }
 
 func (client *MongoClient) init(mongoClient *mongo.Client) {
@@ -49,6 +50,12 @@ func (client *MongoClient) init(mongoClient *mongo.Client) {
 	client.collections = map[string]*mongo.Collection{}
 }
 
+//collectionCacheKey key of a collection in the cache, pools made by WithDatabase share the clients so the database is part of it
+func collectionCacheKey(databaseName string, collectionName string) string {
+
+	return databaseName + "/" + collectionName
+}
+
 //getCollection get cache collection
 func (client *MongoClient) getCollection(databaseName string, collectionName string, cache bool) *mongo.Collection {
 
@@ -56,20 +63,21 @@ func (client *MongoClient) getCollection(databaseName string, collectionName str
 
 		return client.client.Database(databaseName).Collection(collectionName)
 	}
-	if col, ok := client.collections[collectionName]; ok {
+	key := collectionCacheKey(databaseName, collectionName)
+	if col, ok := client.collections[key]; ok {
 
 		return col
 	}
 	collection := client.client.Database(databaseName).Collection(collectionName)
 
-	client.collections[collectionName] = collection
+	client.collections[key] = collection
 
 	return collection
 }
 
 func (client *MongoClient) cleanCacheCollection(databaseName string, collectionName string) {
 
-	delete(client.collections, collectionName)
+	delete(client.collections, collectionCacheKey(databaseName, collectionName))
 }
 
 //MARK: Mongo Query Result
@@ -143,13 +151,20 @@ func (result MongoQueryResult) GetOne(document interface{}) error {
 	return errors.New("get single result while requested many document query")
 }
 
+//ErrMongoWriteAfterDrop mongodb drop collections after a transaction is committed so a transaction can not write to a collection after dropping it
+var ErrMongoWriteAfterDrop = errors.New("write to a collection after it is dropped in the same transaction")
+
 // MARK: Mongo Pool
 
 //MongoPool mongo pool implement DocumentPool
 type MongoPool struct {
+	loggable
+	observable
 	database        string
 	clients         []*MongoClient
 	roundRobinCount int
+	//PingTimeout when it's set Init ping every client and fail with ErrConnect if a ping fail
+	PingTimeout time.Duration
 }
 
 //First get first client
@@ -166,34 +181,44 @@ func (pool *MongoPool) SelectRobin() *MongoClient {
 	return pool.clients[pool.roundRobinCount]
 }
 
-//Init init pool from connection string
-func (pool *MongoPool) Init(connectionString string) error {
-
-	clients := strings.Split(connectionString, ",")
+//MongoServer a mongodb uri and the number of clients connect with it
+type MongoServer struct {
+	URI string
+	//NumClient default 1
+	NumClient int
+	//TLSCAFile when it's set clients connect with tls that trust the certificates of the pem file
+	TLSCAFile string
+}
 
-	pool.database = "default"
-	var err error = nil
+//MongoOptions typed options of MongoPool, zero fields take the defaults of the uri or the driver
+type MongoOptions struct {
+	Servers []MongoServer
+	//Database default is "default"
+	Database string
+	//TLS is used by servers those have no TLSCAFile when it's set
+	TLS            *tls.Config
+	MaxPoolSize    uint64
+	ConnectTimeout time.Duration
+	//PingTimeout override PingTimeout of the pool when it's set
+	PingTimeout time.Duration
+}
 
-	for _, client := range clients {
+//parseMongoConnectionString parse uri?ssl=true&ssl_ca_certs=path[numClient],... connection string
+func parseMongoConnectionString(connectionString string) (MongoOptions, error) {
 
-		var numClient = 1
-		hasNumClient := strings.Index(client, "[")
+	options := MongoOptions{}
 
-		if hasNumClient > 0 {
+	for _, client := range strings.Split(connectionString, ",") {
 
-			end := strings.Index(client, "]")
-			if end > hasNumClient {
+		client, numClient, err := parseNumClient(strings.TrimSpace(client))
+		if err != nil {
 
-				numString := client[hasNumClient+1 : end]
-				if tryParse, err := strconv.ParseInt(numString, 10, 64); err == nil {
-					numClient = int(tryParse)
-				}
-			}
-			client = client[0:hasNumClient]
+			return options, newInvalidConnectionString("mongo", "%v", err)
 		}
+		server := MongoServer{NumClient: numClient}
+
 		//detect params
 		questionMark := strings.Index(client, "?")
-		var tlsConfig *tls.Config = nil
 
 		if questionMark > 0 {
 			prefix := client[:questionMark]
@@ -221,28 +246,87 @@ func (pool *MongoPool) Init(connectionString string) error {
 				client = prefix
 			}
 			if hasSSL {
-				tlsConfig, err = getCustomTLSConfig(sslPath)
-				if err != nil {
+				if sslPath == "" {
 
-					log.Fatal(err)
+					return options, newInvalidConnectionString("mongo", "ssl=true needs ssl_ca_certs")
 				}
+				server.TLSCAFile = sslPath
 			}
 		}
+		server.URI = client
+		options.Servers = append(options.Servers, server)
+	}
+	return options, nil
+}
+
+//Init init pool from connection string uri?ssl=true&ssl_ca_certs=path[numClient],...
+func (pool *MongoPool) Init(connectionString string) error {
+
+	options, err := parseMongoConnectionString(connectionString)
+	if err != nil {
+
+		return err
+	}
+	return pool.InitWithOptions(options)
+}
+
+//InitWithOptions init pool from typed options
+func (pool *MongoPool) InitWithOptions(opts MongoOptions) error {
+
+	if len(opts.Servers) == 0 {
+
+		return newInvalidConnectionString("mongo", "no server")
+	}
+	pool.database = opts.Database
+	if pool.database == "" {
+		pool.database = "default"
+	}
+
+	for _, server := range opts.Servers {
+
+		tlsConfig := opts.TLS
+
+		if server.TLSCAFile != "" {
+			pool.getLogger().Debug("mongo load tls config", "ca_file", server.TLSCAFile)
+			var err error = nil
+			tlsConfig, err = getCustomTLSConfig(server.TLSCAFile)
+			if err != nil {
+
+				pool.Close(context.Background())
+				return newInvalidConnectionString("mongo", "tls: %w", err)
+			}
+		}
+		numClient := server.NumClient
+		if numClient < 1 {
+			numClient = 1
+		}
 
 		for i := 0; i < numClient; i++ {
 
-			clientOptions := options.Client().ApplyURI(client)
+			clientOptions := options.Client().ApplyURI(server.URI)
 			if tlsConfig != nil {
 				clientOptions.SetTLSConfig(tlsConfig)
 			}
+			if opts.MaxPoolSize > 0 {
+				clientOptions.SetMaxPoolSize(opts.MaxPoolSize)
+			}
+			if opts.ConnectTimeout > 0 {
+				clientOptions.SetConnectTimeout(opts.ConnectTimeout)
+			}
+			if err := clientOptions.Validate(); err != nil {
+
+				pool.Close(context.Background())
+				return newInvalidConnectionString("mongo", "%w", err)
+			}
 			mongoClient, err := mongo.Connect(context.TODO(), clientOptions)
 
 			if err != nil {
 
-				log.Fatal(err)
+				pool.Close(context.Background())
+				return newConnectError("mongo", err)
 			}
 
-			fmt.Println("mongo new client ", client)
+			pool.getLogger().Debug("mongo new client", "database", pool.database)
 
 			client := &MongoClient{}
 			client.init(mongoClient)
@@ -250,13 +334,15 @@ func (pool *MongoPool) Init(connectionString string) error {
 			pool.clients = append(pool.clients, client)
 		}
 	}
-	fmt.Println("mongo pool ", len(pool.clients), " clients.")
+	if opts.PingTimeout > 0 {
+		pool.PingTimeout = opts.PingTimeout
+	}
+	pool.getLogger().Info("mongo pool init", "clients", len(pool.clients))
 
-	return nil
+	return pingOnInit("mongo", pool, pool.PingTimeout)
 }
 
 func getCustomTLSConfig(caFile string) (*tls.Config, error) {
-	fmt.Println("load TLS Config ", caFile)
 	tlsConfig := new(tls.Config)
 	certs, err := ioutil.ReadFile(caFile)
 
@@ -284,14 +370,61 @@ func (pool *MongoPool) InitWithDatabase(connectionString string, database string
 	return nil
 }
 
+//WithDatabase make pool that share clients of pool and serve database, closing it close the shared clients
+func (pool *MongoPool) WithDatabase(database string) engine.DocumentPool {
+
+	scoped := &MongoPool{
+		loggable:    pool.loggable,
+		observable:  pool.observable,
+		database:    database,
+		clients:     pool.clients,
+		PingTimeout: pool.PingTimeout,
+	}
+	return scoped
+}
+
+//Ping ping primary of every client
+func (pool *MongoPool) Ping(ctx context.Context) error {
+
+	for _, client := range pool.clients {
+
+		if err := client.client.Ping(ctx, readpref.Primary()); err != nil {
+
+			return err
+		}
+	}
+	return nil
+}
+
+//Close disconnect every client
+func (pool *MongoPool) Close(ctx context.Context) error {
+
+	var firstErr error = nil
+
+	for _, client := range pool.clients {
+
+		if err := client.client.Disconnect(ctx); err != nil && firstErr == nil {
+
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 //Get get document
-func (pool *MongoPool) Get(collection string, id string, document interface{}) error {
-	now := time.Now()
+func (pool *MongoPool) Get(collection string, id string, document interface{}) (err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "get", collection, id)
+	defer func() { done(err, documentSize(err)) }()
 	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 	if col == nil {
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
+	err = mongoGet(ctx, col, id, document)
+
+	return err
+}
+
+func mongoGet(ctx context.Context, col *mongo.Collection, id string, document interface{}) error {
 
 	opts := options.FindOne().SetProjection(bson.M{"_id": 0})
 
@@ -300,10 +433,6 @@ func (pool *MongoPool) Get(collection string, id string, document interface{}) e
 	result := col.FindOne(ctx, filter, opts)
 
 	if result.Err() != nil {
-		if __measurement {
-			delta := time.Now().Sub(now).Nanoseconds()
-			fmt.Printf("mersure docdb get %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
-		}
 		err := result.Err()
 		if err == mongo.ErrNoDocuments {
 			return engine.NoDocument
@@ -311,10 +440,6 @@ func (pool *MongoPool) Get(collection string, id string, document interface{}) e
 			return err
 		}
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb get %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
-	}
 	return result.Decode(document)
 }
 
@@ -324,16 +449,15 @@ func (pool *MongoPool) Put(collection string, document engine.Document) error {
 	return pool.PutRaw(collection, document.GetID(), document)
 }
 
-func (pool *MongoPool) PutRaw(collection string, id string, document interface{}) error {
-	now := time.Now()
+func (pool *MongoPool) PutRaw(collection string, id string, document interface{}) (err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "put", collection, id)
+	defer func() { done(err, 0) }()
 	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 
 	if col == nil {
 
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
-
 	opts := options.Update().SetUpsert(true)
 
 	filter := bson.D{bson.E{Key: "__id", Value: id}}
@@ -342,34 +466,96 @@ func (pool *MongoPool) PutRaw(collection string, id string, document interface{}
 		"$set": document,
 	}
 
-	_, err := col.UpdateOne(ctx, filter, update, opts)
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb put %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
+	_, err = col.UpdateOne(ctx, filter, update, opts)
+	return err
+}
+
+//Update apply update operators on document
+func (pool *MongoPool) Update(collection string, id string, update engine.Update) (err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "update", collection, id)
+	defer func() { done(err, 0) }()
+	col := pool.SelectRobin().getCollection(pool.database, collection, true)
+
+	if col == nil {
+
+		return errors.New("get collection fail")
+	}
+	mongoUpdate, err := buildMongoUpdate(&update)
+
+	if err != nil {
+
+		return err
 	}
+	opts := options.Update().SetUpsert(true)
+
+	filter := bson.D{bson.E{Key: "__id", Value: id}}
+
+	_, err = col.UpdateOne(ctx, filter, mongoUpdate, opts)
 	return err
 }
 
+var __mongo_update_operators = map[string]string{
+	engine.UpdateSet:         "$set",
+	engine.UpdateUnset:       "$unset",
+	engine.UpdateInc:         "$inc",
+	engine.UpdatePush:        "$push",
+	engine.UpdatePull:        "$pull",
+	engine.UpdateAddToSet:    "$addToSet",
+	engine.UpdateMin:         "$min",
+	engine.UpdateMax:         "$max",
+	engine.UpdateCurrentDate: "$currentDate",
+}
+
+func buildMongoUpdate(update *engine.Update) (bson.M, error) {
+
+	if update.IsEmpty() {
+
+		return nil, engine.InvalidUpdate
+	}
+	mongoUpdate := bson.M{}
+
+	for _, operation := range update.Operations {
+
+		operator, ok := __mongo_update_operators[operation.Operator]
+		if !ok {
+
+			return nil, fmt.Errorf("%w: unknown operator %s", engine.InvalidUpdate, operation.Operator)
+		}
+		var value interface{} = operation.Value
+
+		if operation.Operator == engine.UpdateUnset {
+
+			value = ""
+
+		} else if operation.Operator == engine.UpdateCurrentDate {
+
+			value = true
+		}
+		fields, ok := mongoUpdate[operator].(bson.M)
+		if !ok {
+			fields = bson.M{}
+			mongoUpdate[operator] = fields
+		}
+		fields[operation.Field] = value
+	}
+	return mongoUpdate, nil
+}
+
 //Del delete document
-func (pool *MongoPool) Del(collection string, id string) error {
-	now := time.Now()
+func (pool *MongoPool) Del(collection string, id string) (err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "del", collection, id)
+	defer func() { done(err, 0) }()
 	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 
 	if col == nil {
 
 		return errors.New("get collection fail")
 	}
-	ctx := context.TODO()
-
 	opts := &options.DeleteOptions{}
 
 	filter := bson.M{"__id": id}
 
-	_, err := col.DeleteOne(ctx, filter, opts)
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb del %s.%s %0.2fms\n", collection, id, float32(delta)/1_000_000)
-	}
+	_, err = col.DeleteOne(ctx, filter, opts)
 	return err
 }
 
@@ -379,16 +565,141 @@ func (pool *MongoPool) IsNoRecordError(err error) bool {
 	return err == engine.NoDocument //mongo.ErrNoDocuments
 }
 
+//__mongo_transient_codes server errors those happen while a primary is elected or a node is shutting down
+var __mongo_transient_codes = []int{
+	6,     //HostUnreachable
+	7,     //HostNotFound
+	89,    //NetworkTimeout
+	91,    //ShutdownInProgress
+	189,   //PrimarySteppedDown
+	262,   //ExceededTimeLimit
+	9001,  //SocketException
+	10107, //NotWritablePrimary
+	11600, //InterruptedAtShutdown
+	11602, //InterruptedDueToReplStateChange
+	13435, //NotPrimaryNoSecondaryOk
+	13436, //NotPrimaryOrSecondary
+}
+
+//IsTransientError check if err is caused by network, timeout, server selection or a primary election
+func (pool *MongoPool) IsTransientError(err error) bool {
+
+	if err == nil || err == engine.NoDocument {
+
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+
+		return true
+	}
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+
+		if serverErr.HasErrorLabel("TransientTransactionError") || serverErr.HasErrorLabel("RetryableWriteError") {
+
+			return true
+		}
+		for _, code := range __mongo_transient_codes {
+
+			if serverErr.HasErrorCode(code) {
+
+				return true
+			}
+		}
+	}
+	return false
+}
+
 //MakeTransaction create new transaction
-func (pool *MongoPool) MakeTransaction() engine.DBTransaction {
+func (pool *MongoPool) MakeTransaction(options ...*engine.TransactionOptions) engine.DBTransaction {
 
 	trans := MongoTransaction{mongoClient: pool.SelectRobin(),
-		items:    make([]MongoTransactionItem, 0),
-		database: pool.database}
+		pool:     pool,
+		database: pool.database,
+		options:  engine.MergeTransactionOptions(options...)}
 
 	return &trans
 }
 
+//buildMongoTransactionOptions map read concern and write concern to mongo options,
+//write concern that is not "majority" or a number is used as a tag set.
+func buildMongoTransactionOptions(transactionOptions engine.TransactionOptions) *options.TransactionOptions {
+
+	opts := options.Transaction()
+
+	if transactionOptions.ReadConcern != "" {
+
+		opts = opts.SetReadConcern(readconcern.New(readconcern.Level(transactionOptions.ReadConcern)))
+	}
+	if transactionOptions.WriteConcern == engine.WriteConcernMajority {
+
+		opts = opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	} else if w, err := strconv.Atoi(transactionOptions.WriteConcern); err == nil {
+
+		opts = opts.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+
+	} else if transactionOptions.WriteConcern != "" {
+
+		opts = opts.SetWriteConcern(writeconcern.New(writeconcern.WTagSet(transactionOptions.WriteConcern)))
+	}
+	return opts
+}
+
+//RunInTransaction run fn inside a session transaction. The driver retry fn on TransientTransactionError
+//and retry the commit on UnknownTransactionCommitResult until Timeout of options.
+func (pool *MongoPool) RunInTransaction(ctx context.Context, fn func(tx engine.DBTransaction) error, options ...*engine.TransactionOptions) error {
+
+	transactionOptions := engine.MergeTransactionOptions(options...)
+
+	if transactionOptions.Timeout > 0 {
+
+		var cancel context.CancelFunc = nil
+		ctx, cancel = context.WithTimeout(ctx, transactionOptions.Timeout)
+		defer cancel()
+	}
+	client := pool.SelectRobin()
+
+	session, err := client.client.StartSession()
+	if err != nil {
+
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	var transaction *MongoTransaction = nil
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+
+		transaction = &MongoTransaction{mongoClient: client,
+			pool:     pool,
+			database: pool.database,
+			options:  transactionOptions,
+			session:  sessCtx}
+
+		if err := fn(transaction); err != nil {
+
+			return nil, err
+		}
+		if transaction.IsClosed() {
+
+			return nil, engine.TransactionClosed
+		}
+		return nil, transaction.apply(sessCtx)
+	}, buildMongoTransactionOptions(transactionOptions))
+
+	if err != nil {
+
+		return err
+	}
+	return transaction.dropCollections(ctx)
+}
+
 func (pool *MongoPool) buildQueryFilter(filterItem *engine.DBFilterItem) bson.M {
 
 	if filterItem.Operator == "=" {
@@ -469,10 +780,10 @@ func (pool *MongoPool) buildQueryFilter(filterItem *engine.DBFilterItem) bson.M
 
 		pattern := fmt.Sprintf("%v", filterItem.FieldValue)
 
-		return bson.M{
+		return bson.M{filterItem.Field: bson.M{
 
 			"$regex": primitive.Regex{Pattern: pattern},
-		}
+		}}
 
 	} else if filterItem.Operator == "in" {
 
@@ -570,10 +881,17 @@ func (pool *MongoPool) buildQueryOr(ruleSet *gocondition.RuleSet) bson.M {
 //Query query document
 func (pool *MongoPool) Query(query engine.DBQuery) engine.DBQueryResult {
 
-	now := time.Now()
+	ctx, done := pool.observe(context.Background(), "mongo", "query", query.Collection, "")
 	col := pool.SelectRobin().getCollection(pool.database, query.Collection, true)
 
-	ctx := context.TODO()
+	queryResult := pool.query(ctx, col, query)
+
+	done(queryResult.Err, int(queryResult.Total))
+	return queryResult
+}
+
+func (pool *MongoPool) query(ctx context.Context, col *mongo.Collection, query engine.DBQuery) MongoQueryResult {
+
 	queryResult := MongoQueryResult{Err: nil, Ctx: ctx}
 
 	if col == nil {
@@ -628,7 +946,7 @@ func (pool *MongoPool) Query(query engine.DBQuery) engine.DBQueryResult {
 		total, err := col.CountDocuments(ctx, filter, options.Count())
 		if err != nil {
 
-			fmt.Println(err.Error())
+			pool.getLogger().Warn("mongo count documents fail", "collection", query.Collection, "error", err)
 		}
 		result, err := col.Find(ctx, filter, opts)
 
@@ -644,104 +962,279 @@ func (pool *MongoPool) Query(query engine.DBQuery) engine.DBQueryResult {
 		queryResult.isAvailable = true
 		queryResult.Total = total
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb query %s %0.2fms\n", query.Collection, float32(delta)/1_000_000)
-	}
 	return queryResult
 }
 
-//We dont need to implement anything on mongodb
-func (pool *MongoPool) CleanPagingInfo(query engine.DBQuery) {
+//UpdateWhere apply update to all documents that match query
+func (pool *MongoPool) UpdateWhere(query engine.DBQuery, update engine.Update) (count int64, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "updatewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+	col := pool.SelectRobin().getCollection(pool.database, query.Collection, true)
 
-}
+	if col == nil {
 
-//MARK: MongoTransaction
+		return 0, errors.New("get collection fail")
+	}
+	mongoUpdate, err := buildMongoUpdate(&update)
 
-//Begin dbtransaction begin
-func (transaction *MongoTransaction) Begin() {
+	if err != nil {
+
+		return 0, err
+	}
+	filter := pool.buildQueryAnd(query.Condition)
+
+	result, err := col.UpdateMany(ctx, filter, mongoUpdate, options.Update())
+	if err != nil {
 
+		return 0, err
+	}
+	return result.MatchedCount, nil
 }
 
-//Put dbtransaction put
-func (transaction *MongoTransaction) Put(collection string, document engine.Document) {
+//DeleteWhere delete all documents that match query
+func (pool *MongoPool) DeleteWhere(query engine.DBQuery) (count int64, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "deletewhere", query.Collection, "")
+	defer func() { done(err, int(count)) }()
+	col := pool.SelectRobin().getCollection(pool.database, query.Collection, true)
+
+	if col == nil {
 
-	transaction.items = append(transaction.items, MongoTransactionItem{command: "put", collection: collection, id: document.GetID(), document: document})
+		return 0, errors.New("get collection fail")
+	}
+	filter := pool.buildQueryAnd(query.Condition)
+
+	result, err := col.DeleteMany(ctx, filter, options.Delete())
+	if err != nil {
+
+		return 0, err
+	}
+	return result.DeletedCount, nil
 }
 
-//Put dbtransaction put
-func (transaction *MongoTransaction) PutRaw(collection string, id string, document interface{}) {
+type mongoChangeEvent struct {
+	OperationType string   `bson:"operationType"`
+	FullDocument  bson.Raw `bson:"fullDocument"`
+	//DocumentKey hold the internal _id, it is the only key of a deleted document
+	DocumentKey bson.Raw `bson:"documentKey"`
+}
+
+//watchedIDs map internal _id of documents those match the query of a Watch to their ID, so deletes can be reported
+type watchedIDs map[string]string
+
+//load ids of the documents those match filter
+func (ids watchedIDs) load(ctx context.Context, col *mongo.Collection, filter bson.M) error {
 
-	transaction.items = append(transaction.items, MongoTransactionItem{command: "put", collection: collection, id: id, document: document})
+	cursor, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "__id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		ids[cursor.Current.Lookup("_id").String()] = fmt.Sprintf("%v", cursor.Current.Lookup("__id"))
+	}
+	return cursor.Err()
 }
 
-//Del dbtransaction delete
-func (transaction *MongoTransaction) Del(collection string, id string) {
+//mongoDocumentToMap convert document to the map form that is used to match query
+func mongoDocumentToMap(raw bson.Raw) (map[string]interface{}, error) {
 
-	transaction.items = append(transaction.items, MongoTransactionItem{command: "del", collection: collection, id: id})
+	document := bson.M{}
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	delete(document, "_id")
+	content, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-//Commit dbtransaction commit
-func (transaction *MongoTransaction) Commit() error {
-	now := time.Now()
-	ctx := context.Background()
+//Watch observe collection with mongodb change stream, it requires a replica set.
+//Query is matched on the full document after change. Mongodb only report the internal _id of a deleted document,
+//so Watch keep the _id and ID of every document that match query, a delete is reported only for those documents.
+func (pool *MongoPool) Watch(ctx context.Context, collection string, query *engine.DBQuery, resumeToken string) <-chan engine.ChangeEvent {
 
-	fmt.Println("dbtransaction commit")
+	events := make(chan engine.ChangeEvent)
 
-	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
-		// Important: You must pass sessCtx as the Context parameter to the operations for them to be executed in the
-		// transaction.
-		for _, item := range transaction.items {
+	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 
-			col := transaction.mongoClient.getCollection(transaction.database, item.collection, false)
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
 
-			if col == nil {
+	var tokenErr error = nil
+	if resumeToken != "" {
+		token, err := base64.StdEncoding.DecodeString(resumeToken)
+		if err != nil {
+			tokenErr = err
+		} else {
+			opts = opts.SetResumeAfter(bson.Raw(token))
+		}
+	}
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+	}}}}
+
+	go func() {
+		defer close(events)
+
+		fail := func(err error) {
+			event := engine.ChangeEvent{Collection: collection, Err: err}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+		if tokenErr != nil {
+			fail(tokenErr)
+			return
+		}
+		stream, err := col.Watch(ctx, pipeline, opts)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer stream.Close(context.Background())
 
-				return nil, errors.New("get collection fail")
+		//documents those are written after the stream is opened are added by their events
+		ids := watchedIDs{}
+		filter := bson.M{}
+		if query != nil {
+			filter = pool.buildQueryAnd(query.Condition)
+		}
+		if err := ids.load(ctx, col, filter); err != nil {
+			fail(err)
+			return
+		}
+
+		for stream.Next(ctx) {
+
+			change := mongoChangeEvent{}
+			if err := stream.Decode(&change); err != nil {
+				fail(err)
+				return
+			}
+			changeType := engine.ChangeUpdate
+			if change.OperationType == "insert" {
+				changeType = engine.ChangeInsert
+			} else if change.OperationType == "delete" {
+				changeType = engine.ChangeDelete
+			}
+			var document map[string]interface{} = nil
+			id := ""
+			fullDocument := change.FullDocument
+			key := change.DocumentKey.Lookup("_id").String()
+
+			if changeType == engine.ChangeDelete {
+				known, ok := ids[key]
+				if !ok {
+					//document did not match query
+					continue
+				}
+				delete(ids, key)
+				id = known
+			} else {
+				if len(fullDocument) == 0 {
+					//document is deleted before the lookup
+					continue
+				}
+				if document, err = mongoDocumentToMap(fullDocument); err != nil {
+					fail(err)
+					return
+				}
+				if query != nil && !query.Match(document) {
+					delete(ids, key)
+					continue
+				}
+				id = fmt.Sprintf("%v", document["__id"])
+				ids[key] = id
+			}
+			event := engine.MakeChangeEvent(changeType, collection, id, document, func(document interface{}) error {
+				return bson.Unmarshal(fullDocument, document)
+			})
+			event.ResumeToken = base64.StdEncoding.EncodeToString(stream.ResumeToken())
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
 			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			fail(err)
+		}
+	}()
+	return events
+}
 
-			if item.command == "put" {
+//We dont need to implement anything on mongodb
+func (pool *MongoPool) CleanPagingInfo(query engine.DBQuery) {
+
+}
 
-				fmt.Print("transaction put", transaction.database, item.collection)
+//MARK: MongoTransaction
 
-				opts := options.Update().SetUpsert(true)
+//Begin dbtransaction begin
+func (transaction *MongoTransaction) Begin() {
 
-				filter := bson.D{bson.E{Key: "__id", Value: item.id}}
+}
 
-				update := bson.M{
-					"$set": item.document,
-				}
+//context get context of reads, reads are outside of transaction if it's not run by RunInTransaction
+func (transaction *MongoTransaction) context() context.Context {
 
-				_, err := col.UpdateOne(sessCtx, filter, update, opts)
+	if transaction.session != nil {
 
-				if err != nil {
-					fmt.Println(" ", err.Error())
-					return nil, err
-				}
-				fmt.Println(" success")
-			} else if item.command == "del" {
+		return transaction.session
+	}
+	return context.TODO()
+}
 
-				opts := &options.DeleteOptions{}
+//Get dbtransaction get
+func (transaction *MongoTransaction) Get(collection string, id string, document interface{}) error {
 
-				filter := bson.M{"__id": item.id}
+	col := transaction.mongoClient.getCollection(transaction.database, collection, false)
 
-				_, err := col.DeleteOne(sessCtx, filter, opts)
+	return mongoGet(transaction.context(), col, id, document)
+}
 
-				if err != nil {
+//Query dbtransaction query
+func (transaction *MongoTransaction) Query(query engine.DBQuery) engine.DBQueryResult {
 
-					return nil, err
-				}
-			} else if item.command == "del_collection" {
+	col := transaction.mongoClient.getCollection(transaction.database, query.Collection, false)
 
-				err := col.Drop(sessCtx)
-				if err != nil {
+	return transaction.pool.query(transaction.context(), col, query)
+}
 
-					return nil, err
-				}
-			}
-		}
+//Commit dbtransaction commit, it does nothing inside RunInTransaction which commit when fn return
+func (transaction *MongoTransaction) Commit() (err error) {
+
+	if transaction.session != nil {
+
+		return nil
+	}
+	if transaction.IsClosed() {
+
+		return engine.TransactionClosed
+	}
+	ctx, done := transaction.pool.observe(context.Background(), "mongo", "transcommit", "", "")
+	defer func() { done(err, transaction.Len()) }()
+
+	if transaction.options.Timeout > 0 {
 
-		return nil, nil
+		var cancel context.CancelFunc = nil
+		ctx, cancel = context.WithTimeout(ctx, transaction.options.Timeout)
+		defer cancel()
+	}
+
+	transaction.pool.getLogger().Debug("mongo transaction commit", "items", transaction.Len())
+
+	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
+
+		return nil, transaction.apply(sessCtx)
 	}
 
 	session, err := transaction.mongoClient.client.StartSession()
@@ -752,16 +1245,122 @@ func (transaction *MongoTransaction) Commit() error {
 	}
 	defer session.EndSession(ctx)
 
-	result, err := session.WithTransaction(ctx, callback)
+	_, err = session.WithTransaction(ctx, callback, buildMongoTransactionOptions(transaction.options))
 
 	if err != nil {
 		return err
 	}
+	if err := transaction.dropCollections(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//apply write items inside session, collections are dropped later by dropCollections
+func (transaction *MongoTransaction) apply(sessCtx mongo.SessionContext) error {
+
+	dropped := map[string]bool{}
+	// Important: You must pass sessCtx as the Context parameter to the operations for them to be executed in the
+	// transaction.
+	for _, item := range transaction.Items() {
+
+		col := transaction.mongoClient.getCollection(transaction.database, item.Collection, false)
+
+		if col == nil {
+
+			return errors.New("get collection fail")
+		}
+		if item.Command == engine.TransactionDelCollection {
+
+			dropped[item.Collection] = true
+			continue
+		}
+		if dropped[item.Collection] {
+
+			return fmt.Errorf("%w: %s", ErrMongoWriteAfterDrop, item.Collection)
+		}
+
+		if item.Command == engine.TransactionPut {
+
+			opts := options.Update().SetUpsert(true)
+
+			filter := bson.D{bson.E{Key: "__id", Value: item.ID}}
+
+			update := bson.M{
+				"$set": item.Document,
+			}
+
+			_, err := col.UpdateOne(sessCtx, filter, update, opts)
+
+			if err != nil {
+				return err
+			}
+		} else if item.Command == engine.TransactionUpdate {
+
+			update := item.Document.(engine.Update)
+
+			mongoUpdate, err := buildMongoUpdate(&update)
+
+			if err != nil {
+
+				return err
+			}
+			opts := options.Update().SetUpsert(true)
+
+			filter := bson.D{bson.E{Key: "__id", Value: item.ID}}
+
+			_, err = col.UpdateOne(sessCtx, filter, mongoUpdate, opts)
+
+			if err != nil {
+
+				return err
+			}
+		} else if item.Command == engine.TransactionDel {
+
+			opts := &options.DeleteOptions{}
+
+			filter := bson.M{"__id": item.ID}
+
+			_, err := col.DeleteOne(sessCtx, filter, opts)
+
+			if err != nil {
+
+				return err
+			}
+		} else if item.Command == engine.TransactionCreateCollection {
+
+			err := col.Database().CreateCollection(sessCtx, item.Collection)
+			if err != nil {
+
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//dropCollections drop collections after the transaction is committed because mongodb does not allow drop inside a transaction.
+//Writes of the transaction stay committed if a drop fail so the error wrap engine.TransactionPartialCommit.
+func (transaction *MongoTransaction) dropCollections(ctx context.Context) error {
+
+	for _, item := range transaction.Items() {
+
+		if item.Command != engine.TransactionDelCollection {
+
+			continue
+		}
+		col := transaction.mongoClient.getCollection(transaction.database, item.Collection, false)
 
-	fmt.Printf("result: %v\n", result)
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb transcommit %0.2fms\n", float32(delta)/1_000_000)
+		if err := col.Drop(ctx); err != nil {
+
+			return fmt.Errorf("%w: drop collection %s: %v", engine.TransactionPartialCommit, item.Collection, err)
+		}
+		for _, client := range transaction.pool.clients {
+
+			client.cleanCacheCollection(transaction.database, item.Collection)
+		}
 	}
 	return nil
 }
@@ -785,6 +1384,95 @@ func (pool *MongoPool) CreateCollection(collection string) error {
 	return pool.First().client.Database(pool.database).CreateCollection(ctx, collection)
 }
 
+//MARK: Work with index
+
+//EnsureIndex create index if it's not existed
+func (pool *MongoPool) EnsureIndex(collection string, index engine.IndexSpec) error {
+
+	if err := index.Validate(); err != nil {
+
+		return err
+	}
+	ctx := context.Background()
+	col := pool.First().getCollection(pool.database, collection, true)
+
+	keys := bson.D{}
+	for _, field := range index.Fields {
+		if field.Inscrease {
+			keys = append(keys, bson.E{Key: field.Field, Value: 1})
+		} else {
+			keys = append(keys, bson.E{Key: field.Field, Value: -1})
+		}
+	}
+	opts := options.Index().SetName(index.GetName())
+	if index.Unique {
+		opts = opts.SetUnique(true)
+	}
+	if index.Sparse {
+		opts = opts.SetSparse(true)
+	}
+	if index.TTL {
+		opts = opts.SetExpireAfterSeconds(int32(index.ExpireAfter.Seconds()))
+	}
+	if index.Partial != nil {
+		opts = opts.SetPartialFilterExpression(pool.buildQueryAnd(index.Partial))
+	}
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return err
+}
+
+type mongoIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+//ListIndexes list indexes of collection, the default _id index is not listed.
+//Partial filter can not be converted back so it is not reported.
+func (pool *MongoPool) ListIndexes(collection string) ([]engine.IndexSpec, error) {
+
+	ctx := context.Background()
+	col := pool.First().getCollection(pool.database, collection, true)
+
+	cursor, err := col.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoIndex
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	indexes := []engine.IndexSpec{}
+	for _, result := range results {
+		if result.Name == "_id_" {
+			continue
+		}
+		index := engine.IndexSpec{Name: result.Name, Unique: result.Unique, Sparse: result.Sparse}
+		for _, key := range result.Key {
+			direction := fmt.Sprintf("%v", key.Value)
+			index.Fields = append(index.Fields, engine.IndexField{Field: key.Key, Inscrease: !strings.HasPrefix(direction, "-")})
+		}
+		if result.ExpireAfterSeconds != nil {
+			index.TTL = true
+			index.ExpireAfter = time.Duration(*result.ExpireAfterSeconds) * time.Second
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+//DropIndex drop index by name
+func (pool *MongoPool) DropIndex(collection string, name string) error {
+
+	ctx := context.Background()
+	col := pool.First().getCollection(pool.database, collection, true)
+
+	_, err := col.Indexes().DropOne(ctx, name)
+	return err
+}
+
 type varyMapInt struct {
 	ID    int64 `bson:"_id"`
 	Count int   `bson:"Count"`
@@ -795,15 +1483,14 @@ type varyMapString struct {
 }
 
 //MARK:
-func (pool *MongoPool) CollectVaryInt(collection string, field string) (map[string]int, error) {
-	now := time.Now()
+func (pool *MongoPool) CollectVaryInt(collection string, field string) (vary map[string]int, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "collectvaryint", collection, "")
+	defer func() { done(err, len(vary)) }()
 	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 	if col == nil {
 		return nil, errors.New("get collection fail")
 	}
 
-	ctx := context.TODO()
-
 	opts := options.Aggregate()
 
 	groupStage := bson.D{{"$group", bson.D{{"_id", fmt.Sprintf("$%s", field)}, {"Count", bson.D{{"$sum", 1}}}}}}
@@ -820,22 +1507,17 @@ func (pool *MongoPool) CollectVaryInt(collection string, field string) (map[stri
 	for _, item := range results {
 		resultMap[strconv.FormatInt(item.ID, 10)] = item.Count
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb collectvary %s(%s) %0.2fms\n", collection, field, float32(delta)/1_000_000)
-	}
 	return resultMap, nil
 }
 
-func (pool *MongoPool) CollectVaryString(collection string, field string) (map[string]int, error) {
-	now := time.Now()
+func (pool *MongoPool) CollectVaryString(collection string, field string) (vary map[string]int, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "collectvarystring", collection, "")
+	defer func() { done(err, len(vary)) }()
 	col := pool.SelectRobin().getCollection(pool.database, collection, true)
 	if col == nil {
 		return nil, errors.New("get collection fail")
 	}
 
-	ctx := context.TODO()
-
 	opts := options.Aggregate()
 
 	groupStage := bson.D{{"$group", bson.D{{"_id", fmt.Sprintf("$%s", field)}, {"Count", bson.D{{"$sum", 1}}}}}}
@@ -852,21 +1534,16 @@ func (pool *MongoPool) CollectVaryString(collection string, field string) (map[s
 	for _, item := range results {
 		resultMap[item.ID] = item.Count
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb collectvary %s(%s) %0.2fms\n", collection, field, float32(delta)/1_000_000)
-	}
 	return resultMap, nil
 }
-func (pool *MongoPool) CollectVaryQueryInt(query engine.DBQuery, field string) (map[string]int, error) {
-	now := time.Now()
+func (pool *MongoPool) CollectVaryQueryInt(query engine.DBQuery, field string) (vary map[string]int, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "collectvaryqueryint", query.Collection, "")
+	defer func() { done(err, len(vary)) }()
 	col := pool.SelectRobin().getCollection(pool.database, query.Collection, true)
 	if col == nil {
 		return nil, errors.New("get collection fail")
 	}
 
-	ctx := context.TODO()
-
 	opts := options.Aggregate()
 	filter := pool.buildQueryAnd(query.Condition)
 	matchState := bson.D{{"$match", filter}}
@@ -884,22 +1561,17 @@ func (pool *MongoPool) CollectVaryQueryInt(query engine.DBQuery, field string) (
 	for _, item := range results {
 		resultMap[strconv.FormatInt(item.ID, 10)] = item.Count
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb collectvary %s(%s) %0.2fms\n", query.Collection, field, float32(delta)/1_000_000)
-	}
 	return resultMap, nil
 }
 
-func (pool *MongoPool) CollectVaryQueryString(query engine.DBQuery, field string) (map[string]int, error) {
-	now := time.Now()
+func (pool *MongoPool) CollectVaryQueryString(query engine.DBQuery, field string) (vary map[string]int, err error) {
+	ctx, done := pool.observe(context.Background(), "mongo", "collectvaryquerystring", query.Collection, "")
+	defer func() { done(err, len(vary)) }()
 	col := pool.SelectRobin().getCollection(pool.database, query.Collection, true)
 	if col == nil {
 		return nil, errors.New("get collection fail")
 	}
 
-	ctx := context.TODO()
-
 	opts := options.Aggregate()
 	filter := pool.buildQueryAnd(query.Condition)
 
@@ -917,9 +1589,5 @@ func (pool *MongoPool) CollectVaryQueryString(query engine.DBQuery, field string
 	for _, item := range results {
 		resultMap[item.ID] = item.Count
 	}
-	if __measurement {
-		delta := time.Now().Sub(now).Nanoseconds()
-		fmt.Printf("mersure docdb collectvary %s(%s) %0.2fms\n", query.Collection, field, float32(delta)/1_000_000)
-	}
 	return resultMap, nil
 }
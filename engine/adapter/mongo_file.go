@@ -1,5 +1,7 @@
 package adapter
 
+import "context"
+
 //MongoFile wrap file
 type MongoFile struct {
 	Path string  `bson:"path"`
@@ -14,6 +16,7 @@ func (file MongoFile) GetID() string {
 
 //MongoFilePool mongo file pool
 type MongoFilePool struct {
+	observable
 	mongoPool  *MongoPool
 	collection string
 }
@@ -23,25 +26,59 @@ func (pool *MongoFilePool) Init(collection string, mongoPool *MongoPool) {
 	pool.mongoPool = mongoPool
 	pool.collection = collection
 }
+//Ping ping the mongo pool
+func (pool MongoFilePool) Ping(ctx context.Context) error {
+
+	return pool.mongoPool.Ping(ctx)
+}
+
+//Close does not close the mongo pool because it is shared, it must be closed by its owner
+func (pool MongoFilePool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+//IsTransientError classify err as the mongo pool
+func (pool MongoFilePool) IsTransientError(err error) bool {
+
+	return pool.mongoPool.IsTransientError(err)
+}
+
 func (pool MongoFilePool) Read(path string) (*[]byte, error) {
 
+	_, done := pool.observe(context.Background(), "mongo_file", "read", pool.collection, path)
+
 	file := MongoFile{}
 
 	if err := pool.mongoPool.Get(pool.collection, path, &file); err == nil {
 
+		size := 0
+		if file.Data != nil {
+			size = len(*file.Data)
+		}
+		done(nil, size)
 		return file.Data, nil
 	}
+	done(nil, 0)
 	return nil, nil
 }
 
 func (pool MongoFilePool) Write(path string, content *[]byte) error {
 
+	_, done := pool.observe(context.Background(), "mongo_file", "write", pool.collection, path)
+
 	file := MongoFile{Path: path, Data: content}
 
-	return pool.mongoPool.Put(pool.collection, &file)
+	err := pool.mongoPool.Put(pool.collection, &file)
+	done(err, 0)
+	return err
 }
 
 func (pool MongoFilePool) Delete(path string) error {
 
-	return pool.mongoPool.Del(pool.collection, path)
+	_, done := pool.observe(context.Background(), "mongo_file", "delete", pool.collection, path)
+
+	err := pool.mongoPool.Del(pool.collection, path)
+	done(err, 0)
+	return err
 }
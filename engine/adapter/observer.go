@@ -0,0 +1,78 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine"
+)
+
+//observable is embedded by pools to implement engine.ObserverSetter
+type observable struct {
+	observer engine.Observer
+}
+
+//SetObserver set observer of pool
+func (pool *observable) SetObserver(observer engine.Observer) {
+
+	pool.observer = observer
+}
+
+//observe start an operation, name is the lowercased method name. The returned func end the operation
+//with its error and result size. When no observer is set and measurement is active the operation is printed.
+func (pool *observable) observe(ctx context.Context, adapter string, name string, collection string, id string) (context.Context, func(err error, size int)) {
+
+	observer := pool.observer
+	if observer == nil {
+
+		if !__measurement {
+
+			return ctx, func(err error, size int) {}
+		}
+		observer = measurementObserver{}
+	}
+	op := &engine.Operation{Pool: adapter, Name: name, Collection: collection, ID: id, Start: time.Now()}
+
+	ctx = observer.Before(ctx, op)
+
+	return ctx, func(err error, size int) {
+
+		op.Duration = time.Since(op.Start)
+		op.Err = err
+		op.ResultSize = size
+		observer.After(ctx, op)
+	}
+}
+
+//measurementObserver print duration of operations, it is used by SetMeasurement
+type measurementObserver struct{}
+
+func (measurementObserver) Before(ctx context.Context, op *engine.Operation) context.Context {
+
+	return ctx
+}
+
+func (measurementObserver) After(ctx context.Context, op *engine.Operation) {
+
+	target := op.ID
+	if op.Collection != "" && op.ID != "" {
+
+		target = op.Collection + "." + op.ID
+
+	} else if op.Collection != "" {
+
+		target = op.Collection
+	}
+	fmt.Printf("mersure %s %s %s %0.2fms\n", op.Pool, op.Name, target, float32(op.Duration.Nanoseconds())/1_000_000)
+}
+
+//documentSize is the result size of getting a document
+func documentSize(err error) int {
+
+	if err != nil {
+
+		return 0
+	}
+	return 1
+}
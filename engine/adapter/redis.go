@@ -2,7 +2,10 @@ package adapter
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -12,11 +15,15 @@ import (
 
 //RedisPool redis pool
 type RedisPool struct {
+	loggable
+	observable
 	clients         []*redis.Client
 	roundRobinCount int
 	segment         []int
 	roundPools      []int
 	segmentBegin    []int
+	//PingTimeout when it's set Init ping every client and fail with ErrConnect if a ping fail
+	PingTimeout time.Duration
 }
 
 //First get first client
@@ -51,9 +58,15 @@ func (pool *RedisPool) SelectRobin() *redis.Client {
 	return pool.clients[index]
 }
 
+//ShardOf return the segment of clients that SelectShading select for key, other methods use segment 0
+func (pool *RedisPool) ShardOf(key string) int {
+
+	return hashByKey(key) % len(pool.segmentBegin)
+}
+
 func (pool *RedisPool) SelectShading(key string) *redis.Client {
 
-	poolID := hashByKey(key) % len(pool.segmentBegin)
+	poolID := pool.ShardOf(key)
 
 	var round *int = &pool.roundPools[poolID]
 	*round++
@@ -63,183 +76,338 @@ func (pool *RedisPool) SelectShading(key string) *redis.Client {
 	return pool.clients[index]
 }
 
-// MARK: implement MemPool
-//Init init pool from connection string
-func (pool *RedisPool) Init(connectionString string) error {
+//RedisServer a segment of clients those connect to the same server
+type RedisServer struct {
+	Address  string
+	Username string
+	Password string
+	//DB is ignored by RedisClusterPool
+	DB int
+	//NumClient number of clients connect to the server, default 1
+	NumClient int
+}
 
-	clients := strings.Split(connectionString, ",")
-	last := 0
-	for _, client := range clients {
-		var numClient = 1
-		hasNumClient := strings.Index(client, "[")
-
-		if hasNumClient > 0 {
-			end := strings.Index(client, "]")
-			if end > hasNumClient {
-				numString := client[hasNumClient+1 : end]
-				if tryParse, err := strconv.ParseInt(numString, 10, 64); err == nil {
-					numClient = int(tryParse)
-				}
-			}
-			client = client[0:hasNumClient]
+//RedisOptions typed options of RedisPool and RedisClusterPool, zero fields take go-redis defaults
+type RedisOptions struct {
+	Servers      []RedisServer
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	TLS          *tls.Config
+	//PingTimeout override PingTimeout of the pool when it's set
+	PingTimeout time.Duration
+}
+
+//parseRedisConnectionString parse password@host:port/db[numClient],... connection string
+func parseRedisConnectionString(adapter string, connectionString string) (RedisOptions, error) {
+
+	options := RedisOptions{}
+
+	for _, client := range strings.Split(connectionString, ",") {
+
+		client, numClient, err := parseNumClient(strings.TrimSpace(client))
+		if err != nil {
+			return options, newInvalidConnectionString(adapter, "%v", err)
 		}
-		var database = 0
+		server := RedisServer{NumClient: numClient}
 
 		parts := strings.Split(client, "/")
 		if len(parts) == 2 {
 			client = parts[0]
-			if tryDb, err := strconv.Atoi(parts[1]); err == nil {
-				database = tryDb
+			tryDb, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return options, newInvalidConnectionString(adapter, "invalid database %s", parts[1])
 			}
+			server.DB = tryDb
 		}
-		password := ""
 		parts = strings.Split(client, "@")
 		if len(parts) == 2 {
-			password = parts[0]
+			server.Password = parts[0]
 			client = parts[1]
 		}
+		server.Address = client
+		options.Servers = append(options.Servers, server)
+	}
+	return options, nil
+}
+
+// MARK: implement MemPool
+//Init init pool from connection string password@host:port/db[numClient],...
+//every server is a segment of clients, *Shading methods spread keys on segments
+func (pool *RedisPool) Init(connectionString string) error {
+
+	options, err := parseRedisConnectionString("redis", connectionString)
+	if err != nil {
+		return err
+	}
+	return pool.InitWithOptions(options)
+}
+
+//InitWithOptions init pool from typed options
+func (pool *RedisPool) InitWithOptions(options RedisOptions) error {
+
+	if len(options.Servers) == 0 {
+		return newInvalidConnectionString("redis", "no server")
+	}
+	last := 0
+	for _, server := range options.Servers {
+
+		if server.Address == "" {
+			pool.Close(context.Background())
+			return newInvalidConnectionString("redis", "empty address")
+		}
+		numClient := server.NumClient
+		if numClient < 1 {
+			numClient = 1
+		}
 
 		for i := 0; i < numClient; i++ {
 			var redisClient = redis.NewClient(&redis.Options{
 
-				Addr:     client,
-				Password: password,
-				DB:       database,
+				Addr:         server.Address,
+				Username:     server.Username,
+				Password:     server.Password,
+				DB:           server.DB,
+				PoolSize:     options.PoolSize,
+				DialTimeout:  options.DialTimeout,
+				ReadTimeout:  options.ReadTimeout,
+				WriteTimeout: options.WriteTimeout,
+				TLSConfig:    options.TLS,
 			})
 
 			pool.clients = append(pool.clients, redisClient)
 
-			fmt.Println("redis new client ", client)
+			pool.getLogger().Debug("redis new client", "address", server.Address)
 		}
 		pool.segment = append(pool.segment, numClient)
 		pool.roundPools = append(pool.roundPools, 0)
 		pool.segmentBegin = append(pool.segmentBegin, last)
 		last += numClient
 	}
-	fmt.Println("redis pool ", len(pool.clients), " clients.")
+	if options.PingTimeout > 0 {
+		pool.PingTimeout = options.PingTimeout
+	}
+	pool.getLogger().Info("redis pool init", "clients", len(pool.clients))
+	return pingOnInit("redis", pool, pool.PingTimeout)
+}
+
+//Ping ping every client
+func (pool *RedisPool) Ping(ctx context.Context) error {
+
+	for _, client := range pool.clients {
+
+		if err := client.Ping(ctx).Err(); err != nil {
+
+			return err
+		}
+	}
 	return nil
 }
 
+//Close close every client
+func (pool *RedisPool) Close(ctx context.Context) error {
+
+	var firstErr error = nil
+
+	for _, client := range pool.clients {
+
+		if err := client.Close(); err != nil && firstErr == nil {
+
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 //Set set key
 func (pool *RedisPool) Set(key string, value string) error {
 
-	return pool.First().Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "set", "", key)
+	err := pool.First().Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisPool) SetInt(key string, value int64) error {
 
-	return pool.First().Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setint", "", key)
+	err := pool.First().Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisPool) IncrInt(key string) (int64, error) {
 
-	return pool.First().Incr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "incrint", "", key)
+	result, err := pool.First().Incr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisPool) DecrInt(key string) (int64, error) {
 
-	return pool.First().Decr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "decrint", "", key)
+	result, err := pool.First().Decr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisPool) IncrIntBy(key string, num int64) (int64, error) {
 
-	return pool.First().IncrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "incrintby", "", key)
+	result, err := pool.First().IncrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisPool) DecrIntBy(key string, num int64) (int64, error) {
 
-	return pool.First().DecrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "decrintby", "", key)
+	result, err := pool.First().DecrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 //MARK: Shading
 //SetShading select pool by shading the key
 func (pool *RedisPool) SetShading(key string, value string) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisPool) SetIntShading(key string, value int64) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setintshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisPool) IncrIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).Incr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "incrintshading", "", key)
+	result, err := pool.SelectShading(key).Incr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisPool) DescIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).Decr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "descintshading", "", key)
+	result, err := pool.SelectShading(key).Decr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 
 func (pool *RedisPool) IncrIntByShading(key string, num int64) (int64, error) {
 
-	return pool.SelectShading(key).IncrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "incrintbyshading", "", key)
+	result, err := pool.SelectShading(key).IncrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 func (pool *RedisPool) DecrIntByShading(key string, num int64) (int64, error) {
 
-	return pool.SelectShading(key).DecrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "decrintbyshading", "", key)
+	result, err := pool.SelectShading(key).DecrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 //SetExpire set key
 func (pool *RedisPool) SetExpire(key string, value string, d time.Duration) error {
 
-	return pool.First().Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setexpire", "", key)
+	err := pool.First().Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisPool) SetIntExpire(key string, value int64, d time.Duration) error {
 
-	return pool.First().Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setintexpire", "", key)
+	err := pool.First().Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 //SetExpire set key with expire
 func (pool *RedisPool) SetExpireShading(key string, value string, d time.Duration) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setexpireshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 func (pool *RedisPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "redis", "setintexpireshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 //Get get from key
 func (pool *RedisPool) Get(key string) (string, error) {
 
-	return pool.First().Get(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "get", "", key)
+	result, err := pool.First().Get(ctx, key).Result()
+	done(err, len(result))
+	return result, err
 }
 func (pool *RedisPool) GetInt(key string) (int64, error) {
-	return pool.First().IncrBy(context.Background(), key, 0).Result()
+
+	ctx, done := pool.observe(context.Background(), "redis", "getint", "", key)
+	result, err := pool.First().IncrBy(ctx, key, 0).Result()
+	done(err, 0)
+	return result, err
 }
 
 //GetShading get from key that set by shading
 func (pool *RedisPool) GetShading(key string) (string, error) {
 
-	return pool.SelectShading(key).Get(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "getshading", "", key)
+	result, err := pool.SelectShading(key).Get(ctx, key).Result()
+	done(err, len(result))
+	return result, err
 }
 
 func (pool *RedisPool) GetIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).IncrBy(context.Background(), key, 0).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "getintshading", "", key)
+	result, err := pool.SelectShading(key).IncrBy(ctx, key, 0).Result()
+	done(err, 0)
+	return result, err
 }
 
 //Del delete session
 func (pool *RedisPool) Del(key string) error {
 
-	_, err := pool.First().Del(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "del", "", key)
+	_, err := pool.First().Del(ctx, key).Result()
+	done(err, 0)
 	return err
 }
 
 //Del delete a key that set by shading
 func (pool *RedisPool) DelShading(key string) error {
 
-	_, err := pool.SelectShading(key).Del(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "redis", "delshading", "", key)
+	_, err := pool.SelectShading(key).Del(ctx, key).Result()
+	done(err, 0)
 	return err
 }
 
-func (pool *RedisPool) FindKey(keyPattern string) ([]string, error) {
+func (pool *RedisPool) FindKey(keyPattern string) (keys []string, err error) {
 
-	keys := []string{}
+	ctx, done := pool.observe(context.Background(), "redis", "findkey", "", keyPattern)
+	defer func() { done(err, len(keys)) }()
+
+	keys = []string{}
 
 	for poolID := 0; poolID < len(pool.segmentBegin); poolID++ {
 
 		cursor := uint64(0)
 		var findMax = int64(100)
 		for {
-			cmd := pool.SelectID(poolID).Scan(context.Background(), cursor, keyPattern, findMax)
+			cmd := pool.SelectID(poolID).Scan(ctx, cursor, keyPattern, findMax)
 			rkeys, rcursor, rerr := cmd.Result()
 			if rerr != nil {
 				return nil, rerr
@@ -268,3 +436,42 @@ func (pool *RedisPool) IsNotExistedError(err error) bool {
 
 	return err == redis.Nil
 }
+
+//IsTransientError check if err is caused by network or a failover, see isRedisTransientError
+func (pool *RedisPool) IsTransientError(err error) bool {
+
+	return isRedisTransientError(err)
+}
+
+//__redis_transient_prefixes replies of a server that is loading, failing over or resharding
+var __redis_transient_prefixes = []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "MOVED", "ASK"}
+
+func isRedisTransientError(err error) bool {
+
+	if err == nil || err == redis.Nil || err == redis.ErrClosed || errors.Is(err, context.Canceled) {
+
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+
+		message := redisErr.Error()
+		for _, prefix := range __redis_transient_prefixes {
+
+			if strings.HasPrefix(message, prefix+" ") || message == prefix {
+
+				return true
+			}
+		}
+	}
+	return false
+}
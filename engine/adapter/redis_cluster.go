@@ -2,9 +2,6 @@ package adapter
 
 import (
 	"context"
-	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
@@ -12,11 +9,15 @@ import (
 
 //RedisPool redis pool
 type RedisClusterPool struct {
+	loggable
+	observable
 	clients         []*redis.ClusterClient
 	roundRobinCount int
 	segment         []int
 	roundPools      []int
 	segmentBegin    []int
+	//PingTimeout when it's set Init ping every client and fail with ErrConnect if a ping fail
+	PingTimeout time.Duration
 }
 
 //First get first client
@@ -51,9 +52,15 @@ func (pool *RedisClusterPool) SelectRobin() *redis.ClusterClient {
 	return pool.clients[index]
 }
 
+//ShardOf return the segment of clients that SelectShading select for key, other methods use segment 0
+func (pool *RedisClusterPool) ShardOf(key string) int {
+
+	return hashByKey(key) % len(pool.segmentBegin)
+}
+
 func (pool *RedisClusterPool) SelectShading(key string) *redis.ClusterClient {
 
-	poolID := hashByKey(key) % len(pool.segmentBegin)
+	poolID := pool.ShardOf(key)
 
 	var round *int = &pool.roundPools[poolID]
 	*round++
@@ -64,181 +71,279 @@ func (pool *RedisClusterPool) SelectShading(key string) *redis.ClusterClient {
 }
 
 // MARK: implement MemPool
-//Init init pool from connection string
+//Init init pool from connection string password@host:port[numClient],..., database is ignored
 func (pool *RedisClusterPool) Init(connectionString string) error {
 
-	clients := strings.Split(connectionString, ",")
+	options, err := parseRedisConnectionString("rediscluster", connectionString)
+	if err != nil {
+		return err
+	}
+	return pool.InitWithOptions(options)
+}
+
+//InitWithOptions init pool from typed options, DB of servers is ignored
+func (pool *RedisClusterPool) InitWithOptions(options RedisOptions) error {
+
+	if len(options.Servers) == 0 {
+		return newInvalidConnectionString("rediscluster", "no server")
+	}
 	last := 0
-	for _, client := range clients {
-		var numClient = 1
-		hasNumClient := strings.Index(client, "[")
-
-		if hasNumClient > 0 {
-			end := strings.Index(client, "]")
-			if end > hasNumClient {
-				numString := client[hasNumClient+1 : end]
-				if tryParse, err := strconv.ParseInt(numString, 10, 64); err == nil {
-					numClient = int(tryParse)
-				}
-			}
-			client = client[0:hasNumClient]
-		}
-		//var database = 0
-
-		parts := strings.Split(client, "/")
-		if len(parts) == 2 {
-			client = parts[0]
-			//if tryDb, err := strconv.Atoi(parts[1]); err == nil {
-			//database = tryDb
-			//}
+	for _, server := range options.Servers {
+
+		if server.Address == "" {
+			pool.Close(context.Background())
+			return newInvalidConnectionString("rediscluster", "empty address")
 		}
-		password := ""
-		parts = strings.Split(client, "@")
-		if len(parts) == 2 {
-			password = parts[0]
-			client = parts[1]
+		numClient := server.NumClient
+		if numClient < 1 {
+			numClient = 1
 		}
 
 		for i := 0; i < numClient; i++ {
 			var redisClient = redis.NewClusterClient(&redis.ClusterOptions{
 
-				Addrs:    []string{client},
-				Password: password,
+				Addrs:        []string{server.Address},
+				Username:     server.Username,
+				Password:     server.Password,
+				PoolSize:     options.PoolSize,
+				DialTimeout:  options.DialTimeout,
+				ReadTimeout:  options.ReadTimeout,
+				WriteTimeout: options.WriteTimeout,
+				TLSConfig:    options.TLS,
 			})
 
 			pool.clients = append(pool.clients, redisClient)
 
-			fmt.Println("redis new client ", client)
+			pool.getLogger().Debug("redis cluster new client", "address", server.Address)
 		}
 		pool.segment = append(pool.segment, numClient)
 		pool.roundPools = append(pool.roundPools, 0)
 		pool.segmentBegin = append(pool.segmentBegin, last)
 		last += numClient
 	}
-	fmt.Println("redis pool ", len(pool.clients), " clients.")
+	if options.PingTimeout > 0 {
+		pool.PingTimeout = options.PingTimeout
+	}
+	pool.getLogger().Info("redis cluster pool init", "clients", len(pool.clients))
+	return pingOnInit("rediscluster", pool, pool.PingTimeout)
+}
+
+//Ping ping every client
+func (pool *RedisClusterPool) Ping(ctx context.Context) error {
+
+	for _, client := range pool.clients {
+
+		if err := client.Ping(ctx).Err(); err != nil {
+
+			return err
+		}
+	}
 	return nil
 }
 
+//Close close every client
+func (pool *RedisClusterPool) Close(ctx context.Context) error {
+
+	var firstErr error = nil
+
+	for _, client := range pool.clients {
+
+		if err := client.Close(); err != nil && firstErr == nil {
+
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 //Set set key
 func (pool *RedisClusterPool) Set(key string, value string) error {
 
-	return pool.First().Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "set", "", key)
+	err := pool.First().Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisClusterPool) SetInt(key string, value int64) error {
 
-	return pool.First().Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setint", "", key)
+	err := pool.First().Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisClusterPool) IncrInt(key string) (int64, error) {
 
-	return pool.First().Incr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "incrint", "", key)
+	result, err := pool.First().Incr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisClusterPool) DecrInt(key string) (int64, error) {
 
-	return pool.First().Decr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "decrint", "", key)
+	result, err := pool.First().Decr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisClusterPool) IncrIntBy(key string, num int64) (int64, error) {
 
-	return pool.First().IncrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "incrintby", "", key)
+	result, err := pool.First().IncrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisClusterPool) DecrIntBy(key string, num int64) (int64, error) {
 
-	return pool.First().DecrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "decrintby", "", key)
+	result, err := pool.First().DecrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 //MARK: Shading
 //SetShading select pool by shading the key
 func (pool *RedisClusterPool) SetShading(key string, value string) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisClusterPool) SetIntShading(key string, value int64) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, 0).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setintshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, 0).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisClusterPool) IncrIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).Incr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "incrintshading", "", key)
+	result, err := pool.SelectShading(key).Incr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 func (pool *RedisClusterPool) DescIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).Decr(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "descintshading", "", key)
+	result, err := pool.SelectShading(key).Decr(ctx, key).Result()
+	done(err, 0)
+	return result, err
 }
 
 func (pool *RedisClusterPool) IncrIntByShading(key string, num int64) (int64, error) {
 
-	return pool.SelectShading(key).IncrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "incrintbyshading", "", key)
+	result, err := pool.SelectShading(key).IncrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 func (pool *RedisClusterPool) DecrIntByShading(key string, num int64) (int64, error) {
 
-	return pool.SelectShading(key).DecrBy(context.Background(), key, num).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "decrintbyshading", "", key)
+	result, err := pool.SelectShading(key).DecrBy(ctx, key, num).Result()
+	done(err, 0)
+	return result, err
 }
 
 //SetExpire set key
 func (pool *RedisClusterPool) SetExpire(key string, value string, d time.Duration) error {
 
-	return pool.First().Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setexpire", "", key)
+	err := pool.First().Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 func (pool *RedisClusterPool) SetIntExpire(key string, value int64, d time.Duration) error {
 
-	return pool.First().Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setintexpire", "", key)
+	err := pool.First().Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 //SetExpire set key with expire
 func (pool *RedisClusterPool) SetExpireShading(key string, value string, d time.Duration) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setexpireshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 func (pool *RedisClusterPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
 
-	return pool.SelectShading(key).Set(context.Background(), key, value, d).Err()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "setintexpireshading", "", key)
+	err := pool.SelectShading(key).Set(ctx, key, value, d).Err()
+	done(err, 0)
+	return err
 }
 
 //Get get from key
 func (pool *RedisClusterPool) Get(key string) (string, error) {
 
-	return pool.First().Get(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "get", "", key)
+	result, err := pool.First().Get(ctx, key).Result()
+	done(err, len(result))
+	return result, err
 }
 func (pool *RedisClusterPool) GetInt(key string) (int64, error) {
-	return pool.First().IncrBy(context.Background(), key, 0).Result()
+
+	ctx, done := pool.observe(context.Background(), "rediscluster", "getint", "", key)
+	result, err := pool.First().IncrBy(ctx, key, 0).Result()
+	done(err, 0)
+	return result, err
 }
 
 //GetShading get from key that set by shading
 func (pool *RedisClusterPool) GetShading(key string) (string, error) {
 
-	return pool.SelectShading(key).Get(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "getshading", "", key)
+	result, err := pool.SelectShading(key).Get(ctx, key).Result()
+	done(err, len(result))
+	return result, err
 }
 
 func (pool *RedisClusterPool) GetIntShading(key string) (int64, error) {
 
-	return pool.SelectShading(key).IncrBy(context.Background(), key, 0).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "getintshading", "", key)
+	result, err := pool.SelectShading(key).IncrBy(ctx, key, 0).Result()
+	done(err, 0)
+	return result, err
 }
 
 //Del delete session
 func (pool *RedisClusterPool) Del(key string) error {
 
-	_, err := pool.First().Del(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "del", "", key)
+	_, err := pool.First().Del(ctx, key).Result()
+	done(err, 0)
 	return err
 }
 
 //Del delete a key that set by shading
 func (pool *RedisClusterPool) DelShading(key string) error {
 
-	_, err := pool.SelectShading(key).Del(context.Background(), key).Result()
+	ctx, done := pool.observe(context.Background(), "rediscluster", "delshading", "", key)
+	_, err := pool.SelectShading(key).Del(ctx, key).Result()
+	done(err, 0)
 	return err
 }
 
-func (pool *RedisClusterPool) FindKey(keyPattern string) ([]string, error) {
+func (pool *RedisClusterPool) FindKey(keyPattern string) (keys []string, err error) {
+
+	ctx, done := pool.observe(context.Background(), "rediscluster", "findkey", "", keyPattern)
+	defer func() { done(err, len(keys)) }()
 
-	keys := []string{}
+	keys = []string{}
 
 	for poolID := 0; poolID < len(pool.segmentBegin); poolID++ {
 
 		cursor := uint64(0)
 		var findMax = int64(100)
 		for {
-			cmd := pool.SelectID(poolID).Scan(context.Background(), cursor, keyPattern, findMax)
+			cmd := pool.SelectID(poolID).Scan(ctx, cursor, keyPattern, findMax)
 			rkeys, rcursor, rerr := cmd.Result()
 			if rerr != nil {
 				return nil, rerr
@@ -259,3 +364,9 @@ func (pool *RedisClusterPool) IsNotExistedError(err error) bool {
 
 	return err == redis.Nil
 }
+
+//IsTransientError check if err is caused by network, a failover or resharding
+func (pool *RedisClusterPool) IsTransientError(err error) bool {
+
+	return isRedisTransientError(err)
+}
@@ -0,0 +1,443 @@
+package engine
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/tapvanvn/gocondition"
+)
+
+var AdminRequired = errors.New("operation needs a signed admin command")
+var AdminKeyNotSet = errors.New("admin public key is not set")
+var InvalidAdminKey = errors.New("invalid admin public key")
+var InvalidAdminCommand = errors.New("invalid admin command")
+var InvalidAdminSignature = errors.New("invalid admin signature")
+var AdminCommandExpired = errors.New("admin command is expired")
+var AdminCommandReplayed = errors.New("admin command was already used")
+
+const (
+	AdminOperationDelCollection = "del_collection"
+	AdminOperationDeleteWhere   = "delete_where"
+)
+
+//AdminCommandMaxTTL limit how far ExpiresAt of an admin command can be in the future
+var AdminCommandMaxTTL = 10 * time.Minute
+
+//AdminCommand authorize one destructive operation on a collection. It is signed by the admin private key
+//out of the workers, so a compromised worker can not drop data by itself.
+type AdminCommand struct {
+	Operation  string `json:"operation"`
+	Collection string `json:"collection"`
+	//Query the condition of AdminOperationDeleteWhere made by AdminQuery, a command only delete documents those match it
+	Query     string    `json:"query,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	//Nonce make the command single use. An engine reject a nonce it has seen until the command expire,
+	//nonces are only shared by processes those use the same pool of SetAdminNoncePool.
+	Nonce     string `json:"nonce"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+//Payload return the bytes those are signed, it is the json of operation, collection, query, expires_at in unix seconds and nonce
+func (command *AdminCommand) Payload() []byte {
+
+	payload, _ := json.Marshal([]interface{}{"godbengine-admin-v2", command.Operation, command.Collection, command.Query, command.ExpiresAt.Unix(), command.Nonce})
+	return payload
+}
+
+//AdminQuery return the canonical encoding of the condition of query that is signed in AdminCommand.Query.
+//Paging and sort are not encoded because DeleteWhere ignore them.
+func AdminQuery(query DBQuery) (string, error) {
+
+	if query.Condition == nil {
+
+		return "", InvalidAdminCommand
+	}
+	condition, err := canonicalRuleSet(query.Condition)
+	if err != nil {
+
+		return "", err
+	}
+	encoded, err := json.Marshal(condition)
+	if err != nil {
+
+		return "", InvalidAdminCommand
+	}
+	return string(encoded), nil
+}
+
+//canonicalRuleSet convert rule set to {"and"|"or": [rules]}, a filter is [field, operator, value]
+func canonicalRuleSet(ruleSet *gocondition.RuleSet) (map[string]interface{}, error) {
+
+	rules := []interface{}{}
+
+	for _, child := range ruleSet.Children {
+
+		switch child := child.(type) {
+		case *gocondition.RuleSet:
+			rule, err := canonicalRuleSet(child)
+			if err != nil {
+
+				return nil, err
+			}
+			rules = append(rules, rule)
+		case *DBFilterItem:
+			rules = append(rules, []interface{}{child.Field, child.Operator, normalizeValue(child.FieldValue)})
+		default:
+			return nil, InvalidAdminCommand
+		}
+	}
+	if ruleSet.IsOr() {
+
+		return map[string]interface{}{"or": rules}, nil
+	}
+	return map[string]interface{}{"and": rules}, nil
+}
+
+//SignAdminCommand sign command with an *rsa.PrivateKey (PKCS #1 v1.5 with SHA-256) or an ed25519.PrivateKey
+func SignAdminCommand(command *AdminCommand, signer crypto.Signer) error {
+
+	var err error = nil
+
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(command.Payload())
+		command.Signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		command.Signature, err = signer.Sign(rand.Reader, command.Payload(), crypto.Hash(0))
+	default:
+		return InvalidAdminKey
+	}
+	return err
+}
+
+//SetAdminPublicKey set the PEM encoded RSA or Ed25519 public key that verify admin commands.
+//After it is set DelCollection and DeleteWhere of every document pool of engine fail with AdminRequired,
+//they can only run by AdminDelCollection and AdminDeleteWhere.
+//Pools those are used directly without engine are not guarded.
+func (engine *Engine) SetAdminPublicKey(pemString string) error {
+
+	block, _ := pem.Decode([]byte(pemString))
+	if block == nil {
+
+		return InvalidAdminKey
+	}
+	var key interface{} = nil
+	var err error = nil
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		err = InvalidAdminKey
+	}
+	if err != nil {
+
+		return InvalidAdminKey
+	}
+
+	engine.adminMux.Lock()
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		engine.adminPublicKey = key
+		engine.adminEd25519Key = nil
+	case ed25519.PublicKey:
+		engine.adminPublicKey = nil
+		engine.adminEd25519Key = key
+	default:
+		engine.adminMux.Unlock()
+		return InvalidAdminKey
+	}
+	engine.adminPublicKeyString = pemString
+	engine.adminMux.Unlock()
+
+	engine.guardPools()
+	return nil
+}
+
+//GetAdminPublicKey get the PEM that was set by SetAdminPublicKey
+func (engine *Engine) GetAdminPublicKey() string {
+
+	engine.adminMux.Lock()
+	defer engine.adminMux.Unlock()
+
+	return engine.adminPublicKeyString
+}
+
+func (engine *Engine) hasAdminKey() bool {
+
+	engine.adminMux.Lock()
+	defer engine.adminMux.Unlock()
+
+	return engine.adminPublicKey != nil || engine.adminEd25519Key != nil
+}
+
+//VerifyAdminCommand check that command is signed by the admin key, is for operation, is not expired and was not used before
+func (engine *Engine) VerifyAdminCommand(command AdminCommand, operation string) error {
+
+	engine.adminMux.Lock()
+	publicKey, ed25519Key, noncePool := engine.adminPublicKey, engine.adminEd25519Key, engine.adminNoncePool
+	engine.adminMux.Unlock()
+
+	if publicKey == nil && ed25519Key == nil {
+
+		return AdminKeyNotSet
+	}
+	now := time.Now()
+
+	if command.Operation != operation || command.Collection == "" || command.Nonce == "" || command.ExpiresAt.Sub(now) > AdminCommandMaxTTL {
+
+		return InvalidAdminCommand
+	}
+	if (operation == AdminOperationDeleteWhere) == (command.Query == "") {
+
+		return InvalidAdminCommand
+	}
+	if !now.Before(command.ExpiresAt) {
+
+		return AdminCommandExpired
+	}
+	if publicKey != nil {
+
+		digest := sha256.Sum256(command.Payload())
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], command.Signature) != nil {
+
+			return InvalidAdminSignature
+		}
+	} else if !ed25519.Verify(ed25519Key, command.Payload(), command.Signature) {
+
+		return InvalidAdminSignature
+	}
+
+	//the shared pool is called without holding adminMux, it is a network round trip
+	if noncePool != nil {
+
+		return useSharedNonce(noncePool, command.Nonce, command.ExpiresAt.Sub(now))
+	}
+	engine.adminMux.Lock()
+	defer engine.adminMux.Unlock()
+
+	for nonce, expiresAt := range engine.adminNonces {
+		if !now.Before(expiresAt) {
+			delete(engine.adminNonces, nonce)
+		}
+	}
+	if _, ok := engine.adminNonces[command.Nonce]; ok {
+
+		return AdminCommandReplayed
+	}
+	if engine.adminNonces == nil {
+		engine.adminNonces = map[string]time.Time{}
+	}
+	engine.adminNonces[command.Nonce] = command.ExpiresAt
+	return nil
+}
+
+//SetAdminNoncePool record used nonces of admin commands in pool, usually a redis that is shared by every process,
+//so a command can only be used once by all of them and after a restart.
+//Without it nonces are remembered by the engine in memory and a command can be used once by each process
+//until it expire, keep ExpiresAt of commands short.
+func (engine *Engine) SetAdminNoncePool(pool MemPool) {
+
+	engine.adminMux.Lock()
+	defer engine.adminMux.Unlock()
+
+	engine.adminNoncePool = pool
+}
+
+//useSharedNonce claim nonce in pool by an atomic increment, only the first claim get 1
+func useSharedNonce(pool MemPool, nonce string, ttl time.Duration) error {
+
+	key := "godbengine:admin_nonce:" + nonce
+
+	count, err := pool.IncrInt(key)
+	if err != nil {
+
+		return err
+	}
+	if count != 1 {
+
+		return AdminCommandReplayed
+	}
+	//keep the claim until the command expire, a claim that raced with it has already seen a count above 1
+	return pool.SetIntExpire(key, 1, ttl+time.Second)
+}
+
+//AdminDelCollection delete command.Collection from the pool it is routed to after verifying command
+func (engine *Engine) AdminDelCollection(command AdminCommand) error {
+
+	if err := engine.VerifyAdminCommand(command, AdminOperationDelCollection); err != nil {
+
+		return err
+	}
+	pool := engine.DocumentPoolFor(command.Collection)
+	if pool == nil {
+
+		return NoPool
+	}
+	return unguardPool(pool).DelCollection(command.Collection)
+}
+
+//AdminDeleteWhere delete documents those match query after verifying command, query.Collection must be command.Collection
+//and the condition of query must be the signed command.Query
+func (engine *Engine) AdminDeleteWhere(command AdminCommand, query DBQuery) (int64, error) {
+
+	if query.Collection != command.Collection {
+
+		return 0, InvalidAdminCommand
+	}
+	if signed, err := AdminQuery(query); err != nil || signed != command.Query {
+
+		return 0, InvalidAdminCommand
+	}
+	if err := engine.VerifyAdminCommand(command, AdminOperationDeleteWhere); err != nil {
+
+		return 0, err
+	}
+	pool := engine.DocumentPoolFor(command.Collection)
+	if pool == nil {
+
+		return 0, NoPool
+	}
+	return unguardPool(pool).DeleteWhere(query)
+}
+
+//guardPools wrap document pools those are not guarded yet when the admin key is set.
+//A pool that is registered with several names get one guard, so the engine still see it as one pool.
+func (engine *Engine) guardPools() {
+
+	if !engine.hasAdminKey() {
+
+		return
+	}
+	engine.namedMux.Lock()
+	defer engine.namedMux.Unlock()
+
+	guarded := []DocumentPool{}
+	guard := func(pool DocumentPool) DocumentPool {
+
+		for _, other := range guarded {
+			if samePool(unguardPool(other), unguardPool(pool)) {
+				return other
+			}
+		}
+		pool = guardPool(pool)
+		if pool != nil {
+			guarded = append(guarded, pool)
+		}
+		return pool
+	}
+	engine.documentPool = guard(engine.documentPool)
+
+	for _, name := range sortedPoolNames(engine.documentPools) {
+
+		engine.documentPools[name] = guard(engine.documentPools[name])
+	}
+}
+
+func findAdminGuard(pool DocumentPool) *adminGuardPool {
+
+	var guard *adminGuardPool = nil
+
+	walkPool(pool, func(pool interface{}) bool {
+
+		guard, _ = pool.(*adminGuardPool)
+		return guard == nil
+	})
+	return guard
+}
+
+func guardPool(pool DocumentPool) DocumentPool {
+
+	if pool == nil || findAdminGuard(pool) != nil {
+
+		return pool
+	}
+	guard := &adminGuardPool{DocumentPoolWrapper: NewDocumentPoolWrapper(pool)}
+	guard.WrapTransaction = func(tx DBTransaction) DBTransaction {
+
+		return &adminGuardTransaction{DBTransaction: tx}
+	}
+	return guard
+}
+
+//unguardPool return the pool wrapped by the admin guard of pool
+func unguardPool(pool DocumentPool) DocumentPool {
+
+	if guard := findAdminGuard(pool); guard != nil {
+
+		return guard.DocumentPool
+	}
+	return pool
+}
+
+//adminGuardPool reject destructive operations those are not authorized by an admin command
+type adminGuardPool struct {
+	*DocumentPoolWrapper
+}
+
+//Rewrap guard next
+func (pool *adminGuardPool) Rewrap(next DocumentPool) DocumentPool {
+
+	return guardPool(next)
+}
+
+func (pool *adminGuardPool) DelCollection(collection string) error {
+
+	return AdminRequired
+}
+
+func (pool *adminGuardPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	return 0, AdminRequired
+}
+
+func (pool *adminGuardPool) RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error {
+
+	return pool.DocumentPoolWrapper.RunInTransaction(ctx, func(tx DBTransaction) error {
+
+		err := fn(tx)
+		if guard, ok := tx.(*adminGuardTransaction); ok && err == nil && guard.denied {
+
+			return AdminRequired
+		}
+		return err
+	}, options...)
+}
+
+//adminGuardTransaction drop DelCollection and fail Commit with AdminRequired when it was called
+type adminGuardTransaction struct {
+	DBTransaction
+	denied bool
+}
+
+func (tx *adminGuardTransaction) DelCollection(collection string) {
+
+	tx.denied = true
+}
+
+func (tx *adminGuardTransaction) Rollback() error {
+
+	tx.denied = false
+	return tx.DBTransaction.Rollback()
+}
+
+func (tx *adminGuardTransaction) Commit() error {
+
+	if tx.denied {
+
+		return AdminRequired
+	}
+	return tx.DBTransaction.Commit()
+}
@@ -0,0 +1,730 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var CircuitOpen = errors.New("circuit breaker is open")
+
+//BreakerState state of a circuit breaker
+type BreakerState int
+
+const (
+	//BreakerClosed calls are let through
+	BreakerClosed BreakerState = iota
+	//BreakerOpen calls are rejected with CircuitOpen
+	BreakerOpen
+	//BreakerHalfOpen a few probe calls are let through to check if the backend is back
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+
+	switch state {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+//BreakerPolicy configure circuit breakers, zero fields take default values
+type BreakerPolicy struct {
+	//FailureThreshold consecutive failures those open the breaker, default 5
+	FailureThreshold int
+	//OpenTimeout time the breaker stay open before probing the backend, default 30s
+	OpenTimeout time.Duration
+	//HalfOpenProbes successful probes those close the breaker, only this many calls are let through at once while half open, default 1
+	HalfOpenProbes int
+	//IsFailure classify errors those count as failure, default is the TransientErrorClassifier of the pool
+	//or any error that is not a no record error when the pool has no classifier
+	IsFailure func(err error) bool
+	//OnStateChange is called when the breaker of shard change state, shard is 0 when the pool is not broken per shard
+	OnStateChange func(shard int, from BreakerState, to BreakerState)
+}
+
+//CircuitBreaker reject calls after FailureThreshold consecutive failures until OpenTimeout is elapsed,
+//then let probes through and close again when HalfOpenProbes of them succeed
+type CircuitBreaker struct {
+	policy    BreakerPolicy
+	shard     int
+	mux       sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+}
+
+//NewCircuitBreaker make closed breaker
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+
+	return newShardBreaker(policy, 0)
+}
+
+func newShardBreaker(policy BreakerPolicy, shard int) *CircuitBreaker {
+
+	if policy.FailureThreshold <= 0 {
+
+		policy.FailureThreshold = 5
+	}
+	if policy.OpenTimeout <= 0 {
+
+		policy.OpenTimeout = 30 * time.Second
+	}
+	if policy.HalfOpenProbes <= 0 {
+
+		policy.HalfOpenProbes = 1
+	}
+	return &CircuitBreaker{policy: policy, shard: shard, state: BreakerClosed}
+}
+
+//State return current state of breaker
+func (breaker *CircuitBreaker) State() BreakerState {
+
+	breaker.mux.Lock()
+	defer breaker.mux.Unlock()
+
+	if breaker.state == BreakerOpen && time.Since(breaker.openedAt) >= breaker.policy.OpenTimeout {
+
+		return BreakerHalfOpen
+	}
+	return breaker.state
+}
+
+//setState caller must hold the lock and call the returned func after unlocking
+func (breaker *CircuitBreaker) setState(state BreakerState) func() {
+
+	from := breaker.state
+	breaker.state = state
+	breaker.failures = 0
+	breaker.successes = 0
+	breaker.probes = 0
+
+	if state == BreakerOpen {
+
+		breaker.openedAt = time.Now()
+	}
+	if breaker.policy.OnStateChange == nil || from == state {
+
+		return func() {}
+	}
+	return func() {
+		breaker.policy.OnStateChange(breaker.shard, from, state)
+	}
+}
+
+//Allow return CircuitOpen if a call is rejected, otherwise the caller must report the result of the call to Done
+func (breaker *CircuitBreaker) Allow() error {
+
+	notify := func() {}
+
+	breaker.mux.Lock()
+
+	if breaker.state == BreakerOpen && time.Since(breaker.openedAt) >= breaker.policy.OpenTimeout {
+
+		notify = breaker.setState(BreakerHalfOpen)
+	}
+	var err error = nil
+
+	switch breaker.state {
+	case BreakerOpen:
+		err = CircuitOpen
+	case BreakerHalfOpen:
+		if breaker.probes >= breaker.policy.HalfOpenProbes {
+			err = CircuitOpen
+		} else {
+			breaker.probes++
+		}
+	}
+	breaker.mux.Unlock()
+
+	notify()
+	return err
+}
+
+//Done report the result of a call that was allowed
+func (breaker *CircuitBreaker) Done(failed bool) {
+
+	notify := func() {}
+
+	breaker.mux.Lock()
+
+	switch breaker.state {
+	case BreakerClosed:
+		if !failed {
+			breaker.failures = 0
+		} else if breaker.failures++; breaker.failures >= breaker.policy.FailureThreshold {
+			notify = breaker.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		breaker.probes--
+		if failed {
+			notify = breaker.setState(BreakerOpen)
+		} else if breaker.successes++; breaker.successes >= breaker.policy.HalfOpenProbes {
+			notify = breaker.setState(BreakerClosed)
+		}
+	}
+	breaker.mux.Unlock()
+
+	notify()
+}
+
+//Do call fn if breaker allow it and report its result
+func (breaker *CircuitBreaker) Do(isFailure func(err error) bool, fn func() error) error {
+
+	if err := breaker.Allow(); err != nil {
+
+		return err
+	}
+	err := fn()
+	breaker.Done(err != nil && isFailure(err))
+	return err
+}
+
+//breakerFailure return the failure classifier of policy for pool
+func breakerFailure(policy BreakerPolicy, pool interface{}, isNotFound func(err error) bool) func(err error) bool {
+
+	if policy.IsFailure != nil {
+
+		return policy.IsFailure
+	}
+	if isTransient := findTransientClassifier(pool); isTransient != nil {
+
+		return isTransient
+	}
+	return func(err error) bool {
+
+		return !isNotFound(err)
+	}
+}
+
+//errorQueryResult is returned by a query that is rejected
+type errorQueryResult struct {
+	err error
+}
+
+func (result *errorQueryResult) Error() error {
+
+	return result.err
+}
+
+func (result *errorQueryResult) Next(document interface{}) error {
+
+	return result.err
+}
+
+func (result *errorQueryResult) GetOne(document interface{}) error {
+
+	return result.err
+}
+
+func (result *errorQueryResult) Close() {
+
+}
+
+func (result *errorQueryResult) IsAvailable() bool {
+
+	return false
+}
+
+func (result *errorQueryResult) Count() int64 {
+
+	return 0
+}
+
+//CircuitBreakerDocumentPool reject operations of pool with CircuitOpen while the backend is failing.
+//Watch is not guarded.
+func CircuitBreakerDocumentPool(policy BreakerPolicy) DocumentPoolMiddleware {
+
+	return func(next DocumentPool) DocumentPool {
+
+		return newBreakerDocumentPool(next, NewCircuitBreaker(policy), breakerFailure(policy, next, next.IsNoRecordError))
+	}
+}
+
+func newBreakerDocumentPool(next DocumentPool, breaker *CircuitBreaker, isFailure func(err error) bool) *breakerDocumentPool {
+
+	pool := &breakerDocumentPool{
+		DocumentPoolWrapper: NewDocumentPoolWrapper(next),
+		breaker:             breaker,
+		isFailure:           isFailure,
+	}
+	pool.WrapTransaction = func(tx DBTransaction) DBTransaction {
+
+		return &breakerTransaction{DBTransaction: tx, pool: pool}
+	}
+	return pool
+}
+
+type breakerDocumentPool struct {
+	*DocumentPoolWrapper
+	breaker   *CircuitBreaker
+	isFailure func(err error) bool
+}
+
+//Rewrap wrap next with the same breaker, next must be served by the same backend
+func (pool *breakerDocumentPool) Rewrap(next DocumentPool) DocumentPool {
+
+	return newBreakerDocumentPool(next, pool.breaker, pool.isFailure)
+}
+
+func (pool *breakerDocumentPool) do(fn func() error) error {
+
+	return pool.breaker.Do(pool.isFailure, fn)
+}
+
+func (pool *breakerDocumentPool) Put(collection string, document Document) error {
+
+	return pool.do(func() error { return pool.DocumentPool.Put(collection, document) })
+}
+
+func (pool *breakerDocumentPool) Get(collection string, id string, document interface{}) error {
+
+	return pool.do(func() error { return pool.DocumentPool.Get(collection, id, document) })
+}
+
+func (pool *breakerDocumentPool) PutRaw(collection string, id string, document interface{}) error {
+
+	return pool.do(func() error { return pool.DocumentPool.PutRaw(collection, id, document) })
+}
+
+func (pool *breakerDocumentPool) Del(collection string, id string) error {
+
+	return pool.do(func() error { return pool.DocumentPool.Del(collection, id) })
+}
+
+func (pool *breakerDocumentPool) Update(collection string, id string, update Update) error {
+
+	return pool.do(func() error { return pool.DocumentPool.Update(collection, id, update) })
+}
+
+func (pool *breakerDocumentPool) RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error {
+
+	return pool.do(func() error {
+
+		return pool.DocumentPoolWrapper.RunInTransaction(ctx, func(tx DBTransaction) error {
+
+			if guarded, ok := tx.(*breakerTransaction); ok {
+				guarded.inRun = true
+			}
+			return fn(tx)
+		}, options...)
+	})
+}
+
+func (pool *breakerDocumentPool) Query(query DBQuery) DBQueryResult {
+
+	var result DBQueryResult = nil
+
+	if err := pool.do(func() error {
+		result = pool.DocumentPoolWrapper.Query(query)
+		return result.Error()
+	}); err == CircuitOpen {
+
+		return &errorQueryResult{err: err}
+	}
+	return result
+}
+
+func (pool *breakerDocumentPool) UpdateWhere(query DBQuery, update Update) (int64, error) {
+
+	count := int64(0)
+	err := pool.do(func() error {
+		var err error = nil
+		count, err = pool.DocumentPool.UpdateWhere(query, update)
+		return err
+	})
+	return count, err
+}
+
+func (pool *breakerDocumentPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	count := int64(0)
+	err := pool.do(func() error {
+		var err error = nil
+		count, err = pool.DocumentPool.DeleteWhere(query)
+		return err
+	})
+	return count, err
+}
+
+func (pool *breakerDocumentPool) CreateCollection(collection string) error {
+
+	return pool.do(func() error { return pool.DocumentPool.CreateCollection(collection) })
+}
+
+func (pool *breakerDocumentPool) DelCollection(collection string) error {
+
+	return pool.do(func() error { return pool.DocumentPool.DelCollection(collection) })
+}
+
+func (pool *breakerDocumentPool) EnsureIndex(collection string, index IndexSpec) error {
+
+	return pool.do(func() error { return pool.DocumentPool.EnsureIndex(collection, index) })
+}
+
+func (pool *breakerDocumentPool) ListIndexes(collection string) ([]IndexSpec, error) {
+
+	var indexes []IndexSpec = nil
+	err := pool.do(func() error {
+		var err error = nil
+		indexes, err = pool.DocumentPool.ListIndexes(collection)
+		return err
+	})
+	return indexes, err
+}
+
+func (pool *breakerDocumentPool) DropIndex(collection string, name string) error {
+
+	return pool.do(func() error { return pool.DocumentPool.DropIndex(collection, name) })
+}
+
+func (pool *breakerDocumentPool) collectVary(collect func() (map[string]int, error)) (map[string]int, error) {
+
+	var vary map[string]int = nil
+	err := pool.do(func() error {
+		var err error = nil
+		vary, err = collect()
+		return err
+	})
+	return vary, err
+}
+
+func (pool *breakerDocumentPool) CollectVaryInt(collection string, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryInt(collection, field)
+	})
+}
+
+func (pool *breakerDocumentPool) CollectVaryString(collection string, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryString(collection, field)
+	})
+}
+
+func (pool *breakerDocumentPool) CollectVaryQueryInt(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryQueryInt(query, field)
+	})
+}
+
+func (pool *breakerDocumentPool) CollectVaryQueryString(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryQueryString(query, field)
+	})
+}
+
+//breakerTransaction guard reads and commit of a transaction, inside RunInTransaction the whole run is guarded once
+//so its operations are not counted again
+type breakerTransaction struct {
+	DBTransaction
+	pool  *breakerDocumentPool
+	inRun bool
+}
+
+func (tx *breakerTransaction) Get(collection string, id string, document interface{}) error {
+
+	if tx.inRun {
+
+		return tx.DBTransaction.Get(collection, id, document)
+	}
+	return tx.pool.do(func() error { return tx.DBTransaction.Get(collection, id, document) })
+}
+
+func (tx *breakerTransaction) Query(query DBQuery) DBQueryResult {
+
+	if tx.inRun {
+
+		return tx.DBTransaction.Query(query)
+	}
+	var result DBQueryResult = nil
+
+	if err := tx.pool.do(func() error {
+		result = tx.DBTransaction.Query(query)
+		return result.Error()
+	}); err == CircuitOpen {
+
+		return &errorQueryResult{err: err}
+	}
+	return result
+}
+
+func (tx *breakerTransaction) Commit() error {
+
+	if tx.inRun {
+
+		return tx.DBTransaction.Commit()
+	}
+	return tx.pool.do(tx.DBTransaction.Commit)
+}
+
+//ShardedPool is implemented by mem pools those spread keys of *Shading methods on shards, other methods use shard 0
+type ShardedPool interface {
+	ShardOf(key string) int
+}
+
+//MemBreakerOptions configure CircuitBreakerMemPool
+type MemBreakerOptions struct {
+	//PerShard keep one breaker per shard when the pool implement ShardedPool.
+	//FindKey read every shard so it has its own breaker with shard -1.
+	PerShard bool
+	//Fallback serve the calls those are rejected, like a LocalMemDB. Writes to it are not copied back to the pool.
+	Fallback MemPool
+	//SkipWhenOpen when there is no fallback, rejected writes return nil and rejected reads return CircuitOpen
+	//that IsNotExistedError report as not existed, so the pool is used like a cache that miss
+	SkipWhenOpen bool
+}
+
+const __find_key_shard = -1
+
+//CircuitBreakerMemPool reject operations of pool with CircuitOpen while the backend is failing,
+//or serve them from options.Fallback
+func CircuitBreakerMemPool(policy BreakerPolicy, options MemBreakerOptions) MemPoolMiddleware {
+
+	return func(next MemPool) MemPool {
+
+		pool := &breakerMemPool{
+			MemPoolWrapper: NewMemPoolWrapper(next),
+			policy:         policy,
+			options:        options,
+			breakers:       map[int]*CircuitBreaker{},
+			isFailure:      breakerFailure(policy, next, next.IsNotExistedError),
+		}
+		if options.PerShard {
+
+			walkPool(next, func(next interface{}) bool {
+
+				pool.sharded, _ = next.(ShardedPool)
+				return pool.sharded == nil
+			})
+		}
+		return pool
+	}
+}
+
+type breakerMemPool struct {
+	*MemPoolWrapper
+	policy    BreakerPolicy
+	options   MemBreakerOptions
+	sharded   ShardedPool
+	mux       sync.Mutex
+	breakers  map[int]*CircuitBreaker
+	isFailure func(err error) bool
+}
+
+func (pool *breakerMemPool) breaker(shard int) *CircuitBreaker {
+
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+
+	if pool.sharded == nil {
+
+		shard = 0
+	}
+	breaker, ok := pool.breakers[shard]
+	if !ok {
+
+		breaker = newShardBreaker(pool.policy, shard)
+		pool.breakers[shard] = breaker
+	}
+	return breaker
+}
+
+func (pool *breakerMemPool) shardOf(key string) int {
+
+	if pool.sharded == nil {
+
+		return 0
+	}
+	return pool.sharded.ShardOf(key)
+}
+
+//do call fn with the pool, or with the fallback if the breaker of shard reject it
+func (pool *breakerMemPool) do(shard int, write bool, fn func(mem MemPool) error) error {
+
+	breaker := pool.breaker(shard)
+
+	if err := breaker.Allow(); err != nil {
+
+		if pool.options.Fallback != nil {
+
+			return fn(pool.options.Fallback)
+		}
+		if pool.options.SkipWhenOpen && write {
+
+			return nil
+		}
+		return err
+	}
+	err := fn(pool.MemPool)
+	breaker.Done(err != nil && pool.isFailure(err))
+	return err
+}
+
+func (pool *breakerMemPool) doInt(shard int, fn func(mem MemPool) (int64, error)) (int64, error) {
+
+	result := int64(0)
+	err := pool.do(shard, false, func(mem MemPool) error {
+		var err error = nil
+		result, err = fn(mem)
+		return err
+	})
+	return result, err
+}
+
+func (pool *breakerMemPool) doString(shard int, fn func(mem MemPool) (string, error)) (string, error) {
+
+	result := ""
+	err := pool.do(shard, false, func(mem MemPool) error {
+		var err error = nil
+		result, err = fn(mem)
+		return err
+	})
+	return result, err
+}
+
+func (pool *breakerMemPool) Set(key string, value string) error {
+
+	return pool.do(0, true, func(mem MemPool) error { return mem.Set(key, value) })
+}
+
+func (pool *breakerMemPool) SetInt(key string, value int64) error {
+
+	return pool.do(0, true, func(mem MemPool) error { return mem.SetInt(key, value) })
+}
+
+func (pool *breakerMemPool) IncrInt(key string) (int64, error) {
+
+	return pool.doInt(0, func(mem MemPool) (int64, error) { return mem.IncrInt(key) })
+}
+
+func (pool *breakerMemPool) DecrInt(key string) (int64, error) {
+
+	return pool.doInt(0, func(mem MemPool) (int64, error) { return mem.DecrInt(key) })
+}
+
+func (pool *breakerMemPool) IncrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(0, func(mem MemPool) (int64, error) { return mem.IncrIntBy(key, num) })
+}
+
+func (pool *breakerMemPool) DecrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(0, func(mem MemPool) (int64, error) { return mem.DecrIntBy(key, num) })
+}
+
+func (pool *breakerMemPool) SetShading(key string, value string) error {
+
+	return pool.do(pool.shardOf(key), true, func(mem MemPool) error { return mem.SetShading(key, value) })
+}
+
+func (pool *breakerMemPool) SetIntShading(key string, value int64) error {
+
+	return pool.do(pool.shardOf(key), true, func(mem MemPool) error { return mem.SetIntShading(key, value) })
+}
+
+func (pool *breakerMemPool) IncrIntShading(key string) (int64, error) {
+
+	return pool.doInt(pool.shardOf(key), func(mem MemPool) (int64, error) { return mem.IncrIntShading(key) })
+}
+
+func (pool *breakerMemPool) DescIntShading(key string) (int64, error) {
+
+	return pool.doInt(pool.shardOf(key), func(mem MemPool) (int64, error) { return mem.DescIntShading(key) })
+}
+
+func (pool *breakerMemPool) IncrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(pool.shardOf(key), func(mem MemPool) (int64, error) { return mem.IncrIntByShading(key, num) })
+}
+
+func (pool *breakerMemPool) DecrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(pool.shardOf(key), func(mem MemPool) (int64, error) { return mem.DecrIntByShading(key, num) })
+}
+
+func (pool *breakerMemPool) SetExpire(key string, value string, d time.Duration) error {
+
+	return pool.do(0, true, func(mem MemPool) error { return mem.SetExpire(key, value, d) })
+}
+
+func (pool *breakerMemPool) SetIntExpire(key string, value int64, d time.Duration) error {
+
+	return pool.do(0, true, func(mem MemPool) error { return mem.SetIntExpire(key, value, d) })
+}
+
+func (pool *breakerMemPool) SetExpireShading(key string, value string, d time.Duration) error {
+
+	return pool.do(pool.shardOf(key), true, func(mem MemPool) error { return mem.SetExpireShading(key, value, d) })
+}
+
+func (pool *breakerMemPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
+
+	return pool.do(pool.shardOf(key), true, func(mem MemPool) error { return mem.SetIntExpireShading(key, value, d) })
+}
+
+func (pool *breakerMemPool) Get(key string) (string, error) {
+
+	return pool.doString(0, func(mem MemPool) (string, error) { return mem.Get(key) })
+}
+
+func (pool *breakerMemPool) GetInt(key string) (int64, error) {
+
+	return pool.doInt(0, func(mem MemPool) (int64, error) { return mem.GetInt(key) })
+}
+
+func (pool *breakerMemPool) GetShading(key string) (string, error) {
+
+	return pool.doString(pool.shardOf(key), func(mem MemPool) (string, error) { return mem.GetShading(key) })
+}
+
+func (pool *breakerMemPool) GetIntShading(key string) (int64, error) {
+
+	return pool.doInt(pool.shardOf(key), func(mem MemPool) (int64, error) { return mem.GetIntShading(key) })
+}
+
+func (pool *breakerMemPool) Del(key string) error {
+
+	return pool.do(0, true, func(mem MemPool) error { return mem.Del(key) })
+}
+
+func (pool *breakerMemPool) DelShading(key string) error {
+
+	return pool.do(pool.shardOf(key), true, func(mem MemPool) error { return mem.DelShading(key) })
+}
+
+func (pool *breakerMemPool) FindKey(keyPattern string) ([]string, error) {
+
+	var keys []string = nil
+	err := pool.do(__find_key_shard, false, func(mem MemPool) error {
+		var err error = nil
+		keys, err = mem.FindKey(keyPattern)
+		return err
+	})
+	return keys, err
+}
+
+//IsNotExistedError also report CircuitOpen as not existed when SkipWhenOpen is set, and not existed errors of the fallback
+func (pool *breakerMemPool) IsNotExistedError(err error) bool {
+
+	if err == CircuitOpen {
+
+		return pool.options.SkipWhenOpen
+	}
+	if pool.options.Fallback != nil && pool.options.Fallback.IsNotExistedError(err) {
+
+		return true
+	}
+	return pool.MemPool.IsNotExistedError(err)
+}
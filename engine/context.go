@@ -0,0 +1,22 @@
+package engine
+
+import "context"
+
+type engineContextKey struct{}
+
+//WithEngine return a copy of ctx that carry engine
+func WithEngine(ctx context.Context, engine *Engine) context.Context {
+
+	return context.WithValue(ctx, engineContextKey{}, engine)
+}
+
+//FromContext get engine of ctx, nil if ctx carry none
+func FromContext(ctx context.Context) *Engine {
+
+	if ctx == nil {
+
+		return nil
+	}
+	engine, _ := ctx.Value(engineContextKey{}).(*Engine)
+	return engine
+}
@@ -1,12 +1,27 @@
 package engine
 
+import "fmt"
+
 //# All item in a truck must be process inside a transaction.
 //# It's mean it must be all success or all fail.
+//# If the truck is bigger than the batch limit of a pool, it is split into many transactions.
+//# Each part is still all success or all fail but parts those were committed stay committed.
+
+//Data truck commands
+const (
+	TruckPut    = "put"
+	TruckPutRaw = "put_raw"
+	TruckDel    = "del"
+)
 
 //DataTruckItem wrap for document item
 type DataTruckItem struct {
+	//Command empty command mean put, it keep items those are appended by struct literal working
+	Command    string
 	Collection string
 	Document   Document
+	ID         string
+	Raw        interface{}
 }
 
 //DataTruck data truck
@@ -14,10 +29,95 @@ type DataTruck struct {
 	Items []DataTruckItem
 }
 
+//BatchLimiter is implemented by pool that limit number of writes in one transaction, it is also found behind middlewares
+type BatchLimiter interface {
+	MaxBatchSize() int
+}
+
+//DataTruckError report items those are not committed
+type DataTruckError struct {
+	Err error
+	//Committed number of items those were committed before the failure
+	Committed int
+	Failed    []DataTruckItem
+}
+
+func (err *DataTruckError) Error() string {
+
+	return fmt.Sprintf("data truck: %d items committed, %d items failed: %v", err.Committed, len(err.Failed), err.Err)
+}
+
+func (err *DataTruckError) Unwrap() error {
+
+	return err.Err
+}
+
 //Append append a document to a truck
 func (truck *DataTruck) Append(collection string, document Document) {
 
-	truck.Items = append(truck.Items, DataTruckItem{Collection: collection, Document: document})
+	truck.Items = append(truck.Items, DataTruckItem{Command: TruckPut, Collection: collection, Document: document})
+}
+
+//AppendRaw append a raw document to a truck
+func (truck *DataTruck) AppendRaw(collection string, id string, document interface{}) {
+
+	truck.Items = append(truck.Items, DataTruckItem{Command: TruckPutRaw, Collection: collection, ID: id, Raw: document})
+}
+
+//AppendDel append a delete to a truck
+func (truck *DataTruck) AppendDel(collection string, id string) {
+
+	truck.Items = append(truck.Items, DataTruckItem{Command: TruckDel, Collection: collection, ID: id})
+}
+
+//Commit commit all items to pool. It return *DataTruckError that list items those are not committed.
+func (truck *DataTruck) Commit(pool DocumentPool) error {
+
+	if len(truck.Items) == 0 {
+
+		return nil
+	}
+	limit := len(truck.Items)
+
+	walkPool(pool, func(pool interface{}) bool {
+
+		limiter, ok := pool.(BatchLimiter)
+		if ok && limiter.MaxBatchSize() > 0 && limiter.MaxBatchSize() < limit {
+
+			limit = limiter.MaxBatchSize()
+		}
+		return !ok
+	})
+	for begin := 0; begin < len(truck.Items); begin += limit {
+
+		end := begin + limit
+		if end > len(truck.Items) {
+			end = len(truck.Items)
+		}
+		transaction := pool.MakeTransaction()
+		transaction.Begin()
+
+		for _, item := range truck.Items[begin:end] {
+
+			switch item.Command {
+			case TruckPutRaw:
+				transaction.PutRaw(item.Collection, item.ID, item.Raw)
+			case TruckDel:
+				transaction.Del(item.Collection, item.ID)
+			default:
+				transaction.Put(item.Collection, item.Document)
+			}
+		}
+		if err := transaction.Commit(); err != nil {
+
+			return &DataTruckError{
+				Err:       err,
+				Committed: begin,
+				Failed:    append([]DataTruckItem{}, truck.Items[begin:]...),
+			}
+		}
+	}
+	return nil
 }
 
 //CreateDataStruck create a data truck
@@ -1,30 +1,63 @@
 package engine
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var NoDocument = errors.New("no document")
 var InvalidQuery = errors.New("Query is not valid")
 var NotImplement = errors.New("Not implement")
+var InvalidUpdate = errors.New("Update is not valid")
 
 //Document define a interface for document
 type Document interface {
 	GetID() string
 }
 
-//DBTransaction transaction
+//DBTransaction transaction.
+//Writes are buffered and applied on Commit so reads inside a transaction do not see its own writes.
+//Reads are consistent with the commit only when transaction is run by DocumentPool.RunInTransaction,
+//a transaction made by MakeTransaction reads the current state of database.
 type DBTransaction interface {
 	Begin()
 
+	Get(collection string, id string, document interface{}) error
+	//Query query documents, the result must be consumed before the transaction end
+	Query(query DBQuery) DBQueryResult
+
 	Put(collection string, document Document)
 	PutRaw(collection string, id string, document interface{})
 
 	Del(collection string, id string)
 
+	//Update apply update operators on a document, document is created if it's not existed
+	Update(collection string, id string, update Update)
+
+	//CreateCollection create collection when transaction is committed
+	CreateCollection(collection string)
+	//DelCollection delete collection with its documents and indexes when transaction is committed.
+	//Backend that can not drop a collection atomically return an error wrapping TransactionPartialCommit on failure.
+	DelCollection(collection string)
+
+	//Len number of buffered writes
+	Len() int
+	//Items buffered writes in the order they will be applied
+	Items() []TransactionItem
+
+	//Savepoint mark current position of buffered writes
+	Savepoint() int
+	//RollbackTo discard writes those are added after savepoint
+	RollbackTo(savepoint int) error
+	//Rollback discard all writes, Commit of a rolled back transaction return TransactionClosed
+	Rollback() error
+
 	Commit() error
 }
 
 //DocumentPool interface to interact with documentation database
 type DocumentPool interface {
+	Lifecycle
 
 	//Init init pool from connection string
 	Init(connectionString string) error
@@ -38,20 +71,46 @@ type DocumentPool interface {
 
 	Del(collection string, id string) error
 
+	//Update apply update operators on a document atomically, document is created if it's not existed
+	Update(collection string, id string, update Update) error
+
 	IsNoRecordError(error) bool
 
 	//all query in transaction must be all done or all fail.
-	MakeTransaction() DBTransaction
+	MakeTransaction(options ...*TransactionOptions) DBTransaction
+
+	//RunInTransaction run fn with a transaction, writes of tx are committed when fn return nil.
+	//fn may be called again if the transaction fail on a transient error so it must have no other side effect.
+	//fn must use tx for every read and write, calling the pool inside fn may deadlock on local pools.
+	RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error
 
 	//Query query
 	Query(query DBQuery) DBQueryResult
 
+	//UpdateWhere apply update to all documents that match query, return number of matched documents.
+	//Paging and sort of query are ignored.
+	UpdateWhere(query DBQuery, update Update) (int64, error)
+
+	//DeleteWhere delete all documents that match query, return number of deleted documents.
+	//Paging and sort of query are ignored.
+	DeleteWhere(query DBQuery) (int64, error)
+
 	CleanPagingInfo(query DBQuery)
 
+	//Watch observe changes of documents in collection those match query, nil query match all documents.
+	//A delete is reported with the ID of the document when the document matched query before it was deleted.
+	//Empty resumeToken start from now. Channel is closed when ctx is done or after an event with Err.
+	Watch(ctx context.Context, collection string, query *DBQuery, resumeToken string) <-chan ChangeEvent
+
 	//MARK: Work with collection
 	CreateCollection(collection string) error
 	DelCollection(collection string) error
 
+	//MARK: Work with index
+	EnsureIndex(collection string, index IndexSpec) error
+	ListIndexes(collection string) ([]IndexSpec, error)
+	DropIndex(collection string, name string) error
+
 	//
 	CollectVaryInt(collection string, field string) (map[string]int, error)
 	CollectVaryString(collection string, field string) (map[string]int, error)
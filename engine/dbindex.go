@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tapvanvn/gocondition"
+)
+
+var DuplicateKey = errors.New("duplicate key")
+var InvalidIndex = errors.New("Index is not valid")
+
+//IndexField field of an index
+type IndexField struct {
+	Field     string
+	Inscrease bool
+}
+
+//IndexSpec describe an index in a portable way.
+//Adapter that can not honor an option must return an error from EnsureIndex instead of ignoring it.
+type IndexSpec struct {
+	//Name of index, it is generated from fields if empty
+	Name   string
+	Fields []IndexField
+	Unique bool
+	//Sparse document that has none of index fields is not indexed
+	Sparse bool
+	//TTL document is deleted ExpireAfter after the time stored in the only field of index
+	TTL         bool
+	ExpireAfter time.Duration
+	//Partial only documents that match condition are indexed
+	Partial *gocondition.RuleSet
+}
+
+//MakeIndexSpec make index on fields, every field is sorted in inscrease order
+func MakeIndexSpec(fields ...string) IndexSpec {
+
+	index := IndexSpec{Fields: []IndexField{}}
+
+	for _, field := range fields {
+
+		index.Fields = append(index.Fields, IndexField{Field: field, Inscrease: true})
+	}
+	return index
+}
+
+//GetName get index name, default name follow mongodb convention field1_1_field2_-1
+func (index *IndexSpec) GetName() string {
+
+	if index.Name != "" {
+
+		return index.Name
+	}
+	parts := []string{}
+
+	for _, field := range index.Fields {
+
+		if field.Inscrease {
+
+			parts = append(parts, field.Field+"_1")
+
+		} else {
+
+			parts = append(parts, field.Field+"_-1")
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+//Validate check if index is well defined
+func (index *IndexSpec) Validate() error {
+
+	if len(index.Fields) == 0 {
+
+		return fmt.Errorf("%w: index has no field", InvalidIndex)
+	}
+	for _, field := range index.Fields {
+
+		if field.Field == "" {
+
+			return fmt.Errorf("%w: empty field name", InvalidIndex)
+		}
+	}
+	if index.TTL && len(index.Fields) != 1 {
+
+		return fmt.Errorf("%w: ttl index must have exactly one field", InvalidIndex)
+	}
+	return nil
+}
+
+//Key get the key of a document that decoded into map[string]interface{} in index.
+//It return false if document is not indexed because of sparse or partial option.
+func (index *IndexSpec) Key(document map[string]interface{}) (string, bool) {
+
+	if index.Partial != nil && !index.Partial.Value(document) {
+
+		return "", false
+	}
+	values := []interface{}{}
+	hasField := false
+
+	for _, field := range index.Fields {
+
+		value, ok := lookupField(document, field.Field)
+		if ok {
+			hasField = true
+		}
+		values = append(values, value)
+	}
+	if index.Sparse && !hasField {
+
+		return "", false
+	}
+	key, err := json.Marshal(values)
+	if err != nil {
+
+		return "", false
+	}
+	return string(key), true
+}
@@ -4,6 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
 
 	"github.com/tapvanvn/gocondition"
 )
@@ -15,10 +18,90 @@ type DBFilterItem struct {
 	FieldValue interface{}
 }
 
-func (DBFilterItem) Value(context interface{}) bool {
+//Value evaluate filter on a document that decoded into map[string]interface{}.
+//It is used by adapters those can not translate query to native filter.
+func (item DBFilterItem) Value(context interface{}) bool {
+
+	document, ok := context.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	current, existed := lookupField(document, item.Field)
+	current = normalizeValue(current)
+	value := normalizeValue(item.FieldValue)
+
+	switch item.Operator {
+	case "=":
+		return existed && reflect.DeepEqual(current, value)
+	case "!=":
+		return !existed || !reflect.DeepEqual(current, value)
+	case ">", ">=", "<", "<=":
+		if !existed {
+			return false
+		}
+		return compareMatch(item.Operator, current, value)
+	case "+=":
+		return !existed || reflect.DeepEqual(current, value)
+	case "+<":
+		return !existed || compareMatch("<", current, value)
+	case "+>":
+		return !existed || compareMatch(">", current, value)
+	case "regex":
+		str, ok := current.(string)
+		if !existed || !ok {
+			return false
+		}
+		matched, err := regexp.MatchString(fmt.Sprintf("%v", item.FieldValue), str)
+		return err == nil && matched
+	case "in":
+		values, ok := value.([]interface{})
+		if !existed || !ok {
+			return false
+		}
+		for _, candidate := range values {
+			if reflect.DeepEqual(current, candidate) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
+func compareMatch(operator string, current interface{}, value interface{}) bool {
+
+	compare, ok := compareValues(current, value)
+	if !ok {
+		return false
+	}
+	switch operator {
+	case ">":
+		return compare > 0
+	case ">=":
+		return compare >= 0
+	case "<":
+		return compare < 0
+	case "<=":
+		return compare <= 0
+	}
+	return false
+}
+
+//GetField get value of a dotted field from a document that decoded into map[string]interface{}
+func GetField(document map[string]interface{}, field string) (interface{}, bool) {
+
+	return lookupField(document, field)
+}
+
+func lookupField(document map[string]interface{}, field string) (interface{}, bool) {
+
+	parent, key := lookupParent(document, field, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, ok := parent[key]
+	return value, ok
+}
+
 type dbSortItem struct {
 	Field     string
 	Inscrease bool
@@ -102,6 +185,42 @@ func (query *DBQuery) GetSignature() string {
 	return fmt.Sprintf("%x", hash[:])
 }
 
+//Match check if a document that decoded into map[string]interface{} match query condition
+func (query *DBQuery) Match(document map[string]interface{}) bool {
+
+	return query.Condition.Value(document)
+}
+
+//SortDocuments sort documents that decoded into map[string]interface{} by query sort fields.
+//Document that miss a sort field is placed before others as mongodb does.
+func (query *DBQuery) SortDocuments(documents []map[string]interface{}) {
+
+	sort.SliceStable(documents, func(i, j int) bool {
+
+		for _, sortItem := range query.SortFields {
+
+			a, okA := lookupField(documents[i], sortItem.Field)
+			b, okB := lookupField(documents[j], sortItem.Field)
+			compare := 0
+			if okA && okB {
+				compare, _ = compareValues(a, b)
+			} else if okA {
+				compare = 1
+			} else if okB {
+				compare = -1
+			}
+			if compare == 0 {
+				continue
+			}
+			if sortItem.Inscrease {
+				return compare < 0
+			}
+			return compare > 0
+		}
+		return false
+	})
+}
+
 //Paging paging
 func (query *DBQuery) Paging(pageNum int, pageSize int) {
 
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"errors"
+	"time"
+)
+
+var TransactionClosed = errors.New("transaction is rolled back")
+var InvalidSavepoint = errors.New("savepoint is not valid")
+
+//TransactionPartialCommit is returned when backend can not apply every item atomically and
+//a part of a transaction stay committed after a failure
+var TransactionPartialCommit = errors.New("transaction is partially committed")
+
+//Transaction commands
+const (
+	TransactionPut    = "put"
+	TransactionDel    = "del"
+	TransactionUpdate = "update"
+
+	TransactionCreateCollection = "create_collection"
+	TransactionDelCollection    = "del_collection"
+)
+
+//Read concerns
+const (
+	ReadConcernLocal        = "local"
+	ReadConcernMajority     = "majority"
+	ReadConcernSnapshot     = "snapshot"
+	ReadConcernLinearizable = "linearizable"
+)
+
+//Write concerns, a number like "2" is also accepted by backends those support it
+const (
+	WriteConcernMajority = "majority"
+	WriteConcernOne      = "1"
+)
+
+//TransactionOptions options of a transaction.
+//ReadConcern and WriteConcern are honored by backends those have them (mongodb),
+//other backends always run transaction at their strongest level and ignore them.
+type TransactionOptions struct {
+	ReadConcern  string
+	WriteConcern string
+	//Timeout limit the time of commit, zero mean no limit
+	Timeout time.Duration
+}
+
+//MergeTransactionOptions merge options, a field that is set in a later option override the previous one
+func MergeTransactionOptions(options ...*TransactionOptions) TransactionOptions {
+
+	merged := TransactionOptions{}
+
+	for _, option := range options {
+
+		if option == nil {
+			continue
+		}
+		if option.ReadConcern != "" {
+			merged.ReadConcern = option.ReadConcern
+		}
+		if option.WriteConcern != "" {
+			merged.WriteConcern = option.WriteConcern
+		}
+		if option.Timeout != 0 {
+			merged.Timeout = option.Timeout
+		}
+	}
+	return merged
+}
+
+//TransactionItem a write that is buffered in a transaction
+type TransactionItem struct {
+	Command    string
+	Collection string
+	ID         string
+	//Document is the document of put command or the Update of update command
+	Document interface{}
+}
+
+//TransactionBuffer buffer writes of a transaction.
+//Adapters embed it to implement the write, rollback and introspection part of DBTransaction.
+type TransactionBuffer struct {
+	items  []TransactionItem
+	closed bool
+}
+
+func (buffer *TransactionBuffer) append(command string, collection string, id string, document interface{}) {
+
+	if buffer.closed {
+		return
+	}
+	buffer.items = append(buffer.items, TransactionItem{Command: command, Collection: collection, ID: id, Document: document})
+}
+
+//Put dbtransaction put
+func (buffer *TransactionBuffer) Put(collection string, document Document) {
+
+	buffer.append(TransactionPut, collection, document.GetID(), document)
+}
+
+//PutRaw dbtransaction put raw
+func (buffer *TransactionBuffer) PutRaw(collection string, id string, document interface{}) {
+
+	buffer.append(TransactionPut, collection, id, document)
+}
+
+//Del dbtransaction delete
+func (buffer *TransactionBuffer) Del(collection string, id string) {
+
+	buffer.append(TransactionDel, collection, id, nil)
+}
+
+//Update dbtransaction update
+func (buffer *TransactionBuffer) Update(collection string, id string, update Update) {
+
+	buffer.append(TransactionUpdate, collection, id, update)
+}
+
+//CreateCollection dbtransaction create collection
+func (buffer *TransactionBuffer) CreateCollection(collection string) {
+
+	buffer.append(TransactionCreateCollection, collection, "", nil)
+}
+
+//DelCollection dbtransaction delete collection with all its documents and indexes
+func (buffer *TransactionBuffer) DelCollection(collection string) {
+
+	buffer.append(TransactionDelCollection, collection, "", nil)
+}
+
+//Len number of buffered items
+func (buffer *TransactionBuffer) Len() int {
+
+	return len(buffer.items)
+}
+
+//Items copy of buffered items in the order they will be applied
+func (buffer *TransactionBuffer) Items() []TransactionItem {
+
+	return append([]TransactionItem{}, buffer.items...)
+}
+
+//Savepoint mark current position so later items can be discarded by RollbackTo
+func (buffer *TransactionBuffer) Savepoint() int {
+
+	return len(buffer.items)
+}
+
+//RollbackTo discard items those are added after savepoint
+func (buffer *TransactionBuffer) RollbackTo(savepoint int) error {
+
+	if buffer.closed {
+		return TransactionClosed
+	}
+	if savepoint < 0 || savepoint > len(buffer.items) {
+		return InvalidSavepoint
+	}
+	buffer.items = buffer.items[:savepoint]
+	return nil
+}
+
+//Rollback discard all items, later writes are ignored and Commit return TransactionClosed
+func (buffer *TransactionBuffer) Rollback() error {
+
+	buffer.items = nil
+	buffer.closed = true
+	return nil
+}
+
+//IsClosed check if transaction is rolled back
+func (buffer *TransactionBuffer) IsClosed() bool {
+
+	return buffer.closed
+}
@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//Update operators
+const (
+	UpdateSet         = "set"
+	UpdateUnset       = "unset"
+	UpdateInc         = "inc"
+	UpdatePush        = "push"
+	UpdatePull        = "pull"
+	UpdateAddToSet    = "add_to_set"
+	UpdateMin         = "min"
+	UpdateMax         = "max"
+	UpdateCurrentDate = "current_date"
+)
+
+//UpdateOperation one operator apply on one field
+type UpdateOperation struct {
+	Operator string
+	Field    string
+	Value    interface{}
+}
+
+//Update describe a partial update of a document.
+//Field is the field name as it is stored by the backend, nested field is separated by "."
+type Update struct {
+	Operations []UpdateOperation
+}
+
+//MakeUpdate make new update
+func MakeUpdate() Update {
+
+	return Update{Operations: []UpdateOperation{}}
+}
+
+func (update *Update) append(operator string, field string, value interface{}) {
+
+	update.Operations = append(update.Operations, UpdateOperation{Operator: operator, Field: field, Value: value})
+}
+
+//Set set field to value
+func (update *Update) Set(field string, value interface{}) {
+
+	update.append(UpdateSet, field, value)
+}
+
+//Unset remove field
+func (update *Update) Unset(field string) {
+
+	update.append(UpdateUnset, field, nil)
+}
+
+//Inc increase a number field by num, field is created if it's not existed
+func (update *Update) Inc(field string, num interface{}) {
+
+	update.append(UpdateInc, field, num)
+}
+
+//Push append value to an array field, firestore return NotImplement because it can only add to set
+func (update *Update) Push(field string, value interface{}) {
+
+	update.append(UpdatePush, field, value)
+}
+
+//Pull remove all element equal to value from an array field
+func (update *Update) Pull(field string, value interface{}) {
+
+	update.append(UpdatePull, field, value)
+}
+
+//AddToSet append value to an array field if it's not in the array yet
+func (update *Update) AddToSet(field string, value interface{}) {
+
+	update.append(UpdateAddToSet, field, value)
+}
+
+//Min set field to value if value is less than current value
+func (update *Update) Min(field string, value interface{}) {
+
+	update.append(UpdateMin, field, value)
+}
+
+//Max set field to value if value is greater than current value
+func (update *Update) Max(field string, value interface{}) {
+
+	update.append(UpdateMax, field, value)
+}
+
+//CurrentDate set field to the current time
+func (update *Update) CurrentDate(field string) {
+
+	update.append(UpdateCurrentDate, field, nil)
+}
+
+//IsEmpty check if update has no operation
+func (update *Update) IsEmpty() bool {
+
+	return len(update.Operations) == 0
+}
+
+//IsIdempotent check if applying update again give the same document, it is false when update has inc, push or current date
+func (update *Update) IsIdempotent() bool {
+
+	for _, operation := range update.Operations {
+
+		if operation.Operator == UpdateInc || operation.Operator == UpdatePush || operation.Operator == UpdateCurrentDate {
+
+			return false
+		}
+	}
+	return true
+}
+
+//Apply apply update to a document that decoded into map.
+//It is used by adapters those have no native update operator.
+func (update *Update) Apply(document map[string]interface{}) error {
+
+	for _, operation := range update.Operations {
+
+		parent, key := lookupParent(document, operation.Field, true)
+		if parent == nil {
+
+			return fmt.Errorf("%w: %s is not an object", InvalidUpdate, operation.Field)
+		}
+		current, existed := parent[key]
+
+		switch operation.Operator {
+		case UpdateSet:
+			parent[key] = normalizeValue(operation.Value)
+
+		case UpdateUnset:
+			delete(parent, key)
+
+		case UpdateInc:
+			num, ok := toFloat(normalizeValue(operation.Value))
+			if !ok {
+				return fmt.Errorf("%w: inc %s by a non number", InvalidUpdate, operation.Field)
+			}
+			if !existed || current == nil {
+				parent[key] = num
+				break
+			}
+			value, ok := toFloat(current)
+			if !ok {
+				return fmt.Errorf("%w: inc %s which is not a number", InvalidUpdate, operation.Field)
+			}
+			parent[key] = value + num
+
+		case UpdatePush, UpdateAddToSet, UpdatePull:
+			array := []interface{}{}
+			if existed && current != nil {
+				isArray := false
+				if array, isArray = current.([]interface{}); !isArray {
+					return fmt.Errorf("%w: %s is not an array", InvalidUpdate, operation.Field)
+				}
+			}
+			value := normalizeValue(operation.Value)
+			if operation.Operator == UpdatePush {
+				parent[key] = append(array, value)
+				break
+			}
+			found := -1
+			remains := []interface{}{}
+			for i, item := range array {
+				if reflect.DeepEqual(item, value) {
+					found = i
+					continue
+				}
+				remains = append(remains, item)
+			}
+			if operation.Operator == UpdatePull {
+				if existed {
+					parent[key] = remains
+				}
+			} else if found < 0 {
+				parent[key] = append(array, value)
+			}
+
+		case UpdateMin, UpdateMax:
+			value := normalizeValue(operation.Value)
+			if !existed || current == nil {
+				parent[key] = value
+				break
+			}
+			compare, ok := compareValues(value, current)
+			if !ok {
+				return fmt.Errorf("%w: can not compare %s", InvalidUpdate, operation.Field)
+			}
+			if (operation.Operator == UpdateMin && compare < 0) || (operation.Operator == UpdateMax && compare > 0) {
+				parent[key] = value
+			}
+
+		case UpdateCurrentDate:
+			parent[key] = time.Now().UTC().Format(time.RFC3339Nano)
+
+		default:
+			return fmt.Errorf("%w: unknown operator %s", InvalidUpdate, operation.Operator)
+		}
+	}
+	return nil
+}
+
+//lookupParent find the object that hold the last part of a dotted field
+func lookupParent(document map[string]interface{}, field string, create bool) (map[string]interface{}, string) {
+
+	parts := strings.Split(field, ".")
+	current := document
+
+	for _, part := range parts[:len(parts)-1] {
+
+		next, ok := current[part]
+		if !ok || next == nil {
+			if !create {
+				return nil, ""
+			}
+			child := map[string]interface{}{}
+			current[part] = child
+			current = child
+			continue
+		}
+		child, ok := asMap(next)
+		if !ok {
+			return nil, ""
+		}
+		current = child
+	}
+	return current, parts[len(parts)-1]
+}
+
+//asMap return value as map[string]interface{} when it is a map of that kind like bson.M, the map is not copied
+func asMap(value interface{}) (map[string]interface{}, bool) {
+
+	if document, ok := value.(map[string]interface{}); ok {
+
+		return document, true
+	}
+	mapType := reflect.TypeOf(map[string]interface{}{})
+	reflected := reflect.ValueOf(value)
+	if reflected.Kind() != reflect.Map || !reflected.Type().ConvertibleTo(mapType) {
+
+		return nil, false
+	}
+	return reflected.Convert(mapType).Interface().(map[string]interface{}), true
+}
+
+//normalizeValue convert a value to the form that decoded from json so it can be compared with document's values
+func normalizeValue(value interface{}) interface{} {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{} = nil
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+func toFloat(value interface{}) (float64, bool) {
+
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case float32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case uint:
+		return float64(number), true
+	case uint32:
+		return float64(number), true
+	case uint64:
+		return float64(number), true
+	}
+	return 0, false
+}
+
+//compareValues compare two number or two string. It return false if values are not comparable
+func compareValues(a interface{}, b interface{}) (int, bool) {
+
+	if numA, ok := toFloat(a); ok {
+		numB, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		if numA < numB {
+			return -1, true
+		} else if numA > numB {
+			return 1, true
+		}
+		return 0, true
+	}
+	if strA, ok := a.(string); ok {
+		strB, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(strA, strB), true
+	}
+	if boolA, ok := a.(bool); ok {
+		boolB, ok := b.(bool)
+		if !ok || boolA == boolB {
+			return 0, ok
+		}
+		if !boolA {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
@@ -0,0 +1,56 @@
+package engine
+
+import "encoding/json"
+
+//Change event types
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+//ChangeEvent a change of a document that is observed by DocumentPool.Watch
+type ChangeEvent struct {
+	Type       string
+	Collection string
+	ID         string
+	//Document fields of document after change, it is nil for delete event
+	Document map[string]interface{}
+	//ResumeToken pass to Watch to continue after this event
+	ResumeToken string
+	//Err is set on the last event before the channel is closed because of an error
+	Err error
+
+	decode func(document interface{}) error
+}
+
+//MakeChangeEvent make change event, decode is used by Decode to convert document in the backend format
+func MakeChangeEvent(changeType string, collection string, id string, document map[string]interface{}, decode func(document interface{}) error) ChangeEvent {
+
+	return ChangeEvent{
+		Type:       changeType,
+		Collection: collection,
+		ID:         id,
+		Document:   document,
+		decode:     decode,
+	}
+}
+
+//Decode decode document after change
+func (event *ChangeEvent) Decode(document interface{}) error {
+
+	if event.Document == nil {
+
+		return NoDocument
+	}
+	if event.decode != nil {
+
+		return event.decode(document)
+	}
+	content, err := json.Marshal(event.Document)
+	if err != nil {
+
+		return err
+	}
+	return json.Unmarshal(content, document)
+}
@@ -0,0 +1,1379 @@
+package engine
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/tapvanvn/gocondition"
+)
+
+var EncryptionKeyNotFound = errors.New("encryption key is not found")
+var InvalidEncryptionKey = errors.New("invalid encryption key")
+var InvalidCiphertext = errors.New("invalid ciphertext")
+var UnsupportedEncryptedField = errors.New("only string and []byte fields can be encrypted")
+var EncryptedFieldOperation = errors.New("encrypted field can only be set, unset or compared by equality when it is deterministic")
+var UnregisteredEncryptedCollection = errors.New("collection is not registered to field encryption")
+
+const __encrypted_prefix = "enc:v1:"
+
+//KeyProvider provide the key encryption keys of FieldEncryption. Keys are 16, 24 or 32 bytes AES keys.
+//A key that was rotated out must still be returned by Key as long as values encrypted by it exist.
+type KeyProvider interface {
+	//CurrentKeyID id of the key new values are encrypted with, it must not contain ":"
+	CurrentKeyID() (string, error)
+	Key(id string) ([]byte, error)
+}
+
+//KeyLister is implemented by key providers those can list their keys.
+//Equality queries on deterministic fields then match values encrypted by every key, not only the current one.
+type KeyLister interface {
+	KeyIDs() ([]string, error)
+}
+
+//KeyRing in memory KeyProvider
+type KeyRing struct {
+	mux     sync.RWMutex
+	keys    map[string][]byte
+	ids     []string
+	current string
+}
+
+//NewKeyRing make an empty key ring
+func NewKeyRing() *KeyRing {
+
+	return &KeyRing{keys: map[string][]byte{}}
+}
+
+//AddKey add key of id, the first key that is added become the current key
+func (ring *KeyRing) AddKey(id string, key []byte) error {
+
+	if id == "" || strings.Contains(id, ":") {
+
+		return InvalidEncryptionKey
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+
+		return InvalidEncryptionKey
+	}
+	ring.mux.Lock()
+	defer ring.mux.Unlock()
+
+	if _, ok := ring.keys[id]; !ok {
+		ring.ids = append(ring.ids, id)
+	}
+	ring.keys[id] = append([]byte{}, key...)
+	if ring.current == "" {
+		ring.current = id
+	}
+	return nil
+}
+
+//SetCurrent make the key of id the key new values are encrypted with
+func (ring *KeyRing) SetCurrent(id string) error {
+
+	ring.mux.Lock()
+	defer ring.mux.Unlock()
+
+	if _, ok := ring.keys[id]; !ok {
+
+		return EncryptionKeyNotFound
+	}
+	ring.current = id
+	return nil
+}
+
+//Rotate add key of id and make it the current key, older keys still decrypt the values they encrypted
+func (ring *KeyRing) Rotate(id string, key []byte) error {
+
+	if err := ring.AddKey(id, key); err != nil {
+
+		return err
+	}
+	return ring.SetCurrent(id)
+}
+
+func (ring *KeyRing) CurrentKeyID() (string, error) {
+
+	ring.mux.RLock()
+	defer ring.mux.RUnlock()
+
+	if ring.current == "" {
+
+		return "", EncryptionKeyNotFound
+	}
+	return ring.current, nil
+}
+
+func (ring *KeyRing) Key(id string) ([]byte, error) {
+
+	ring.mux.RLock()
+	defer ring.mux.RUnlock()
+
+	key, ok := ring.keys[id]
+	if !ok {
+
+		return nil, EncryptionKeyNotFound
+	}
+	return key, nil
+}
+
+func (ring *KeyRing) KeyIDs() ([]string, error) {
+
+	ring.mux.RLock()
+	defer ring.mux.RUnlock()
+
+	return append([]string{}, ring.ids...), nil
+}
+
+//encryptedField a field that is tagged by godb:"encrypt"
+type encryptedField struct {
+	//index field indexes from the document struct, through nested structs and pointers to struct
+	index []int
+	//path dotted json name of the field, like it is used in queries and updates
+	path          string
+	deterministic bool
+}
+
+//FieldEncryption encrypt the string and []byte fields of documents those are tagged by godb:"encrypt"
+//before they are written and decrypt them after they are read.
+//
+//	type User struct {
+//		ID    string `json:"ID" bson:"ID"`
+//		Name  string `json:"Name" bson:"Name" godb:"encrypt"`
+//		Email string `json:"Email" bson:"Email" godb:"encrypt,deterministic"`
+//	}
+//
+//Each value is encrypted by AES-GCM with its own random data key, the data key is encrypted by the current key
+//of the KeyProvider and stored with the value with the id of that key, so keys can be rotated without
+//rewriting documents. Deterministic fields derive their key and nonce from the key of the provider and the value,
+//the same value always has the same ciphertext so it can be compared by =, != and in.
+//
+//Fields are found by the json tag, so json and bson names must be the same. Fields inside slices and maps are not encrypted.
+//Values those are not encrypted, like documents written before encryption was enabled, are read as they are.
+//
+//Map documents, updates and queries with filters need the collection to be registered, by Register or by putting
+//a struct document, so a process that just started must register its collections before it writes. They fail with
+//UnregisteredEncryptedCollection otherwise, so encrypted fields are never written in plaintext. Reading a map
+//that has encrypted values from a collection that is not registered fail the same way.
+type FieldEncryption struct {
+	keys KeyProvider
+	mux  sync.RWMutex
+	//fields encrypted fields by path of the types those are registered for a collection
+	fields map[string]map[string]bool
+	types  sync.Map
+}
+
+//NewFieldEncryption make field encryption with keys
+func NewFieldEncryption(keys KeyProvider) *FieldEncryption {
+
+	return &FieldEncryption{keys: keys, fields: map[string]map[string]bool{}}
+}
+
+//Register register the encrypted fields of sample, a struct or pointer to struct, for collection.
+//A sample without encrypted fields register a collection whose documents are written as they are.
+func (encryption *FieldEncryption) Register(collection string, sample interface{}) error {
+
+	fields, err := encryption.fieldsOf(reflect.TypeOf(sample))
+	if err != nil {
+
+		return err
+	}
+	encryption.register(collection, fields)
+	return nil
+}
+
+func (encryption *FieldEncryption) register(collection string, fields []encryptedField) {
+
+	encryption.mux.RLock()
+	known := encryption.fields[collection]
+	registered := known != nil
+	for _, field := range fields {
+		if deterministic, ok := known[field.path]; !ok || deterministic != field.deterministic {
+			registered = false
+		}
+	}
+	encryption.mux.RUnlock()
+
+	if registered {
+
+		return
+	}
+	encryption.mux.Lock()
+	defer encryption.mux.Unlock()
+
+	if encryption.fields[collection] == nil {
+		encryption.fields[collection] = map[string]bool{}
+	}
+	for _, field := range fields {
+		encryption.fields[collection][field.path] = field.deterministic
+	}
+}
+
+//paths return the encrypted paths of collection with if they are deterministic, and if collection is registered
+func (encryption *FieldEncryption) paths(collection string) (map[string]bool, bool) {
+
+	encryption.mux.RLock()
+	defer encryption.mux.RUnlock()
+
+	known, registered := encryption.fields[collection]
+	paths := map[string]bool{}
+	for path, deterministic := range known {
+		paths[path] = deterministic
+	}
+	return paths, registered
+}
+
+//requireRegistered return UnregisteredEncryptedCollection if collection is not registered
+func (encryption *FieldEncryption) requireRegistered(collection string) error {
+
+	encryption.mux.RLock()
+	defer encryption.mux.RUnlock()
+
+	if _, registered := encryption.fields[collection]; !registered {
+
+		return fmt.Errorf("%w: %s", UnregisteredEncryptedCollection, collection)
+	}
+	return nil
+}
+
+//field return if path of collection is encrypted and if it is deterministic
+func (encryption *FieldEncryption) field(collection string, path string) (bool, bool) {
+
+	encryption.mux.RLock()
+	defer encryption.mux.RUnlock()
+
+	deterministic, ok := encryption.fields[collection][path]
+	return ok, deterministic
+}
+
+//fieldsOf return encrypted fields of a struct type or pointer to struct type, types are cached
+func (encryption *FieldEncryption) fieldsOf(t reflect.Type) ([]encryptedField, error) {
+
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+
+		return nil, nil
+	}
+	if fields, ok := encryption.types.Load(t); ok {
+
+		return fields.([]encryptedField), nil
+	}
+	fields, err := collectEncryptedFields(t, "", nil, map[reflect.Type]bool{})
+	if err != nil {
+
+		return nil, err
+	}
+	encryption.types.Store(t, fields)
+	return fields, nil
+}
+
+func collectEncryptedFields(t reflect.Type, prefix string, index []int, visiting map[reflect.Type]bool) ([]encryptedField, error) {
+
+	if visiting[t] {
+
+		return nil, nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	fields := []encryptedField{}
+
+	for i := 0; i < t.NumField(); i++ {
+
+		structField := t.Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		fieldType := structField.Type
+
+		options := strings.Split(structField.Tag.Get("godb"), ",")
+		if options[0] == "encrypt" {
+
+			if name == "" {
+				name = structField.Name
+			}
+			if fieldType.Kind() != reflect.String && !(fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Uint8) {
+
+				return nil, fmt.Errorf("%w: %s", UnsupportedEncryptedField, prefix+name)
+			}
+			deterministic := len(options) > 1 && options[1] == "deterministic"
+			fields = append(fields, encryptedField{index: fieldIndex, path: prefix + name, deterministic: deterministic})
+			continue
+		}
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.Struct {
+			continue
+		}
+		nestedPrefix := prefix
+		if !structField.Anonymous || name != "" {
+			if name == "" {
+				name = structField.Name
+			}
+			nestedPrefix = prefix + name + "."
+		}
+		nested, err := collectEncryptedFields(fieldType, nestedPrefix, fieldIndex, visiting)
+		if err != nil {
+
+			return nil, err
+		}
+		fields = append(fields, nested...)
+	}
+	return fields, nil
+}
+
+//visitFields call fn for every encrypted field of the struct value, nil pointers on the way are skipped.
+//Pointers on the way are replaced by pointers to copies when copyPointers is set.
+func visitFields(value reflect.Value, fields []encryptedField, copyPointers bool, fn func(value reflect.Value, field encryptedField) error) error {
+
+	for _, field := range fields {
+
+		current := value
+		skip := false
+
+		for _, i := range field.index {
+
+			for current.Kind() == reflect.Ptr {
+
+				if current.IsNil() {
+					skip = true
+					break
+				}
+				if copyPointers {
+					copied := reflect.New(current.Type().Elem())
+					copied.Elem().Set(current.Elem())
+					current.Set(copied)
+				}
+				current = current.Elem()
+			}
+			if skip {
+				break
+			}
+			current = current.Field(i)
+		}
+		if skip {
+			continue
+		}
+		if err := fn(current, field); err != nil {
+
+			return err
+		}
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+
+		return nil, InvalidEncryptionKey
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveKey(key []byte, purpose string, path string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("godbengine-" + purpose + ":" + path))
+	return mac.Sum(nil)
+}
+
+func (encryption *FieldEncryption) currentKey() (string, []byte, error) {
+
+	id, err := encryption.keys.CurrentKeyID()
+	if err != nil {
+
+		return "", nil, err
+	}
+	if id == "" || strings.Contains(id, ":") {
+
+		return "", nil, InvalidEncryptionKey
+	}
+	key, err := encryption.keys.Key(id)
+	if err != nil {
+
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+//Encrypt encrypt plaintext of the field at path with the current key
+func (encryption *FieldEncryption) Encrypt(path string, plaintext []byte, deterministic bool) (string, error) {
+
+	id, key, err := encryption.currentKey()
+	if err != nil {
+
+		return "", err
+	}
+	if deterministic {
+
+		return encryptDeterministic(id, key, path, plaintext)
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+
+		return "", err
+	}
+	keyGCM, err := newGCM(key)
+	if err != nil {
+
+		return "", err
+	}
+	wrappedKey, err := seal(keyGCM, dataKey, []byte(id))
+	if err != nil {
+
+		return "", err
+	}
+	dataGCM, _ := newGCM(dataKey)
+	ciphertext, err := seal(dataGCM, plaintext, []byte(path))
+	if err != nil {
+
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return __encrypted_prefix + "r:" + id + ":" + encoding.EncodeToString(wrappedKey) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+
+	if len(ciphertext) < aead.NonceSize() {
+
+		return nil, InvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+
+		return nil, InvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+func encryptDeterministic(id string, key []byte, path string, plaintext []byte) (string, error) {
+
+	aead, err := newGCM(deriveKey(key, "deterministic-key", path))
+	if err != nil {
+
+		return "", err
+	}
+	mac := hmac.New(sha256.New, deriveKey(key, "deterministic-nonce", path))
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(path))
+	return __encrypted_prefix + "d:" + id + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+//IsEncrypted check if value was made by Encrypt
+func IsEncrypted(value string) bool {
+
+	return strings.HasPrefix(value, __encrypted_prefix)
+}
+
+//Decrypt decrypt value of the field at path that was made by Encrypt with any key of the provider
+func (encryption *FieldEncryption) Decrypt(path string, value string) ([]byte, error) {
+
+	if !IsEncrypted(value) {
+
+		return nil, InvalidCiphertext
+	}
+	parts := strings.Split(strings.TrimPrefix(value, __encrypted_prefix), ":")
+	if len(parts) < 3 {
+
+		return nil, InvalidCiphertext
+	}
+	key, err := encryption.keys.Key(parts[1])
+	if err != nil {
+
+		return nil, err
+	}
+	encoding := base64.RawURLEncoding
+
+	switch {
+	case parts[0] == "d" && len(parts) == 3:
+		ciphertext, err := encoding.DecodeString(parts[2])
+		if err != nil {
+
+			return nil, InvalidCiphertext
+		}
+		aead, err := newGCM(deriveKey(key, "deterministic-key", path))
+		if err != nil {
+
+			return nil, err
+		}
+		return open(aead, ciphertext, []byte(path))
+
+	case parts[0] == "r" && len(parts) == 4:
+		wrappedKey, err := encoding.DecodeString(parts[2])
+		if err != nil {
+
+			return nil, InvalidCiphertext
+		}
+		ciphertext, err := encoding.DecodeString(parts[3])
+		if err != nil {
+
+			return nil, InvalidCiphertext
+		}
+		keyGCM, err := newGCM(key)
+		if err != nil {
+
+			return nil, err
+		}
+		dataKey, err := open(keyGCM, wrappedKey, []byte(parts[1]))
+		if err != nil {
+
+			return nil, err
+		}
+		dataGCM, err := newGCM(dataKey)
+		if err != nil {
+
+			return nil, InvalidCiphertext
+		}
+		return open(dataGCM, ciphertext, []byte(path))
+	}
+	return nil, InvalidCiphertext
+}
+
+//encryptValue encrypt a string or []byte value, the result has the type of value
+func (encryption *FieldEncryption) encryptValue(path string, value interface{}, deterministic bool) (interface{}, error) {
+
+	switch value := value.(type) {
+	case string:
+		return encryption.Encrypt(path, []byte(value), deterministic)
+	case []byte:
+		if value == nil {
+			return value, nil
+		}
+		ciphertext, err := encryption.Encrypt(path, value, deterministic)
+		return []byte(ciphertext), err
+	}
+	return nil, fmt.Errorf("%w: %s", UnsupportedEncryptedField, path)
+}
+
+//encryptDocument return a copy of document whose encrypted fields are encrypted, or document if it has no encrypted field
+func (encryption *FieldEncryption) encryptDocument(collection string, document interface{}) (interface{}, error) {
+
+	documentType := reflect.TypeOf(document)
+	for documentType != nil && documentType.Kind() == reflect.Ptr {
+		documentType = documentType.Elem()
+	}
+	if documentType == nil || documentType.Kind() != reflect.Struct {
+
+		return encryption.encryptRaw(collection, document)
+	}
+	fields, err := encryption.fieldsOf(documentType)
+	if err != nil {
+
+		return nil, err
+	}
+	encryption.register(collection, fields)
+	if len(fields) == 0 {
+
+		return document, nil
+	}
+
+	value := reflect.ValueOf(document)
+	isPointer := value.Kind() == reflect.Ptr
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return document, nil
+		}
+		value = value.Elem()
+	}
+	copied := reflect.New(value.Type())
+	copied.Elem().Set(value)
+
+	err = visitFields(copied.Elem(), fields, true, func(field reflect.Value, spec encryptedField) error {
+
+		if field.Kind() == reflect.String {
+
+			ciphertext, err := encryption.Encrypt(spec.path, []byte(field.String()), spec.deterministic)
+			field.SetString(ciphertext)
+			return err
+		}
+		if field.IsNil() {
+
+			return nil
+		}
+		ciphertext, err := encryption.Encrypt(spec.path, field.Bytes(), spec.deterministic)
+		field.SetBytes([]byte(ciphertext))
+		return err
+	})
+	if err != nil {
+
+		return nil, err
+	}
+	if isPointer {
+
+		return copied.Interface(), nil
+	}
+	return copied.Elem().Interface(), nil
+}
+
+//encryptRaw encrypt a document that is not a struct by the registered paths of collection,
+//maps are encrypted like decryptMap decrypt them and other documents can not have encrypted paths
+func (encryption *FieldEncryption) encryptRaw(collection string, document interface{}) (interface{}, error) {
+
+	paths, registered := encryption.paths(collection)
+	if !registered {
+
+		return nil, fmt.Errorf("%w: %s", UnregisteredEncryptedCollection, collection)
+	}
+	if len(paths) == 0 || document == nil {
+
+		return document, nil
+	}
+	mapType := reflect.TypeOf(map[string]interface{}{})
+	value := reflect.ValueOf(document)
+	isPointer := value.Kind() == reflect.Ptr
+	if isPointer {
+		if value.IsNil() {
+			return document, nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Map || !value.Type().ConvertibleTo(mapType) {
+
+		return nil, fmt.Errorf("%w: %s document of %s", UnsupportedEncryptedField, value.Type(), collection)
+	}
+	if value.IsNil() {
+
+		return document, nil
+	}
+	encrypted, err := encryption.encryptMap(paths, value.Convert(mapType).Interface().(map[string]interface{}))
+	if err != nil {
+
+		return nil, err
+	}
+	result := reflect.ValueOf(encrypted).Convert(value.Type())
+	if isPointer {
+
+		pointer := reflect.New(value.Type())
+		pointer.Elem().Set(result)
+		return pointer.Interface(), nil
+	}
+	return result.Interface(), nil
+}
+
+//encryptMap return a copy of document whose values at paths are encrypted, maps on the way are copied
+func (encryption *FieldEncryption) encryptMap(paths map[string]bool, document map[string]interface{}) (map[string]interface{}, error) {
+
+	encrypted := copyMap(document)
+
+	for path, deterministic := range paths {
+
+		parts := strings.Split(path, ".")
+		parent := encrypted
+		for _, part := range parts[:len(parts)-1] {
+
+			next, ok := parent[part]
+			if !ok || next == nil {
+				parent = nil
+				break
+			}
+			child, ok := asMap(next)
+			if !ok {
+
+				return nil, fmt.Errorf("%w: %s", UnsupportedEncryptedField, path)
+			}
+			child = copyMap(child)
+			parent[part] = child
+			parent = child
+		}
+		if parent == nil {
+			continue
+		}
+		value, ok := parent[parts[len(parts)-1]]
+		if !ok || value == nil {
+			continue
+		}
+		ciphertext, err := encryption.encryptValue(path, value, deterministic)
+		if err != nil {
+
+			return nil, err
+		}
+		parent[parts[len(parts)-1]] = ciphertext
+	}
+	return encrypted, nil
+}
+
+func copyMap(document map[string]interface{}) map[string]interface{} {
+
+	copied := make(map[string]interface{}, len(document))
+	for key, value := range document {
+		copied[key] = value
+	}
+	return copied
+}
+
+//decryptDocument decrypt encrypted fields of document in place, document is a pointer to struct or a map like bson.M
+func (encryption *FieldEncryption) decryptDocument(collection string, document interface{}) error {
+
+	if documentMap, ok := asMap(document); ok {
+
+		return encryption.decryptMap(collection, documentMap)
+	}
+	if value := reflect.ValueOf(document); value.Kind() == reflect.Ptr && !value.IsNil() {
+
+		if documentMap, ok := asMap(value.Elem().Interface()); ok {
+
+			return encryption.decryptMap(collection, documentMap)
+		}
+	}
+	fields, err := encryption.fieldsOf(reflect.TypeOf(document))
+	if err != nil || len(fields) == 0 {
+
+		return err
+	}
+	value := reflect.ValueOf(document)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+
+		return nil
+	}
+	return visitFields(value, fields, false, func(field reflect.Value, spec encryptedField) error {
+
+		var ciphertext string
+		if field.Kind() == reflect.String {
+			ciphertext = field.String()
+		} else {
+			ciphertext = string(field.Bytes())
+		}
+		if !IsEncrypted(ciphertext) {
+
+			return nil
+		}
+		plaintext, err := encryption.Decrypt(spec.path, ciphertext)
+		if err != nil {
+
+			return fmt.Errorf("decrypt %s: %w", spec.path, err)
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(string(plaintext))
+		} else {
+			field.SetBytes(plaintext)
+		}
+		return nil
+	})
+}
+
+func (encryption *FieldEncryption) decryptMap(collection string, document map[string]interface{}) error {
+
+	if document == nil {
+
+		return nil
+	}
+	paths, registered := encryption.paths(collection)
+	if !registered && hasEncryptedValue(document) {
+
+		return fmt.Errorf("%w: %s", UnregisteredEncryptedCollection, collection)
+	}
+
+	for path := range paths {
+
+		parent, key := lookupParent(document, path, false)
+		if parent == nil {
+			continue
+		}
+		ciphertext, ok := parent[key].(string)
+		if !ok || !IsEncrypted(ciphertext) {
+			continue
+		}
+		plaintext, err := encryption.Decrypt(path, ciphertext)
+		if err != nil {
+
+			return fmt.Errorf("decrypt %s: %w", path, err)
+		}
+		parent[key] = string(plaintext)
+	}
+	return nil
+}
+
+//hasEncryptedValue check if a string of document or of its maps was made by Encrypt
+func hasEncryptedValue(document map[string]interface{}) bool {
+
+	for _, value := range document {
+
+		if text, ok := value.(string); ok && IsEncrypted(text) {
+
+			return true
+		}
+		if child, ok := asMap(value); ok && hasEncryptedValue(child) {
+
+			return true
+		}
+	}
+	return false
+}
+
+//equalValues return the ciphertexts of value by every key of the provider, or by the current key if it can not list keys
+func (encryption *FieldEncryption) equalValues(path string, value interface{}) ([]interface{}, error) {
+
+	var plaintext []byte
+	switch value := value.(type) {
+	case string:
+		plaintext = []byte(value)
+	case []byte:
+		plaintext = value
+	default:
+		return nil, fmt.Errorf("%w: %s", UnsupportedEncryptedField, path)
+	}
+	ids := []string{}
+	if lister, ok := encryption.keys.(KeyLister); ok {
+
+		listed, err := lister.KeyIDs()
+		if err != nil {
+
+			return nil, err
+		}
+		ids = listed
+	}
+	if len(ids) == 0 {
+
+		id, err := encryption.keys.CurrentKeyID()
+		if err != nil {
+
+			return nil, err
+		}
+		ids = []string{id}
+	}
+	values := []interface{}{}
+	for _, id := range ids {
+
+		key, err := encryption.keys.Key(id)
+		if err != nil {
+
+			return nil, err
+		}
+		ciphertext, err := encryptDeterministic(id, key, path, plaintext)
+		if err != nil {
+
+			return nil, err
+		}
+		if _, ok := value.([]byte); ok {
+			values = append(values, []byte(ciphertext))
+		} else {
+			values = append(values, ciphertext)
+		}
+	}
+	return values, nil
+}
+
+//encryptQuery return a copy of query whose filters on deterministic fields compare ciphertexts
+func (encryption *FieldEncryption) encryptQuery(query DBQuery) (DBQuery, error) {
+
+	if query.Condition == nil || !hasFilter(query.Condition) {
+
+		return query, nil
+	}
+	if err := encryption.requireRegistered(query.Collection); err != nil {
+
+		return query, err
+	}
+	condition, err := encryption.encryptCondition(query.Collection, query.Condition)
+	if err != nil {
+
+		return query, err
+	}
+	query.Condition = condition
+	return query, nil
+}
+
+//hasFilter check if rule set has a rule that may compare a field
+func hasFilter(ruleSet *gocondition.RuleSet) bool {
+
+	for _, child := range ruleSet.Children {
+
+		if nested, ok := child.(*gocondition.RuleSet); !ok || hasFilter(nested) {
+
+			return true
+		}
+	}
+	return false
+}
+
+func (encryption *FieldEncryption) encryptCondition(collection string, ruleSet *gocondition.RuleSet) (*gocondition.RuleSet, error) {
+
+	encrypted := &gocondition.RuleSet{Type: ruleSet.Type, Children: make([]gocondition.IRule, 0, len(ruleSet.Children))}
+
+	for _, child := range ruleSet.Children {
+
+		switch child := child.(type) {
+		case *gocondition.RuleSet:
+			nested, err := encryption.encryptCondition(collection, child)
+			if err != nil {
+
+				return nil, err
+			}
+			encrypted.Children = append(encrypted.Children, nested)
+
+		case *DBFilterItem:
+			rule, err := encryption.encryptFilter(collection, child)
+			if err != nil {
+
+				return nil, err
+			}
+			encrypted.Children = append(encrypted.Children, rule)
+
+		default:
+			encrypted.Children = append(encrypted.Children, child)
+		}
+	}
+	return encrypted, nil
+}
+
+func (encryption *FieldEncryption) encryptFilter(collection string, item *DBFilterItem) (gocondition.IRule, error) {
+
+	encrypted, deterministic := encryption.field(collection, item.Field)
+	if !encrypted {
+
+		return item, nil
+	}
+	if !deterministic {
+
+		return nil, fmt.Errorf("%w: %s", EncryptedFieldOperation, item.Field)
+	}
+	switch item.Operator {
+	case "=", "!=":
+		values, err := encryption.equalValues(item.Field, item.FieldValue)
+		if err != nil {
+
+			return nil, err
+		}
+		if len(values) == 1 {
+
+			return &DBFilterItem{Field: item.Field, Operator: item.Operator, FieldValue: values[0]}, nil
+		}
+		if item.Operator == "=" {
+
+			return &DBFilterItem{Field: item.Field, Operator: "in", FieldValue: values}, nil
+		}
+		notEqual := &gocondition.RuleSet{Type: gocondition.RuleAnd, Children: []gocondition.IRule{}}
+		for _, value := range values {
+			notEqual.Children = append(notEqual.Children, &DBFilterItem{Field: item.Field, Operator: "!=", FieldValue: value})
+		}
+		return notEqual, nil
+
+	case "in":
+		list := reflect.ValueOf(item.FieldValue)
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+
+			return nil, fmt.Errorf("%w: %s", EncryptedFieldOperation, item.Field)
+		}
+		values := []interface{}{}
+		for i := 0; i < list.Len(); i++ {
+
+			encryptedValues, err := encryption.equalValues(item.Field, list.Index(i).Interface())
+			if err != nil {
+
+				return nil, err
+			}
+			values = append(values, encryptedValues...)
+		}
+		return &DBFilterItem{Field: item.Field, Operator: "in", FieldValue: values}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", EncryptedFieldOperation, item.Field)
+}
+
+//encryptUpdate return a copy of update whose set values of encrypted fields are encrypted
+func (encryption *FieldEncryption) encryptUpdate(collection string, update Update) (Update, error) {
+
+	if err := encryption.requireRegistered(collection); err != nil {
+
+		return update, err
+	}
+	encrypted := Update{Operations: make([]UpdateOperation, 0, len(update.Operations))}
+
+	for _, operation := range update.Operations {
+
+		isEncrypted, deterministic := encryption.field(collection, operation.Field)
+		if isEncrypted {
+
+			switch operation.Operator {
+			case UpdateSet:
+				value, err := encryption.encryptValue(operation.Field, operation.Value, deterministic)
+				if err != nil {
+
+					return update, err
+				}
+				operation.Value = value
+			case UpdateUnset:
+			default:
+				return update, fmt.Errorf("%w: %s", EncryptedFieldOperation, operation.Field)
+			}
+		}
+		encrypted.Operations = append(encrypted.Operations, operation)
+	}
+	return encrypted, nil
+}
+
+//Rekey read documents those match query and write them back so their fields are encrypted by the current key.
+//newDocument make the document that a result is decoded into, its type is registered for the collection.
+//pool is wrapped by the encryption if it is not yet.
+func (encryption *FieldEncryption) Rekey(pool DocumentPool, query DBQuery, newDocument func() Document) (int64, error) {
+
+	if err := encryption.Register(query.Collection, newDocument()); err != nil {
+
+		return 0, err
+	}
+	found := false
+	walkPool(pool, func(pool interface{}) bool {
+
+		encrypted, ok := pool.(*encryptedDocumentPool)
+		found = ok && encrypted.encryption == encryption
+		return !found
+	})
+	if !found {
+
+		pool = EncryptFieldsDocumentPool(encryption)(pool)
+	}
+	result := pool.Query(query)
+	defer result.Close()
+
+	if err := result.Error(); err != nil {
+
+		return 0, err
+	}
+	documents := []Document{}
+	for {
+		document := newDocument()
+		if err := result.Next(document); err != nil {
+
+			if err == NoDocument || pool.IsNoRecordError(err) {
+				break
+			}
+			return 0, err
+		}
+		documents = append(documents, document)
+	}
+	count := int64(0)
+	for _, document := range documents {
+
+		if err := pool.Put(query.Collection, document); err != nil {
+
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+//EncryptFieldsDocumentPool encrypt the fields those are tagged by godb:"encrypt" with encryption, see FieldEncryption.
+//Map documents are encrypted and decrypted by the paths those are registered for their collection.
+func EncryptFieldsDocumentPool(encryption *FieldEncryption) DocumentPoolMiddleware {
+
+	return func(next DocumentPool) DocumentPool {
+
+		return newEncryptedDocumentPool(next, encryption)
+	}
+}
+
+func newEncryptedDocumentPool(next DocumentPool, encryption *FieldEncryption) *encryptedDocumentPool {
+
+	pool := &encryptedDocumentPool{
+		DocumentPoolWrapper: NewDocumentPoolWrapper(next),
+		encryption:          encryption,
+	}
+	pool.WrapTransaction = func(tx DBTransaction) DBTransaction {
+
+		return &encryptedTransaction{DBTransaction: tx, encryption: encryption}
+	}
+	return pool
+}
+
+type encryptedDocumentPool struct {
+	*DocumentPoolWrapper
+	encryption *FieldEncryption
+}
+
+//Rewrap wrap next with the same encryption
+func (pool *encryptedDocumentPool) Rewrap(next DocumentPool) DocumentPool {
+
+	return newEncryptedDocumentPool(next, pool.encryption)
+}
+
+func (pool *encryptedDocumentPool) Put(collection string, document Document) error {
+
+	encrypted, err := pool.encryption.encryptDocument(collection, document)
+	if err != nil {
+
+		return err
+	}
+	return pool.DocumentPool.Put(collection, encrypted.(Document))
+}
+
+func (pool *encryptedDocumentPool) PutRaw(collection string, id string, document interface{}) error {
+
+	encrypted, err := pool.encryption.encryptDocument(collection, document)
+	if err != nil {
+
+		return err
+	}
+	return pool.DocumentPool.PutRaw(collection, id, encrypted)
+}
+
+func (pool *encryptedDocumentPool) RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error {
+
+	return pool.DocumentPoolWrapper.RunInTransaction(ctx, func(tx DBTransaction) error {
+
+		err := fn(tx)
+		if encrypted, ok := tx.(*encryptedTransaction); ok && err == nil {
+
+			return encrypted.err
+		}
+		return err
+	}, options...)
+}
+
+func (pool *encryptedDocumentPool) Get(collection string, id string, document interface{}) error {
+
+	if err := pool.DocumentPool.Get(collection, id, document); err != nil {
+
+		return err
+	}
+	return pool.encryption.decryptDocument(collection, document)
+}
+
+func (pool *encryptedDocumentPool) Update(collection string, id string, update Update) error {
+
+	encrypted, err := pool.encryption.encryptUpdate(collection, update)
+	if err != nil {
+
+		return err
+	}
+	return pool.DocumentPool.Update(collection, id, encrypted)
+}
+
+func (pool *encryptedDocumentPool) Query(query DBQuery) DBQueryResult {
+
+	encrypted, err := pool.encryption.encryptQuery(query)
+	if err != nil {
+
+		return &errorQueryResult{err: err}
+	}
+	return &encryptedQueryResult{DBQueryResult: pool.DocumentPool.Query(encrypted), encryption: pool.encryption, collection: query.Collection}
+}
+
+func (pool *encryptedDocumentPool) UpdateWhere(query DBQuery, update Update) (int64, error) {
+
+	encrypted, err := pool.encryption.encryptQuery(query)
+	if err != nil {
+
+		return 0, err
+	}
+	encryptedUpdate, err := pool.encryption.encryptUpdate(query.Collection, update)
+	if err != nil {
+
+		return 0, err
+	}
+	return pool.DocumentPool.UpdateWhere(encrypted, encryptedUpdate)
+}
+
+func (pool *encryptedDocumentPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	encrypted, err := pool.encryption.encryptQuery(query)
+	if err != nil {
+
+		return 0, err
+	}
+	return pool.DocumentPool.DeleteWhere(encrypted)
+}
+
+func (pool *encryptedDocumentPool) CollectVaryQueryInt(query DBQuery, field string) (map[string]int, error) {
+
+	encrypted, err := pool.encryption.encryptQuery(query)
+	if err != nil {
+
+		return nil, err
+	}
+	return pool.DocumentPool.CollectVaryQueryInt(encrypted, field)
+}
+
+func (pool *encryptedDocumentPool) CollectVaryQueryString(query DBQuery, field string) (map[string]int, error) {
+
+	encrypted, err := pool.encryption.encryptQuery(query)
+	if err != nil {
+
+		return nil, err
+	}
+	return pool.DocumentPool.CollectVaryQueryString(encrypted, field)
+}
+
+func (pool *encryptedDocumentPool) Watch(ctx context.Context, collection string, query *DBQuery, resumeToken string) <-chan ChangeEvent {
+
+	if query != nil {
+
+		encrypted, err := pool.encryption.encryptQuery(*query)
+		if err != nil {
+
+			events := make(chan ChangeEvent, 1)
+			events <- ChangeEvent{Collection: collection, Err: err}
+			close(events)
+			return events
+		}
+		query = &encrypted
+	}
+	events := pool.DocumentPool.Watch(ctx, collection, query, resumeToken)
+	decrypted := make(chan ChangeEvent)
+
+	go func() {
+		defer close(decrypted)
+
+		for event := range events {
+
+			if err := pool.encryption.decryptMap(event.Collection, event.Document); err != nil && event.Err == nil {
+				event.Err = err
+			}
+			original := event
+			eventCollection := event.Collection
+			event.decode = func(document interface{}) error {
+
+				if err := original.Decode(document); err != nil {
+
+					return err
+				}
+				return pool.encryption.decryptDocument(eventCollection, document)
+			}
+			select {
+			case decrypted <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return decrypted
+}
+
+//encryptedQueryResult decrypt documents of a query result
+type encryptedQueryResult struct {
+	DBQueryResult
+	encryption *FieldEncryption
+	collection string
+}
+
+func (result *encryptedQueryResult) Next(document interface{}) error {
+
+	if err := result.DBQueryResult.Next(document); err != nil {
+
+		return err
+	}
+	return result.encryption.decryptDocument(result.collection, document)
+}
+
+func (result *encryptedQueryResult) GetOne(document interface{}) error {
+
+	if err := result.DBQueryResult.GetOne(document); err != nil {
+
+		return err
+	}
+	return result.encryption.decryptDocument(result.collection, document)
+}
+
+//encryptedTransaction encrypt writes and decrypt reads of a transaction.
+//A write that fail to be encrypted is dropped and fail Commit.
+type encryptedTransaction struct {
+	DBTransaction
+	encryption *FieldEncryption
+	err        error
+}
+
+func (tx *encryptedTransaction) fail(err error) {
+
+	if tx.err == nil {
+		tx.err = err
+	}
+}
+
+func (tx *encryptedTransaction) Get(collection string, id string, document interface{}) error {
+
+	if err := tx.DBTransaction.Get(collection, id, document); err != nil {
+
+		return err
+	}
+	return tx.encryption.decryptDocument(collection, document)
+}
+
+func (tx *encryptedTransaction) Query(query DBQuery) DBQueryResult {
+
+	encrypted, err := tx.encryption.encryptQuery(query)
+	if err != nil {
+
+		return &errorQueryResult{err: err}
+	}
+	return &encryptedQueryResult{DBQueryResult: tx.DBTransaction.Query(encrypted), encryption: tx.encryption, collection: query.Collection}
+}
+
+func (tx *encryptedTransaction) Put(collection string, document Document) {
+
+	encrypted, err := tx.encryption.encryptDocument(collection, document)
+	if err != nil {
+
+		tx.fail(err)
+		return
+	}
+	tx.DBTransaction.Put(collection, encrypted.(Document))
+}
+
+func (tx *encryptedTransaction) PutRaw(collection string, id string, document interface{}) {
+
+	encrypted, err := tx.encryption.encryptDocument(collection, document)
+	if err != nil {
+
+		tx.fail(err)
+		return
+	}
+	tx.DBTransaction.PutRaw(collection, id, encrypted)
+}
+
+func (tx *encryptedTransaction) Update(collection string, id string, update Update) {
+
+	encrypted, err := tx.encryption.encryptUpdate(collection, update)
+	if err != nil {
+
+		tx.fail(err)
+		return
+	}
+	tx.DBTransaction.Update(collection, id, encrypted)
+}
+
+func (tx *encryptedTransaction) Rollback() error {
+
+	tx.err = nil
+	return tx.DBTransaction.Rollback()
+}
+
+func (tx *encryptedTransaction) Commit() error {
+
+	if tx.err != nil {
+
+		return tx.err
+	}
+	return tx.DBTransaction.Commit()
+}
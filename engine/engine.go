@@ -1,9 +1,16 @@
 package engine
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
+	"sort"
+	"sync"
+	"time"
 )
 
+//DefaultPoolName name of the pools those are given to Init
+const DefaultPoolName = "default"
+
 //Engine hold all adapter to ready to work
 type Engine struct {
 	documentPool         DocumentPool
@@ -11,32 +18,203 @@ type Engine struct {
 	filePool             FilePool
 	adminPublicKeyString string
 	adminPublicKey       *rsa.PublicKey
+	adminEd25519Key      ed25519.PublicKey
+	adminNoncePool       MemPool
+	adminNonces          map[string]time.Time
+	adminMux             sync.Mutex
+	logger               Logger
+	observer             Observer
+	namedMux             sync.RWMutex
+	documentPools        map[string]DocumentPool
+	memPools             map[string]MemPool
+	filePools            map[string]FilePool
+	documentRoutes       routes
+	memRoutes            routes
+	fileRoutes           routes
+}
+
+//NewEngine make an engine with pools, it is independent of other engines so tests can run in parallel with different backends
+func NewEngine(memPool MemPool, documentPool DocumentPool, filePool FilePool) *Engine {
+
+	engine := &Engine{}
+	engine.Init(memPool, documentPool, filePool)
+	return engine
 }
 
 //Init init engine
 func (engine *Engine) Init(memPool MemPool, documentPool DocumentPool, filePool FilePool) {
 
+	engine.namedMux.Lock()
+
 	engine.memPool = memPool
 
 	engine.documentPool = documentPool
 
 	engine.filePool = filePool
+
+	engine.namedMux.Unlock()
+
+	engine.guardPools()
+	engine.propagateLogger()
+	engine.propagateObserver()
 }
 
 //GetMemPool get current mempool
 func (engine *Engine) GetMemPool() MemPool {
 
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
 	return engine.memPool
 }
 
 //GetDocumentPool get current document pool
 func (engine *Engine) GetDocumentPool() DocumentPool {
 
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
 	return engine.documentPool
 }
 
 //GetFilePool get current file pool
 func (engine *Engine) GetFilePool() FilePool {
 
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
 	return engine.filePool
 }
+
+//RegisterDocumentPool register pool with name, DefaultPoolName replace the pool given to Init
+func (engine *Engine) RegisterDocumentPool(name string, pool DocumentPool) {
+
+	engine.namedMux.Lock()
+	if name == DefaultPoolName {
+
+		engine.documentPool = pool
+	} else {
+
+		if engine.documentPools == nil {
+			engine.documentPools = map[string]DocumentPool{}
+		}
+		engine.documentPools[name] = pool
+	}
+	engine.namedMux.Unlock()
+
+	engine.guardPools()
+	engine.propagateLogger()
+	engine.propagateObserver()
+}
+
+//GetDocumentPoolNamed get document pool registered with name, nil if there is none
+func (engine *Engine) GetDocumentPoolNamed(name string) DocumentPool {
+
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
+	if name == DefaultPoolName {
+
+		return engine.documentPool
+	}
+	return engine.documentPools[name]
+}
+
+//RegisterMemPool register pool with name, DefaultPoolName replace the pool given to Init
+func (engine *Engine) RegisterMemPool(name string, pool MemPool) {
+
+	engine.namedMux.Lock()
+	if name == DefaultPoolName {
+
+		engine.memPool = pool
+	} else {
+
+		if engine.memPools == nil {
+			engine.memPools = map[string]MemPool{}
+		}
+		engine.memPools[name] = pool
+	}
+	engine.namedMux.Unlock()
+
+	engine.propagateLogger()
+	engine.propagateObserver()
+}
+
+//GetMemPoolNamed get mem pool registered with name, nil if there is none
+func (engine *Engine) GetMemPoolNamed(name string) MemPool {
+
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
+	if name == DefaultPoolName {
+
+		return engine.memPool
+	}
+	return engine.memPools[name]
+}
+
+//RegisterFilePool register pool with name, DefaultPoolName replace the pool given to Init
+func (engine *Engine) RegisterFilePool(name string, pool FilePool) {
+
+	engine.namedMux.Lock()
+	if name == DefaultPoolName {
+
+		engine.filePool = pool
+	} else {
+
+		if engine.filePools == nil {
+			engine.filePools = map[string]FilePool{}
+		}
+		engine.filePools[name] = pool
+	}
+	engine.namedMux.Unlock()
+
+	engine.propagateLogger()
+	engine.propagateObserver()
+}
+
+//GetFilePoolNamed get file pool registered with name, nil if there is none
+func (engine *Engine) GetFilePoolNamed(name string) FilePool {
+
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
+	if name == DefaultPoolName {
+
+		return engine.filePool
+	}
+	return engine.filePools[name]
+}
+
+//PoolNames return sorted names of the registered pools of every kind, DefaultPoolName is included when the pool is set
+func (engine *Engine) PoolNames() (documentPools []string, memPools []string, filePools []string) {
+
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
+	documentPools = []string{}
+	if engine.documentPool != nil {
+		documentPools = append(documentPools, DefaultPoolName)
+	}
+	for name := range engine.documentPools {
+		documentPools = append(documentPools, name)
+	}
+	memPools = []string{}
+	if engine.memPool != nil {
+		memPools = append(memPools, DefaultPoolName)
+	}
+	for name := range engine.memPools {
+		memPools = append(memPools, name)
+	}
+	filePools = []string{}
+	if engine.filePool != nil {
+		filePools = append(filePools, DefaultPoolName)
+	}
+	for name := range engine.filePools {
+		filePools = append(filePools, name)
+	}
+	sort.Strings(documentPools)
+	sort.Strings(memPools)
+	sort.Strings(filePools)
+	return documentPools, memPools, filePools
+}
@@ -2,6 +2,8 @@ package engine
 
 //FilePool provide file store service
 type FilePool interface {
+	Lifecycle
+
 	Read(path string) (*[]byte, error)
 	Write(path string, content *[]byte) error
 	Delete(path string) error
@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var InvalidEncodedFile = errors.New("invalid encoded file")
+var DecodedFileTooLarge = errors.New("decoded file is too large")
+
+//DefaultMaxDecodedFileSize is the limit of decompressed content when FileEncodingOptions.MaxDecodedSize is not set
+const DefaultMaxDecodedFileSize = 256 << 20
+
+//FileCodec compression of an encoded file
+type FileCodec byte
+
+const (
+	FileCodecNone FileCodec = 0
+	FileCodecGzip FileCodec = 1
+	FileCodecZstd FileCodec = 2
+)
+
+//encoded file header: magic, version, codec, flags, length of key id, key id.
+//Encrypted content is authenticated with the header and the path of the file.
+const __file_magic = "GDBF"
+const __file_version = 1
+const __file_flag_encrypted = 1
+
+//FileEncodingOptions options of EncodeFilePool
+type FileEncodingOptions struct {
+	Codec FileCodec
+	//MinCompressSize content smaller than this is stored without compression
+	MinCompressSize int
+	//Keys encrypt content by AES-GCM with the current key when it is set, see KeyProvider.
+	//It is needed to read encrypted files even when new files are not encrypted.
+	Keys KeyProvider
+	//MaxDecodedSize reading a file whose decompressed content is larger fail with DecodedFileTooLarge,
+	//default is DefaultMaxDecodedFileSize
+	MaxDecodedSize int64
+}
+
+var zstdOnce sync.Once
+var zstdEncoder *zstd.Encoder
+var zstdErr error
+
+func zstdCodec() (*zstd.Encoder, error) {
+
+	zstdOnce.Do(func() {
+
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+	return zstdEncoder, zstdErr
+}
+
+func compressFile(codec FileCodec, content []byte) ([]byte, error) {
+
+	switch codec {
+	case FileCodecNone:
+		return content, nil
+
+	case FileCodecGzip:
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		if _, err := writer.Write(content); err != nil {
+
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+
+	case FileCodecZstd:
+		encoder, err := zstdCodec()
+		if err != nil {
+
+			return nil, err
+		}
+		return encoder.EncodeAll(content, nil), nil
+	}
+	return nil, InvalidEncodedFile
+}
+
+func decompressFile(codec FileCodec, content []byte, maxSize int64) ([]byte, error) {
+
+	var reader io.Reader
+	switch codec {
+	case FileCodecNone:
+		return content, nil
+
+	case FileCodecGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+
+			return nil, InvalidEncodedFile
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+
+	case FileCodecZstd:
+		decoder, err := zstd.NewReader(bytes.NewReader(content), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+
+			return nil, err
+		}
+		defer decoder.Close()
+		reader = decoder
+
+	default:
+		return nil, InvalidEncodedFile
+	}
+	//read one byte more than the limit to tell content of the limit size from larger content
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+
+		return nil, err
+	}
+	if int64(len(decompressed)) > maxSize {
+
+		return nil, DecodedFileTooLarge
+	}
+	return decompressed, nil
+}
+
+//fileAdditionalData authenticate header with the path of the file, so a file can not be moved to another path
+func fileAdditionalData(header []byte, filePath string) []byte {
+
+	additionalData := append([]byte{}, header...)
+	return append(additionalData, path.Clean("/"+filePath)...)
+}
+
+//EncodeFileContent compress and encrypt content of the file at filePath by options and prefix it with a header
+//that record the codec and the key id. The encrypted content can only be decoded for the same path.
+func EncodeFileContent(filePath string, content []byte, options FileEncodingOptions) ([]byte, error) {
+
+	codec := options.Codec
+	if len(content) < options.MinCompressSize {
+		codec = FileCodecNone
+	}
+	payload, err := compressFile(codec, content)
+	if err != nil {
+
+		return nil, err
+	}
+	header := []byte(__file_magic)
+	header = append(header, __file_version, byte(codec))
+
+	if options.Keys == nil {
+
+		header = append(header, 0, 0)
+		return append(header, payload...), nil
+	}
+	id, err := options.Keys.CurrentKeyID()
+	if err != nil {
+
+		return nil, err
+	}
+	if id == "" || len(id) > 255 {
+
+		return nil, InvalidEncryptionKey
+	}
+	key, err := options.Keys.Key(id)
+	if err != nil {
+
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+
+		return nil, err
+	}
+	header = append(header, __file_flag_encrypted, byte(len(id)))
+	header = append(header, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+
+		return nil, err
+	}
+	encoded := append(header, nonce...)
+	return aead.Seal(encoded, nonce, payload, fileAdditionalData(header, filePath)), nil
+}
+
+//DecodeFileContent decode content of the file at filePath that was made by EncodeFileContent, content without the header
+//is returned as it is. options.Keys is needed when content is encrypted.
+func DecodeFileContent(filePath string, content []byte, options FileEncodingOptions) ([]byte, error) {
+
+	if !bytes.HasPrefix(content, []byte(__file_magic)) || len(content) < len(__file_magic)+4 || content[len(__file_magic)] != __file_version {
+
+		return content, nil
+	}
+	offset := len(__file_magic)
+	codec := FileCodec(content[offset+1])
+	flags := content[offset+2]
+	idLength := int(content[offset+3])
+	offset += 4
+
+	if len(content) < offset+idLength {
+
+		return nil, InvalidEncodedFile
+	}
+	header := content[:offset+idLength]
+	id := string(content[offset : offset+idLength])
+	payload := content[offset+idLength:]
+
+	if flags&__file_flag_encrypted != 0 {
+
+		if options.Keys == nil {
+
+			return nil, EncryptionKeyNotFound
+		}
+		key, err := options.Keys.Key(id)
+		if err != nil {
+
+			return nil, err
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+
+			return nil, err
+		}
+		if len(payload) < aead.NonceSize() {
+
+			return nil, InvalidEncodedFile
+		}
+		nonce := payload[:aead.NonceSize()]
+		if payload, err = aead.Open(nil, nonce, payload[aead.NonceSize():], fileAdditionalData(header, filePath)); err != nil {
+
+			return nil, InvalidCiphertext
+		}
+	}
+	maxSize := options.MaxDecodedSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecodedFileSize
+	}
+	return decompressFile(codec, payload, maxSize)
+}
+
+//EncodeFilePool compress and encrypt files on Write and decode them on Read, see EncodeFileContent.
+//Files those were written without encoding are read as they are so the middleware can be added to a pool that has files.
+func EncodeFilePool(options FileEncodingOptions) FilePoolMiddleware {
+
+	return func(next FilePool) FilePool {
+
+		return &encodedFilePool{FilePoolWrapper: NewFilePoolWrapper(next), options: options}
+	}
+}
+
+type encodedFilePool struct {
+	*FilePoolWrapper
+	options FileEncodingOptions
+}
+
+func (pool *encodedFilePool) Read(path string) (*[]byte, error) {
+
+	content, err := pool.FilePool.Read(path)
+	if err != nil || content == nil {
+
+		return content, err
+	}
+	decoded, err := DecodeFileContent(path, *content, pool.options)
+	if err != nil {
+
+		return nil, err
+	}
+	return &decoded, nil
+}
+
+func (pool *encodedFilePool) Write(path string, content *[]byte) error {
+
+	if content == nil {
+
+		return pool.FilePool.Write(path, content)
+	}
+	encoded, err := EncodeFileContent(path, *content, pool.options)
+	if err != nil {
+
+		return err
+	}
+	return pool.FilePool.Write(path, &encoded)
+}
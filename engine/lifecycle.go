@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Lifecycle is implemented by every pool
+type Lifecycle interface {
+	//Ping check if backend is reachable
+	Ping(ctx context.Context) error
+	//Close release connections of pool, pool must not be used after closing
+	Close(ctx context.Context) error
+}
+
+//PoolHealth health of a pool
+type PoolHealth struct {
+	Pool    string        `json:"pool"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+//Health health report of an engine, it is healthy when every pool is healthy
+type Health struct {
+	Healthy bool         `json:"healthy"`
+	Pools   []PoolHealth `json:"pools"`
+}
+
+//samePool check if a and b are the same pool without panicking on uncomparable pools
+func samePool(a interface{}, b interface{}) bool {
+
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+
+		return false
+	}
+	return a == b
+}
+
+//pools get pools those are set in engine with their names, named pools are named kind:name.
+//A named pool that is also the default pool is listed once.
+func (engine *Engine) pools() ([]string, []Lifecycle) {
+
+	engine.namedMux.RLock()
+	defer engine.namedMux.RUnlock()
+
+	names := []string{}
+	pools := []Lifecycle{}
+
+	if engine.memPool != nil {
+		names = append(names, "mem")
+		pools = append(pools, engine.memPool)
+	}
+	if engine.documentPool != nil {
+		names = append(names, "document")
+		pools = append(pools, engine.documentPool)
+	}
+	if engine.filePool != nil {
+		names = append(names, "file")
+		pools = append(pools, engine.filePool)
+	}
+	for _, name := range sortedPoolNames(engine.memPools) {
+		if pool := engine.memPools[name]; !samePool(pool, engine.memPool) {
+			names = append(names, "mem:"+name)
+			pools = append(pools, pool)
+		}
+	}
+	for _, name := range sortedPoolNames(engine.documentPools) {
+		if pool := engine.documentPools[name]; !samePool(pool, engine.documentPool) {
+			names = append(names, "document:"+name)
+			pools = append(pools, pool)
+		}
+	}
+	for _, name := range sortedPoolNames(engine.filePools) {
+		if pool := engine.filePools[name]; !samePool(pool, engine.filePool) {
+			names = append(names, "file:"+name)
+			pools = append(pools, pool)
+		}
+	}
+	return names, pools
+}
+
+//sortedPoolNames return sorted keys of a map of pools
+func sortedPoolNames(pools interface{}) []string {
+
+	names := []string{}
+	for _, key := range reflect.ValueOf(pools).MapKeys() {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Close close every pool, it return the first error after trying to close all pools
+func (engine *Engine) Close(ctx context.Context) error {
+
+	var firstErr error = nil
+
+	names, pools := engine.pools()
+
+	for i, pool := range pools {
+
+		if err := pool.Close(ctx); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close %s pool: %w", names[i], err)
+		}
+	}
+	return firstErr
+}
+
+//Ping ping every pool, it return the first error
+func (engine *Engine) Ping(ctx context.Context) error {
+
+	names, pools := engine.pools()
+
+	for i, pool := range pools {
+
+		if err := pool.Ping(ctx); err != nil {
+			return fmt.Errorf("ping %s pool: %w", names[i], err)
+		}
+	}
+	return nil
+}
+
+//Health ping every pool concurrently and report their health, it can be used for readiness probe
+func (engine *Engine) Health(ctx context.Context) Health {
+
+	names, pools := engine.pools()
+
+	health := Health{Healthy: true, Pools: make([]PoolHealth, len(pools))}
+
+	var wait sync.WaitGroup
+
+	for i, pool := range pools {
+
+		wait.Add(1)
+		go func(i int, pool Lifecycle) {
+			defer wait.Done()
+
+			begin := time.Now()
+			err := pool.Ping(ctx)
+
+			health.Pools[i] = PoolHealth{Pool: names[i], Healthy: err == nil, Latency: time.Since(begin)}
+			if err != nil {
+				health.Pools[i].Error = err.Error()
+			}
+		}(i, pool)
+	}
+	wait.Wait()
+
+	for _, pool := range health.Pools {
+		if !pool.Healthy {
+			health.Healthy = false
+		}
+	}
+	return health
+}
@@ -0,0 +1,69 @@
+package engine
+
+//Logger leveled structured logger, keyvals are pairs of key and value.
+//*slog.Logger satisfy it, see NewSlogLogger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+//LoggerSetter is implemented by pools those can log
+type LoggerSetter interface {
+	SetLogger(logger Logger)
+}
+
+//NopLogger discard every log, pools use it until a logger is set
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (NopLogger) Info(msg string, keyvals ...interface{})  {}
+func (NopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (NopLogger) Error(msg string, keyvals ...interface{}) {}
+
+//LoggerOrNop return logger or NopLogger if logger is nil
+func LoggerOrNop(logger Logger) Logger {
+
+	if logger == nil {
+
+		return NopLogger{}
+	}
+	return logger
+}
+
+//SetLogger set logger of engine and every pool of engine that implement LoggerSetter, wrapped pools included.
+//Pools those are set later by Init also get the logger.
+func (engine *Engine) SetLogger(logger Logger) {
+
+	engine.logger = logger
+
+	engine.propagateLogger()
+}
+
+//GetLogger get logger of engine, it is never nil
+func (engine *Engine) GetLogger() Logger {
+
+	return LoggerOrNop(engine.logger)
+}
+
+func (engine *Engine) propagateLogger() {
+
+	if engine.logger == nil {
+
+		return
+	}
+	_, pools := engine.pools()
+
+	for _, pool := range pools {
+
+		walkPool(pool, func(pool interface{}) bool {
+
+			if setter, ok := pool.(LoggerSetter); ok {
+
+				setter.SetLogger(engine.logger)
+			}
+			return true
+		})
+	}
+}
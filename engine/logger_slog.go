@@ -0,0 +1,49 @@
+//go:build go1.21
+// +build go1.21
+
+package engine
+
+import (
+	"context"
+	"log/slog"
+)
+
+//SlogLogger write logs to a slog.Logger
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+//NewSlogLogger make Logger from slog.Logger, slog.Default() is used if logger is nil
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+
+	if logger == nil {
+
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+func (logger *SlogLogger) log(level slog.Level, msg string, keyvals ...interface{}) {
+
+	logger.logger.Log(context.Background(), level, msg, keyvals...)
+}
+
+func (logger *SlogLogger) Debug(msg string, keyvals ...interface{}) {
+
+	logger.log(slog.LevelDebug, msg, keyvals...)
+}
+
+func (logger *SlogLogger) Info(msg string, keyvals ...interface{}) {
+
+	logger.log(slog.LevelInfo, msg, keyvals...)
+}
+
+func (logger *SlogLogger) Warn(msg string, keyvals ...interface{}) {
+
+	logger.log(slog.LevelWarn, msg, keyvals...)
+}
+
+func (logger *SlogLogger) Error(msg string, keyvals ...interface{}) {
+
+	logger.log(slog.LevelError, msg, keyvals...)
+}
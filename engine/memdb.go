@@ -4,6 +4,7 @@ import "time"
 
 //MemPool memory pool
 type MemPool interface {
+	Lifecycle
 
 	//Init init pool from connection string
 	Init(connectionString string) error
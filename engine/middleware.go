@@ -0,0 +1,178 @@
+package engine
+
+import "context"
+
+//DocumentPoolMiddleware wrap a document pool to intercept its operations.
+//A middleware usually return a struct that embed *DocumentPoolWrapper and override the methods it intercept.
+type DocumentPoolMiddleware func(next DocumentPool) DocumentPool
+
+//MemPoolMiddleware wrap a mem pool to intercept its operations, see DocumentPoolMiddleware
+type MemPoolMiddleware func(next MemPool) MemPool
+
+//FilePoolMiddleware wrap a file pool to intercept its operations, see DocumentPoolMiddleware
+type FilePoolMiddleware func(next FilePool) FilePool
+
+//Chain wrap pool with middlewares, the first middleware is the outermost one so it see an operation first
+func Chain(pool DocumentPool, middlewares ...DocumentPoolMiddleware) DocumentPool {
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+
+		pool = middlewares[i](pool)
+	}
+	return pool
+}
+
+//ChainMemPool wrap pool with middlewares, the first middleware is the outermost one
+func ChainMemPool(pool MemPool, middlewares ...MemPoolMiddleware) MemPool {
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+
+		pool = middlewares[i](pool)
+	}
+	return pool
+}
+
+//ChainFilePool wrap pool with middlewares, the first middleware is the outermost one
+func ChainFilePool(pool FilePool, middlewares ...FilePoolMiddleware) FilePool {
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+
+		pool = middlewares[i](pool)
+	}
+	return pool
+}
+
+//DocumentPoolWrapper delegate every method to the wrapped pool. Transactions made by MakeTransaction
+//or given to fn of RunInTransaction are wrapped by WrapTransaction, query results of Query and of
+//transactions are wrapped by WrapQueryResult, when they are set.
+type DocumentPoolWrapper struct {
+	DocumentPool
+	WrapTransaction func(tx DBTransaction) DBTransaction
+	WrapQueryResult func(result DBQueryResult) DBQueryResult
+}
+
+//NewDocumentPoolWrapper make wrapper of next
+func NewDocumentPoolWrapper(next DocumentPool) *DocumentPoolWrapper {
+
+	return &DocumentPoolWrapper{DocumentPool: next}
+}
+
+//Unwrap return the wrapped pool
+func (wrapper *DocumentPoolWrapper) Unwrap() DocumentPool {
+
+	return wrapper.DocumentPool
+}
+
+func (wrapper *DocumentPoolWrapper) wrapTransaction(tx DBTransaction) DBTransaction {
+
+	if wrapper.WrapQueryResult != nil {
+
+		tx = &queryResultTransaction{DBTransaction: tx, wrap: wrapper.WrapQueryResult}
+	}
+	if wrapper.WrapTransaction != nil {
+
+		tx = wrapper.WrapTransaction(tx)
+	}
+	return tx
+}
+
+//MakeTransaction make transaction of the wrapped pool then wrap it
+func (wrapper *DocumentPoolWrapper) MakeTransaction(options ...*TransactionOptions) DBTransaction {
+
+	return wrapper.wrapTransaction(wrapper.DocumentPool.MakeTransaction(options...))
+}
+
+//RunInTransaction run fn with the wrapped transaction of the wrapped pool
+func (wrapper *DocumentPoolWrapper) RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error {
+
+	return wrapper.DocumentPool.RunInTransaction(ctx, func(tx DBTransaction) error {
+
+		return fn(wrapper.wrapTransaction(tx))
+	}, options...)
+}
+
+//Query query the wrapped pool then wrap the result
+func (wrapper *DocumentPoolWrapper) Query(query DBQuery) DBQueryResult {
+
+	result := wrapper.DocumentPool.Query(query)
+
+	if wrapper.WrapQueryResult != nil {
+
+		result = wrapper.WrapQueryResult(result)
+	}
+	return result
+}
+
+//queryResultTransaction wrap query results of a transaction
+type queryResultTransaction struct {
+	DBTransaction
+	wrap func(result DBQueryResult) DBQueryResult
+}
+
+func (tx *queryResultTransaction) Query(query DBQuery) DBQueryResult {
+
+	return tx.wrap(tx.DBTransaction.Query(query))
+}
+
+//DocumentPoolRewrapper is implemented by middlewares those can wrap another pool the same way,
+//ForTenant use it to put the middlewares of the engine's pool around the pool of the tenant's database
+type DocumentPoolRewrapper interface {
+	Rewrap(next DocumentPool) DocumentPool
+}
+
+//MemPoolWrapper delegate every method to the wrapped pool
+type MemPoolWrapper struct {
+	MemPool
+}
+
+//NewMemPoolWrapper make wrapper of next
+func NewMemPoolWrapper(next MemPool) *MemPoolWrapper {
+
+	return &MemPoolWrapper{MemPool: next}
+}
+
+//Unwrap return the wrapped pool
+func (wrapper *MemPoolWrapper) Unwrap() MemPool {
+
+	return wrapper.MemPool
+}
+
+//FilePoolWrapper delegate every method to the wrapped pool
+type FilePoolWrapper struct {
+	FilePool
+}
+
+//NewFilePoolWrapper make wrapper of next
+func NewFilePoolWrapper(next FilePool) *FilePoolWrapper {
+
+	return &FilePoolWrapper{FilePool: next}
+}
+
+//Unwrap return the wrapped pool
+func (wrapper *FilePoolWrapper) Unwrap() FilePool {
+
+	return wrapper.FilePool
+}
+
+//unwrapPool return the pool wrapped by pool or nil if pool is not a wrapper
+func unwrapPool(pool interface{}) interface{} {
+
+	switch wrapper := pool.(type) {
+	case interface{ Unwrap() DocumentPool }:
+		return wrapper.Unwrap()
+	case interface{ Unwrap() MemPool }:
+		return wrapper.Unwrap()
+	case interface{ Unwrap() FilePool }:
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+//walkPool call visit for pool and every pool wrapped by it from the outermost one, until visit return false
+func walkPool(pool interface{}, visit func(pool interface{}) bool) {
+
+	for pool != nil && visit(pool) {
+
+		pool = unwrapPool(pool)
+	}
+}
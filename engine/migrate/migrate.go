@@ -0,0 +1,314 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tapvanvn/godbengine/engine"
+)
+
+var ErrLocked = errors.New("migration is locked by another instance")
+var ErrDuplicateID = errors.New("duplicate migration id")
+var ErrUnknownMigration = errors.New("unknown migration")
+var ErrNoDown = errors.New("migration has no down function")
+
+//DefaultCollection collection that store applied migrations and the lock document
+const DefaultCollection = "_migrations"
+
+const lockID = "__lock"
+
+//Migration a versioned change of documents
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(pool engine.DocumentPool) error
+	Down        func(pool engine.DocumentPool) error
+}
+
+//Status state of a registered migration
+type Status struct {
+	ID          string
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+type migrationRecord struct {
+	ID          string `json:"ID" bson:"ID" firestore:"ID"`
+	Description string `json:"Description" bson:"Description" firestore:"Description"`
+	AppliedAt   int64  `json:"AppliedAt" bson:"AppliedAt" firestore:"AppliedAt"`
+}
+
+//Migrator apply registered migrations on a document pool.
+//Migrations are applied in the order of their ID. A lock document that is written by RunInTransaction
+//make sure only one migrator apply migrations at a time, so mongodb needs a replica set.
+type Migrator struct {
+	pool       engine.DocumentPool
+	collection string
+	migrations []Migration
+	owner      string
+	//LockTTL lock is considered released if the owner does not renew it in this duration
+	LockTTL time.Duration
+	//DryRun Up and Down only report what would be done
+	DryRun bool
+}
+
+//NewMigrator create migrator that store state in DefaultCollection of pool
+func NewMigrator(pool engine.DocumentPool) *Migrator {
+
+	hostname, _ := os.Hostname()
+
+	return &Migrator{
+		pool:       pool,
+		collection: DefaultCollection,
+		migrations: []Migration{},
+		owner:      fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.New().String()),
+		LockTTL:    time.Minute * 5,
+	}
+}
+
+//SetCollection change the collection that store migration state
+func (migrator *Migrator) SetCollection(collection string) {
+
+	migrator.collection = collection
+}
+
+//Register register a migration
+func (migrator *Migrator) Register(migration Migration) error {
+
+	if migration.ID == "" || migration.ID == lockID {
+
+		return fmt.Errorf("%w: invalid id %s", ErrUnknownMigration, migration.ID)
+	}
+	for _, registered := range migrator.migrations {
+
+		if registered.ID == migration.ID {
+
+			return fmt.Errorf("%w: %s", ErrDuplicateID, migration.ID)
+		}
+	}
+	migrator.migrations = append(migrator.migrations, migration)
+
+	sort.SliceStable(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].ID < migrator.migrations[j].ID
+	})
+	return nil
+}
+
+//Status report state of all registered migrations
+func (migrator *Migrator) Status() ([]Status, error) {
+
+	statuses := []Status{}
+
+	for _, migration := range migrator.migrations {
+
+		status := Status{ID: migration.ID, Description: migration.Description}
+
+		record := migrationRecord{}
+		err := migrator.pool.Get(migrator.collection, migration.ID, &record)
+		if err == nil {
+			status.Applied = true
+			status.AppliedAt = time.Unix(0, record.AppliedAt*int64(time.Millisecond))
+		} else if !migrator.pool.IsNoRecordError(err) {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+//Up apply all pending migrations, return ids of applied migrations
+func (migrator *Migrator) Up() ([]string, error) {
+
+	return migrator.UpTo("")
+}
+
+//UpTo apply pending migrations until the migration with id is applied, empty id mean all
+func (migrator *Migrator) UpTo(id string) ([]string, error) {
+
+	if id != "" && !migrator.isRegistered(id) {
+
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMigration, id)
+	}
+	return migrator.run(func(statuses []Status) ([]string, error) {
+
+		applied := []string{}
+
+		for i, status := range statuses {
+
+			if !status.Applied {
+
+				if err := migrator.apply(migrator.migrations[i]); err != nil {
+					return applied, fmt.Errorf("migration %s up: %w", status.ID, err)
+				}
+				applied = append(applied, status.ID)
+			}
+			if status.ID == id {
+				break
+			}
+		}
+		return applied, nil
+	})
+}
+
+//Down revert the last steps applied migrations, return ids of reverted migrations
+func (migrator *Migrator) Down(steps int) ([]string, error) {
+
+	return migrator.run(func(statuses []Status) ([]string, error) {
+
+		reverted := []string{}
+
+		for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+
+			if !statuses[i].Applied {
+				continue
+			}
+			if err := migrator.revert(migrator.migrations[i]); err != nil {
+				return reverted, fmt.Errorf("migration %s down: %w", statuses[i].ID, err)
+			}
+			reverted = append(reverted, statuses[i].ID)
+		}
+		return reverted, nil
+	})
+}
+
+func (migrator *Migrator) isRegistered(id string) bool {
+
+	for _, migration := range migrator.migrations {
+		if migration.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//run hold the lock while process run, lock is not taken in dry run
+func (migrator *Migrator) run(process func(statuses []Status) ([]string, error)) ([]string, error) {
+
+	if !migrator.DryRun {
+
+		if err := migrator.lock(); err != nil {
+			return nil, err
+		}
+		defer migrator.unlock()
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		return nil, err
+	}
+	return process(statuses)
+}
+
+func (migrator *Migrator) apply(migration Migration) error {
+
+	if migrator.DryRun {
+		return nil
+	}
+	if err := migrator.renew(); err != nil {
+		return err
+	}
+	if migration.Up != nil {
+		if err := migration.Up(migrator.pool); err != nil {
+			return err
+		}
+	}
+	record := migrationRecord{
+		ID:          migration.ID,
+		Description: migration.Description,
+		AppliedAt:   toMillisecond(time.Now()),
+	}
+	return migrator.pool.PutRaw(migrator.collection, migration.ID, record)
+}
+
+func (migrator *Migrator) revert(migration Migration) error {
+
+	if migration.Down == nil {
+		return ErrNoDown
+	}
+	if migrator.DryRun {
+		return nil
+	}
+	if err := migrator.renew(); err != nil {
+		return err
+	}
+	if err := migration.Down(migrator.pool); err != nil {
+		return err
+	}
+	return migrator.pool.Del(migrator.collection, migration.ID)
+}
+
+//MARK: Lock
+
+//toMillisecond time stored in millisecond so it is exact in adapters those store number as float64
+func toMillisecond(t time.Time) int64 {
+
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+type lockRecord struct {
+	Name        string `json:"Name" bson:"Name" firestore:"Name"`
+	Owner       string `json:"Owner" bson:"Owner" firestore:"Owner"`
+	LockedUntil int64  `json:"LockedUntil" bson:"LockedUntil" firestore:"LockedUntil"`
+}
+
+//takeLock write the lock of migrator inside a transaction, so the lock is read and written atomically.
+//The lock is taken when it is free, expired or already owned, renew only extend a lock that is still owned.
+func (migrator *Migrator) takeLock(renew bool) error {
+
+	return migrator.pool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		record := lockRecord{}
+		if err := tx.Get(migrator.collection, lockID, &record); err != nil && !migrator.pool.IsNoRecordError(err) {
+			return err
+		}
+		now := time.Now()
+		isOwner := record.Owner == migrator.owner && record.LockedUntil >= toMillisecond(now)
+		isFree := record.Owner == "" || record.LockedUntil < toMillisecond(now)
+
+		if !isOwner && (renew || !isFree) {
+			return ErrLocked
+		}
+		tx.PutRaw(migrator.collection, lockID, &lockRecord{
+			Name:        lockID,
+			Owner:       migrator.owner,
+			LockedUntil: toMillisecond(now.Add(migrator.LockTTL)),
+		})
+		return nil
+	})
+}
+
+//lock take the lock document, see takeLock
+func (migrator *Migrator) lock() error {
+
+	return migrator.takeLock(false)
+}
+
+//renew extend the lock before each migration
+func (migrator *Migrator) renew() error {
+
+	return migrator.takeLock(true)
+}
+
+func (migrator *Migrator) unlock() error {
+
+	return migrator.pool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		record := lockRecord{}
+		if err := tx.Get(migrator.collection, lockID, &record); err != nil {
+			if migrator.pool.IsNoRecordError(err) {
+				return nil
+			}
+			return err
+		}
+		if record.Owner != migrator.owner {
+			return nil
+		}
+		tx.PutRaw(migrator.collection, lockID, &lockRecord{Name: lockID})
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+//Operation describe a call to a pool, it is given to Observer before and after the call.
+//Duration, Err and ResultSize are only set when After is called.
+type Operation struct {
+	Pool       string //adapter of the pool: mongo, firestore, redis, rediscluster, file_docdb, local_docdb, local_memdb, file, mongo_file
+	Name       string //get, put, update, del, query, updatewhere, deletewhere, transcommit, set, incr, decr, findkey, read, write...
+	Collection string //collection of document pools, empty for mem pools and file pools
+	ID         string //document id, key of mem pools or path of file pools
+	Start      time.Time
+	Duration   time.Duration
+	Err        error
+	ResultSize int //number of documents or bytes returned
+}
+
+//Observer is called before and after every operation of pools, it is used for metrics and tracing.
+//Before return the context that is used by the operation and given to After.
+type Observer interface {
+	Before(ctx context.Context, op *Operation) context.Context
+	After(ctx context.Context, op *Operation)
+}
+
+//ObserverSetter is implemented by pools those can be observed
+type ObserverSetter interface {
+	SetObserver(observer Observer)
+}
+
+//Observers call many observers, After is called in reverse order
+type Observers []Observer
+
+func (observers Observers) Before(ctx context.Context, op *Operation) context.Context {
+
+	for _, observer := range observers {
+
+		ctx = observer.Before(ctx, op)
+	}
+	return ctx
+}
+
+func (observers Observers) After(ctx context.Context, op *Operation) {
+
+	for i := len(observers) - 1; i >= 0; i-- {
+
+		observers[i].After(ctx, op)
+	}
+}
+
+//SetObserver set observer of every pool of engine that implement ObserverSetter, wrapped pools included.
+//Pools those are set later by Init also get the observer.
+func (engine *Engine) SetObserver(observer Observer) {
+
+	engine.observer = observer
+
+	engine.propagateObserver()
+}
+
+func (engine *Engine) propagateObserver() {
+
+	if engine.observer == nil {
+
+		return
+	}
+	_, pools := engine.pools()
+
+	for _, pool := range pools {
+
+		walkPool(pool, func(pool interface{}) bool {
+
+			if setter, ok := pool.(ObserverSetter); ok {
+
+				setter.SetObserver(engine.observer)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package otelobserver
+
+import (
+	"context"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/tapvanvn/godbengine"
+
+//Observer trace every pool operation with an OpenTelemetry span named pool.operation
+type Observer struct {
+	tracer trace.Tracer
+}
+
+//New make observer that start spans with tracer, the tracer of global provider is used if tracer is nil
+func New(tracer trace.Tracer) *Observer {
+
+	if tracer == nil {
+
+		tracer = otel.Tracer(instrumentationName)
+	}
+	return &Observer{tracer: tracer}
+}
+
+//Before start the span of operation
+func (observer *Observer) Before(ctx context.Context, op *engine.Operation) context.Context {
+
+	attributes := []attribute.KeyValue{
+		attribute.String("db.system", op.Pool),
+		attribute.String("db.operation", op.Name),
+	}
+	if op.Collection != "" {
+
+		attributes = append(attributes, attribute.String("db.collection", op.Collection))
+	}
+	ctx, _ = observer.tracer.Start(ctx, op.Pool+"."+op.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(op.Start),
+		trace.WithAttributes(attributes...))
+
+	return ctx
+}
+
+//After end the span of operation and record its error
+func (observer *Observer) After(ctx context.Context, op *engine.Operation) {
+
+	span := trace.SpanFromContext(ctx)
+
+	span.SetAttributes(attribute.Int("db.result_size", op.ResultSize))
+
+	if op.Err != nil {
+
+		span.RecordError(op.Err)
+		span.SetStatus(codes.Error, op.Err.Error())
+	}
+	span.End(trace.WithTimestamp(op.Start.Add(op.Duration)))
+}
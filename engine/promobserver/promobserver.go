@@ -0,0 +1,67 @@
+package promobserver
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tapvanvn/godbengine/engine"
+)
+
+//Observer record duration and result size of every pool operation to Prometheus histograms.
+//Both histograms are labeled by pool, operation and collection, duration is also labeled by status (ok or error).
+type Observer struct {
+	duration   *prometheus.HistogramVec
+	resultSize *prometheus.HistogramVec
+}
+
+//New make observer and register its histograms to registerer, prometheus.DefaultRegisterer is used if registerer is nil
+func New(registerer prometheus.Registerer) (*Observer, error) {
+
+	if registerer == nil {
+
+		registerer = prometheus.DefaultRegisterer
+	}
+	observer := &Observer{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "godbengine",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of pool operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"pool", "operation", "collection", "status"}),
+		resultSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "godbengine",
+			Name:      "operation_result_size",
+			Help:      "Number of documents or bytes returned by pool operations.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"pool", "operation", "collection"}),
+	}
+	if err := registerer.Register(observer.duration); err != nil {
+
+		return nil, err
+	}
+	if err := registerer.Register(observer.resultSize); err != nil {
+
+		registerer.Unregister(observer.duration)
+		return nil, err
+	}
+	return observer, nil
+}
+
+//Before nothing to do before operation
+func (observer *Observer) Before(ctx context.Context, op *engine.Operation) context.Context {
+
+	return ctx
+}
+
+//After observe duration and result size of operation
+func (observer *Observer) After(ctx context.Context, op *engine.Operation) {
+
+	status := "ok"
+	if op.Err != nil {
+
+		status = "error"
+	}
+	observer.duration.WithLabelValues(op.Pool, op.Name, op.Collection, status).Observe(op.Duration.Seconds())
+
+	observer.resultSize.WithLabelValues(op.Pool, op.Name, op.Collection).Observe(float64(op.ResultSize))
+}
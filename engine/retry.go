@@ -0,0 +1,560 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//TransientErrorClassifier is implemented by pools those can tell if an error is transient,
+//like a network error or a primary election, so the operation may succeed if it is tried again.
+type TransientErrorClassifier interface {
+	IsTransientError(err error) bool
+}
+
+//RetryPolicy retry operations those fail with a transient error using exponential backoff with jitter.
+//Operations those are not idempotent are not retried unless RetryNonIdempotent is set.
+type RetryPolicy struct {
+	//MaxAttempts number of attempts including the first one, 0 means no limit when MaxElapsed is set
+	MaxAttempts int
+	//MaxElapsed no attempt is started after this duration since the first one, 0 means no limit
+	MaxElapsed     time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	//Jitter fraction of backoff that is randomized, from 0 to 1
+	Jitter float64
+	//RetryNonIdempotent also retry operations like IncrInt or Update with inc, they may be applied twice
+	RetryNonIdempotent bool
+	//IsTransient classify errors instead of the TransientErrorClassifier of the pool
+	IsTransient func(err error) bool
+}
+
+//DefaultRetryPolicy try 4 times with backoff from 50ms to 2s
+func DefaultRetryPolicy() RetryPolicy {
+
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+//Backoff return the delay before the retry-th retry, retry start from 1
+func (policy RetryPolicy) Backoff(retry int) time.Duration {
+
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+
+		multiplier = 1
+	}
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+
+		backoff = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+
+		jitter := math.Min(policy.Jitter, 1)
+		backoff -= backoff * jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+//Do call fn until it succeed or fail with an error that is not transient, or the policy is exhausted.
+//The last error is returned. Canceling ctx stop waiting for the next attempt.
+func (policy RetryPolicy) Do(ctx context.Context, idempotent bool, isTransient func(err error) bool, fn func() error) error {
+
+	if policy.IsTransient != nil {
+
+		isTransient = policy.IsTransient
+	}
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+
+		err := fn()
+
+		if err == nil || isTransient == nil || !isTransient(err) || (!idempotent && !policy.RetryNonIdempotent) {
+
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+
+			return err
+		}
+		if policy.MaxAttempts <= 0 && policy.MaxElapsed <= 0 {
+
+			return err
+		}
+		backoff := policy.Backoff(attempt)
+
+		if policy.MaxElapsed > 0 && time.Since(start)+backoff > policy.MaxElapsed {
+
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+//findTransientClassifier return the IsTransientError of pool or of a pool wrapped by it
+func findTransientClassifier(pool interface{}) func(err error) bool {
+
+	var isTransient func(err error) bool = nil
+
+	walkPool(pool, func(pool interface{}) bool {
+
+		if classifier, ok := pool.(TransientErrorClassifier); ok {
+
+			isTransient = classifier.IsTransientError
+			return false
+		}
+		return true
+	})
+	return isTransient
+}
+
+//isIdempotentItems check if committing items again give the same result
+func isIdempotentItems(items []TransactionItem) bool {
+
+	for _, item := range items {
+
+		switch item.Command {
+		case TransactionPut, TransactionDel:
+		case TransactionUpdate:
+			update, ok := item.Document.(Update)
+			if !ok || !update.IsIdempotent() {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//RetryDocumentPool retry operations of pool with policy. Errors are classified by the pool
+//(see TransientErrorClassifier) unless policy.IsTransient is set.
+//Commit of transactions is retried only if all its items are idempotent, RunInTransaction and Watch are not retried.
+func RetryDocumentPool(policy RetryPolicy) DocumentPoolMiddleware {
+
+	return func(next DocumentPool) DocumentPool {
+
+		return newRetryDocumentPool(next, policy)
+	}
+}
+
+func newRetryDocumentPool(next DocumentPool, policy RetryPolicy) *retryDocumentPool {
+
+	pool := &retryDocumentPool{
+		DocumentPoolWrapper: NewDocumentPoolWrapper(next),
+		policy:              policy,
+		isTransient:         findTransientClassifier(next),
+	}
+	pool.WrapTransaction = func(tx DBTransaction) DBTransaction {
+
+		return &retryTransaction{DBTransaction: tx, pool: pool}
+	}
+	return pool
+}
+
+type retryDocumentPool struct {
+	*DocumentPoolWrapper
+	policy      RetryPolicy
+	isTransient func(err error) bool
+}
+
+//Rewrap wrap next with the same policy
+func (pool *retryDocumentPool) Rewrap(next DocumentPool) DocumentPool {
+
+	return newRetryDocumentPool(next, pool.policy)
+}
+
+func (pool *retryDocumentPool) do(idempotent bool, fn func() error) error {
+
+	return pool.policy.Do(context.Background(), idempotent, pool.isTransient, fn)
+}
+
+func (pool *retryDocumentPool) Put(collection string, document Document) error {
+
+	return pool.do(true, func() error {
+		return pool.DocumentPool.Put(collection, document)
+	})
+}
+
+func (pool *retryDocumentPool) Get(collection string, id string, document interface{}) error {
+
+	return pool.do(true, func() error {
+		return pool.DocumentPool.Get(collection, id, document)
+	})
+}
+
+func (pool *retryDocumentPool) PutRaw(collection string, id string, document interface{}) error {
+
+	return pool.do(true, func() error {
+		return pool.DocumentPool.PutRaw(collection, id, document)
+	})
+}
+
+func (pool *retryDocumentPool) Del(collection string, id string) error {
+
+	return pool.do(true, func() error {
+		return pool.DocumentPool.Del(collection, id)
+	})
+}
+
+func (pool *retryDocumentPool) Update(collection string, id string, update Update) error {
+
+	return pool.do(update.IsIdempotent(), func() error {
+		return pool.DocumentPool.Update(collection, id, update)
+	})
+}
+
+func (pool *retryDocumentPool) Query(query DBQuery) DBQueryResult {
+
+	var result DBQueryResult = nil
+
+	pool.do(true, func() error {
+		//result of a failed attempt hold a cursor on some backends
+		if result != nil {
+			result.Close()
+		}
+		result = pool.DocumentPoolWrapper.Query(query)
+		return result.Error()
+	})
+	return result
+}
+
+func (pool *retryDocumentPool) UpdateWhere(query DBQuery, update Update) (int64, error) {
+
+	count := int64(0)
+	err := pool.do(update.IsIdempotent(), func() error {
+		var err error = nil
+		count, err = pool.DocumentPool.UpdateWhere(query, update)
+		return err
+	})
+	return count, err
+}
+
+func (pool *retryDocumentPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	count := int64(0)
+	err := pool.do(true, func() error {
+		var err error = nil
+		count, err = pool.DocumentPool.DeleteWhere(query)
+		return err
+	})
+	return count, err
+}
+
+func (pool *retryDocumentPool) EnsureIndex(collection string, index IndexSpec) error {
+
+	return pool.do(true, func() error {
+		return pool.DocumentPool.EnsureIndex(collection, index)
+	})
+}
+
+func (pool *retryDocumentPool) ListIndexes(collection string) ([]IndexSpec, error) {
+
+	var indexes []IndexSpec = nil
+	err := pool.do(true, func() error {
+		var err error = nil
+		indexes, err = pool.DocumentPool.ListIndexes(collection)
+		return err
+	})
+	return indexes, err
+}
+
+func (pool *retryDocumentPool) collectVary(collect func() (map[string]int, error)) (map[string]int, error) {
+
+	var vary map[string]int = nil
+	err := pool.do(true, func() error {
+		var err error = nil
+		vary, err = collect()
+		return err
+	})
+	return vary, err
+}
+
+func (pool *retryDocumentPool) CollectVaryInt(collection string, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryInt(collection, field)
+	})
+}
+
+func (pool *retryDocumentPool) CollectVaryString(collection string, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryString(collection, field)
+	})
+}
+
+func (pool *retryDocumentPool) CollectVaryQueryInt(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryQueryInt(query, field)
+	})
+}
+
+func (pool *retryDocumentPool) CollectVaryQueryString(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.collectVary(func() (map[string]int, error) {
+		return pool.DocumentPool.CollectVaryQueryString(query, field)
+	})
+}
+
+//retryTransaction retry reads of transaction and its commit when all items are idempotent
+type retryTransaction struct {
+	DBTransaction
+	pool *retryDocumentPool
+}
+
+func (tx *retryTransaction) Get(collection string, id string, document interface{}) error {
+
+	return tx.pool.do(true, func() error {
+		return tx.DBTransaction.Get(collection, id, document)
+	})
+}
+
+func (tx *retryTransaction) Query(query DBQuery) DBQueryResult {
+
+	var result DBQueryResult = nil
+
+	tx.pool.do(true, func() error {
+		//result of a failed attempt hold a cursor on some backends
+		if result != nil {
+			result.Close()
+		}
+		result = tx.DBTransaction.Query(query)
+		return result.Error()
+	})
+	return result
+}
+
+func (tx *retryTransaction) Commit() error {
+
+	return tx.pool.do(isIdempotentItems(tx.Items()), tx.DBTransaction.Commit)
+}
+
+//RetryMemPool retry operations of pool with policy, incr and decr are not idempotent
+func RetryMemPool(policy RetryPolicy) MemPoolMiddleware {
+
+	return func(next MemPool) MemPool {
+
+		return &retryMemPool{
+			MemPoolWrapper: NewMemPoolWrapper(next),
+			policy:         policy,
+			isTransient:    findTransientClassifier(next),
+		}
+	}
+}
+
+type retryMemPool struct {
+	*MemPoolWrapper
+	policy      RetryPolicy
+	isTransient func(err error) bool
+}
+
+func (pool *retryMemPool) do(idempotent bool, fn func() error) error {
+
+	return pool.policy.Do(context.Background(), idempotent, pool.isTransient, fn)
+}
+
+func (pool *retryMemPool) doInt(idempotent bool, fn func() (int64, error)) (int64, error) {
+
+	result := int64(0)
+	err := pool.do(idempotent, func() error {
+		var err error = nil
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
+
+func (pool *retryMemPool) doString(fn func() (string, error)) (string, error) {
+
+	result := ""
+	err := pool.do(true, func() error {
+		var err error = nil
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
+
+func (pool *retryMemPool) Set(key string, value string) error {
+
+	return pool.do(true, func() error { return pool.MemPool.Set(key, value) })
+}
+
+func (pool *retryMemPool) SetInt(key string, value int64) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetInt(key, value) })
+}
+
+func (pool *retryMemPool) IncrInt(key string) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.IncrInt(key) })
+}
+
+func (pool *retryMemPool) DecrInt(key string) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.DecrInt(key) })
+}
+
+func (pool *retryMemPool) IncrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.IncrIntBy(key, num) })
+}
+
+func (pool *retryMemPool) DecrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.DecrIntBy(key, num) })
+}
+
+func (pool *retryMemPool) SetShading(key string, value string) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetShading(key, value) })
+}
+
+func (pool *retryMemPool) SetIntShading(key string, value int64) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetIntShading(key, value) })
+}
+
+func (pool *retryMemPool) IncrIntShading(key string) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.IncrIntShading(key) })
+}
+
+func (pool *retryMemPool) DescIntShading(key string) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.DescIntShading(key) })
+}
+
+func (pool *retryMemPool) IncrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.IncrIntByShading(key, num) })
+}
+
+func (pool *retryMemPool) DecrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(false, func() (int64, error) { return pool.MemPool.DecrIntByShading(key, num) })
+}
+
+func (pool *retryMemPool) SetExpire(key string, value string, d time.Duration) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetExpire(key, value, d) })
+}
+
+func (pool *retryMemPool) SetIntExpire(key string, value int64, d time.Duration) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetIntExpire(key, value, d) })
+}
+
+func (pool *retryMemPool) SetExpireShading(key string, value string, d time.Duration) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetExpireShading(key, value, d) })
+}
+
+func (pool *retryMemPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
+
+	return pool.do(true, func() error { return pool.MemPool.SetIntExpireShading(key, value, d) })
+}
+
+func (pool *retryMemPool) Get(key string) (string, error) {
+
+	return pool.doString(func() (string, error) { return pool.MemPool.Get(key) })
+}
+
+func (pool *retryMemPool) GetInt(key string) (int64, error) {
+
+	return pool.doInt(true, func() (int64, error) { return pool.MemPool.GetInt(key) })
+}
+
+func (pool *retryMemPool) GetShading(key string) (string, error) {
+
+	return pool.doString(func() (string, error) { return pool.MemPool.GetShading(key) })
+}
+
+func (pool *retryMemPool) GetIntShading(key string) (int64, error) {
+
+	return pool.doInt(true, func() (int64, error) { return pool.MemPool.GetIntShading(key) })
+}
+
+func (pool *retryMemPool) Del(key string) error {
+
+	return pool.do(true, func() error { return pool.MemPool.Del(key) })
+}
+
+func (pool *retryMemPool) DelShading(key string) error {
+
+	return pool.do(true, func() error { return pool.MemPool.DelShading(key) })
+}
+
+func (pool *retryMemPool) FindKey(keyPattern string) ([]string, error) {
+
+	var keys []string = nil
+	err := pool.do(true, func() error {
+		var err error = nil
+		keys, err = pool.MemPool.FindKey(keyPattern)
+		return err
+	})
+	return keys, err
+}
+
+//RetryFilePool retry operations of pool with policy
+func RetryFilePool(policy RetryPolicy) FilePoolMiddleware {
+
+	return func(next FilePool) FilePool {
+
+		return &retryFilePool{
+			FilePoolWrapper: NewFilePoolWrapper(next),
+			policy:          policy,
+			isTransient:     findTransientClassifier(next),
+		}
+	}
+}
+
+type retryFilePool struct {
+	*FilePoolWrapper
+	policy      RetryPolicy
+	isTransient func(err error) bool
+}
+
+func (pool *retryFilePool) do(fn func() error) error {
+
+	return pool.policy.Do(context.Background(), true, pool.isTransient, fn)
+}
+
+func (pool *retryFilePool) Read(path string) (*[]byte, error) {
+
+	var content *[]byte = nil
+	err := pool.do(func() error {
+		var err error = nil
+		content, err = pool.FilePool.Read(path)
+		return err
+	})
+	return content, err
+}
+
+func (pool *retryFilePool) Write(path string, content *[]byte) error {
+
+	return pool.do(func() error { return pool.FilePool.Write(path, content) })
+}
+
+func (pool *retryFilePool) Delete(path string) error {
+
+	return pool.do(func() error { return pool.FilePool.Delete(path) })
+}
@@ -0,0 +1,833 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var NoPool = errors.New("no pool is registered for the route")
+var CrossPoolTransaction = errors.New("transaction can not span pools")
+
+//routes map prefixes to pool names, the longest prefix win
+type routes struct {
+	prefixes []string
+	names    map[string]string
+}
+
+func (routes *routes) add(prefix string, name string) {
+
+	if routes.names == nil {
+
+		routes.names = map[string]string{}
+	}
+	if _, ok := routes.names[prefix]; !ok {
+
+		routes.prefixes = append(routes.prefixes, prefix)
+		sort.SliceStable(routes.prefixes, func(i, j int) bool {
+			return len(routes.prefixes[i]) > len(routes.prefixes[j])
+		})
+	}
+	routes.names[prefix] = name
+}
+
+func (routes *routes) match(key string) string {
+
+	for _, prefix := range routes.prefixes {
+
+		if strings.HasPrefix(key, prefix) {
+
+			return routes.names[prefix]
+		}
+	}
+	return DefaultPoolName
+}
+
+//RouteDocument route collections those start with prefix to the document pool registered with name
+func (engine *Engine) RouteDocument(prefix string, name string) {
+
+	engine.namedMux.Lock()
+	defer engine.namedMux.Unlock()
+
+	engine.documentRoutes.add(prefix, name)
+}
+
+//RouteMem route keys those start with prefix to the mem pool registered with name
+func (engine *Engine) RouteMem(prefix string, name string) {
+
+	engine.namedMux.Lock()
+	defer engine.namedMux.Unlock()
+
+	engine.memRoutes.add(prefix, name)
+}
+
+//RouteFile route paths those start with prefix to the file pool registered with name
+func (engine *Engine) RouteFile(prefix string, name string) {
+
+	engine.namedMux.Lock()
+	defer engine.namedMux.Unlock()
+
+	engine.fileRoutes.add(prefix, name)
+}
+
+//DocumentPoolFor get document pool that collection is routed to, the default pool if no route match.
+//It is nil if the pool of the route is not registered.
+func (engine *Engine) DocumentPoolFor(collection string) DocumentPool {
+
+	engine.namedMux.RLock()
+	name := engine.documentRoutes.match(collection)
+	engine.namedMux.RUnlock()
+
+	return engine.GetDocumentPoolNamed(name)
+}
+
+//MemPoolFor get mem pool that key is routed to, see DocumentPoolFor
+func (engine *Engine) MemPoolFor(key string) MemPool {
+
+	engine.namedMux.RLock()
+	name := engine.memRoutes.match(key)
+	engine.namedMux.RUnlock()
+
+	return engine.GetMemPoolNamed(name)
+}
+
+//FilePoolFor get file pool that path is routed to, see DocumentPoolFor
+func (engine *Engine) FilePoolFor(path string) FilePool {
+
+	engine.namedMux.RLock()
+	name := engine.fileRoutes.match(path)
+	engine.namedMux.RUnlock()
+
+	return engine.GetFilePoolNamed(name)
+}
+
+//GetRoutedDocumentPool get document pool that send every operation to the pool its collection is routed to.
+//Operations fail with NoPool when that pool is not registered.
+//
+//A transaction is bound to the pool of the first collection it use, using a collection of another pool
+//make it fail with CrossPoolTransaction. RunInTransaction use the default pool, use DocumentPoolFor to run
+//a transaction in another pool. Init and Close of the routed pool do nothing, pools are owned by engine.
+func (engine *Engine) GetRoutedDocumentPool() DocumentPool {
+
+	return &routedDocumentPool{engine: engine}
+}
+
+//GetRoutedMemPool get mem pool that send every operation to the pool its key is routed to, see GetRoutedDocumentPool.
+//FindKey is routed by the part of pattern before the first wildcard.
+func (engine *Engine) GetRoutedMemPool() MemPool {
+
+	return &routedMemPool{engine: engine}
+}
+
+//GetRoutedFilePool get file pool that send every operation to the pool its path is routed to, see GetRoutedDocumentPool
+func (engine *Engine) GetRoutedFilePool() FilePool {
+
+	return &routedFilePool{engine: engine}
+}
+
+//pingPools ping every distinct pool, it return the first error
+func pingPools(ctx context.Context, pools []Lifecycle) error {
+
+	for i, pool := range pools {
+
+		duplicated := false
+		for _, other := range pools[:i] {
+			if samePool(pool, other) {
+				duplicated = true
+			}
+		}
+		if duplicated {
+			continue
+		}
+		if err := pool.Ping(ctx); err != nil {
+
+			return err
+		}
+	}
+	return nil
+}
+
+type routedDocumentPool struct {
+	engine *Engine
+}
+
+func (pool *routedDocumentPool) route(collection string) (DocumentPool, error) {
+
+	documentPool := pool.engine.DocumentPoolFor(collection)
+	if documentPool == nil {
+
+		return nil, NoPool
+	}
+	return documentPool, nil
+}
+
+func (pool *routedDocumentPool) all() []Lifecycle {
+
+	pools := []Lifecycle{}
+	documentPools, _, _ := pool.engine.PoolNames()
+
+	for _, name := range documentPools {
+
+		pools = append(pools, pool.engine.GetDocumentPoolNamed(name))
+	}
+	return pools
+}
+
+func (pool *routedDocumentPool) Ping(ctx context.Context) error {
+
+	return pingPools(ctx, pool.all())
+}
+
+func (pool *routedDocumentPool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *routedDocumentPool) Init(connectionString string) error {
+
+	return nil
+}
+
+func (pool *routedDocumentPool) Put(collection string, document Document) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.Put(collection, document)
+}
+
+func (pool *routedDocumentPool) Get(collection string, id string, document interface{}) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.Get(collection, id, document)
+}
+
+func (pool *routedDocumentPool) PutRaw(collection string, id string, document interface{}) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.PutRaw(collection, id, document)
+}
+
+func (pool *routedDocumentPool) Del(collection string, id string) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.Del(collection, id)
+}
+
+func (pool *routedDocumentPool) Update(collection string, id string, update Update) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.Update(collection, id, update)
+}
+
+//IsNoRecordError check if any routed pool report err as no record
+func (pool *routedDocumentPool) IsNoRecordError(err error) bool {
+
+	if err == NoDocument {
+
+		return true
+	}
+	for _, documentPool := range pool.all() {
+
+		if documentPool.(DocumentPool).IsNoRecordError(err) {
+
+			return true
+		}
+	}
+	return false
+}
+
+func (pool *routedDocumentPool) MakeTransaction(options ...*TransactionOptions) DBTransaction {
+
+	return &routedTransaction{pool: pool, options: options}
+}
+
+func (pool *routedDocumentPool) RunInTransaction(ctx context.Context, fn func(tx DBTransaction) error, options ...*TransactionOptions) error {
+
+	documentPool := pool.engine.GetDocumentPool()
+	if documentPool == nil {
+
+		return NoPool
+	}
+	return documentPool.RunInTransaction(ctx, func(tx DBTransaction) error {
+
+		routed := &routedTransaction{pool: pool, tx: tx, bound: documentPool}
+		//the pool commit tx itself, a write that was dropped must fail the run
+		if err := fn(routed); err != nil {
+
+			return err
+		}
+		return routed.err
+	}, options...)
+}
+
+func (pool *routedDocumentPool) Query(query DBQuery) DBQueryResult {
+
+	documentPool, err := pool.route(query.Collection)
+	if err != nil {
+		return &errorQueryResult{err: err}
+	}
+	return documentPool.Query(query)
+}
+
+func (pool *routedDocumentPool) UpdateWhere(query DBQuery, update Update) (int64, error) {
+
+	documentPool, err := pool.route(query.Collection)
+	if err != nil {
+		return 0, err
+	}
+	return documentPool.UpdateWhere(query, update)
+}
+
+func (pool *routedDocumentPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	documentPool, err := pool.route(query.Collection)
+	if err != nil {
+		return 0, err
+	}
+	return documentPool.DeleteWhere(query)
+}
+
+func (pool *routedDocumentPool) CleanPagingInfo(query DBQuery) {
+
+	if documentPool, err := pool.route(query.Collection); err == nil {
+
+		documentPool.CleanPagingInfo(query)
+	}
+}
+
+func (pool *routedDocumentPool) Watch(ctx context.Context, collection string, query *DBQuery, resumeToken string) <-chan ChangeEvent {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+
+		events := make(chan ChangeEvent, 1)
+		events <- ChangeEvent{Collection: collection, Err: err}
+		close(events)
+		return events
+	}
+	return documentPool.Watch(ctx, collection, query, resumeToken)
+}
+
+func (pool *routedDocumentPool) CreateCollection(collection string) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.CreateCollection(collection)
+}
+
+func (pool *routedDocumentPool) DelCollection(collection string) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.DelCollection(collection)
+}
+
+func (pool *routedDocumentPool) EnsureIndex(collection string, index IndexSpec) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.EnsureIndex(collection, index)
+}
+
+func (pool *routedDocumentPool) ListIndexes(collection string) ([]IndexSpec, error) {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return nil, err
+	}
+	return documentPool.ListIndexes(collection)
+}
+
+func (pool *routedDocumentPool) DropIndex(collection string, name string) error {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return err
+	}
+	return documentPool.DropIndex(collection, name)
+}
+
+func (pool *routedDocumentPool) CollectVaryInt(collection string, field string) (map[string]int, error) {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return nil, err
+	}
+	return documentPool.CollectVaryInt(collection, field)
+}
+
+func (pool *routedDocumentPool) CollectVaryString(collection string, field string) (map[string]int, error) {
+
+	documentPool, err := pool.route(collection)
+	if err != nil {
+		return nil, err
+	}
+	return documentPool.CollectVaryString(collection, field)
+}
+
+func (pool *routedDocumentPool) CollectVaryQueryInt(query DBQuery, field string) (map[string]int, error) {
+
+	documentPool, err := pool.route(query.Collection)
+	if err != nil {
+		return nil, err
+	}
+	return documentPool.CollectVaryQueryInt(query, field)
+}
+
+func (pool *routedDocumentPool) CollectVaryQueryString(query DBQuery, field string) (map[string]int, error) {
+
+	documentPool, err := pool.route(query.Collection)
+	if err != nil {
+		return nil, err
+	}
+	return documentPool.CollectVaryQueryString(query, field)
+}
+
+//routedTransaction is bound to the pool of the first collection it use,
+//a write that can not be routed is reported by Commit
+type routedTransaction struct {
+	pool    *routedDocumentPool
+	options []*TransactionOptions
+	tx      DBTransaction
+	bound   DocumentPool
+	begun   bool
+	err     error
+}
+
+//bind return the transaction of the pool of collection
+func (tx *routedTransaction) bind(collection string) (DBTransaction, error) {
+
+	documentPool, err := tx.pool.route(collection)
+	if err != nil {
+
+		return nil, err
+	}
+	if tx.tx == nil {
+
+		tx.bound = documentPool
+		tx.tx = documentPool.MakeTransaction(tx.options...)
+		if tx.begun {
+			tx.tx.Begin()
+		}
+	} else if !samePool(documentPool, tx.bound) {
+
+		return nil, CrossPoolTransaction
+	}
+	return tx.tx, nil
+}
+
+//write bind collection and keep the first error for Commit
+func (tx *routedTransaction) write(collection string) DBTransaction {
+
+	bound, err := tx.bind(collection)
+	if err != nil && tx.err == nil {
+
+		tx.err = err
+	}
+	return bound
+}
+
+func (tx *routedTransaction) Begin() {
+
+	tx.begun = true
+	if tx.tx != nil {
+		tx.tx.Begin()
+	}
+}
+
+func (tx *routedTransaction) Get(collection string, id string, document interface{}) error {
+
+	bound, err := tx.bind(collection)
+	if err != nil {
+		return err
+	}
+	return bound.Get(collection, id, document)
+}
+
+func (tx *routedTransaction) Query(query DBQuery) DBQueryResult {
+
+	bound, err := tx.bind(query.Collection)
+	if err != nil {
+		return &errorQueryResult{err: err}
+	}
+	return bound.Query(query)
+}
+
+func (tx *routedTransaction) Put(collection string, document Document) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.Put(collection, document)
+	}
+}
+
+func (tx *routedTransaction) PutRaw(collection string, id string, document interface{}) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.PutRaw(collection, id, document)
+	}
+}
+
+func (tx *routedTransaction) Del(collection string, id string) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.Del(collection, id)
+	}
+}
+
+func (tx *routedTransaction) Update(collection string, id string, update Update) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.Update(collection, id, update)
+	}
+}
+
+func (tx *routedTransaction) CreateCollection(collection string) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.CreateCollection(collection)
+	}
+}
+
+func (tx *routedTransaction) DelCollection(collection string) {
+
+	if bound := tx.write(collection); bound != nil {
+		bound.DelCollection(collection)
+	}
+}
+
+func (tx *routedTransaction) Len() int {
+
+	if tx.tx == nil {
+		return 0
+	}
+	return tx.tx.Len()
+}
+
+func (tx *routedTransaction) Items() []TransactionItem {
+
+	if tx.tx == nil {
+		return nil
+	}
+	return tx.tx.Items()
+}
+
+func (tx *routedTransaction) Savepoint() int {
+
+	if tx.tx == nil {
+		return 0
+	}
+	return tx.tx.Savepoint()
+}
+
+func (tx *routedTransaction) RollbackTo(savepoint int) error {
+
+	if tx.tx == nil {
+		return nil
+	}
+	return tx.tx.RollbackTo(savepoint)
+}
+
+func (tx *routedTransaction) Rollback() error {
+
+	tx.err = nil
+	if tx.tx == nil {
+		return nil
+	}
+	return tx.tx.Rollback()
+}
+
+//Commit fail with the first routing error without committing any write
+func (tx *routedTransaction) Commit() error {
+
+	if tx.err != nil {
+		return tx.err
+	}
+	if tx.tx == nil {
+		return nil
+	}
+	return tx.tx.Commit()
+}
+
+type routedMemPool struct {
+	engine *Engine
+}
+
+func (pool *routedMemPool) route(key string) (MemPool, error) {
+
+	memPool := pool.engine.MemPoolFor(key)
+	if memPool == nil {
+
+		return nil, NoPool
+	}
+	return memPool, nil
+}
+
+func (pool *routedMemPool) all() []Lifecycle {
+
+	pools := []Lifecycle{}
+	_, memPools, _ := pool.engine.PoolNames()
+
+	for _, name := range memPools {
+
+		pools = append(pools, pool.engine.GetMemPoolNamed(name))
+	}
+	return pools
+}
+
+func (pool *routedMemPool) Ping(ctx context.Context) error {
+
+	return pingPools(ctx, pool.all())
+}
+
+func (pool *routedMemPool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *routedMemPool) Init(connectionString string) error {
+
+	return nil
+}
+
+func (pool *routedMemPool) do(key string, fn func(memPool MemPool) error) error {
+
+	memPool, err := pool.route(key)
+	if err != nil {
+		return err
+	}
+	return fn(memPool)
+}
+
+func (pool *routedMemPool) doInt(key string, fn func(memPool MemPool) (int64, error)) (int64, error) {
+
+	memPool, err := pool.route(key)
+	if err != nil {
+		return 0, err
+	}
+	return fn(memPool)
+}
+
+func (pool *routedMemPool) doString(key string, fn func(memPool MemPool) (string, error)) (string, error) {
+
+	memPool, err := pool.route(key)
+	if err != nil {
+		return "", err
+	}
+	return fn(memPool)
+}
+
+func (pool *routedMemPool) Set(key string, value string) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.Set(key, value) })
+}
+
+func (pool *routedMemPool) SetInt(key string, value int64) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetInt(key, value) })
+}
+
+func (pool *routedMemPool) IncrInt(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.IncrInt(key) })
+}
+
+func (pool *routedMemPool) DecrInt(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.DecrInt(key) })
+}
+
+func (pool *routedMemPool) IncrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.IncrIntBy(key, num) })
+}
+
+func (pool *routedMemPool) DecrIntBy(key string, num int64) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.DecrIntBy(key, num) })
+}
+
+func (pool *routedMemPool) SetShading(key string, value string) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetShading(key, value) })
+}
+
+func (pool *routedMemPool) SetIntShading(key string, value int64) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetIntShading(key, value) })
+}
+
+func (pool *routedMemPool) IncrIntShading(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.IncrIntShading(key) })
+}
+
+func (pool *routedMemPool) DescIntShading(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.DescIntShading(key) })
+}
+
+func (pool *routedMemPool) IncrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.IncrIntByShading(key, num) })
+}
+
+func (pool *routedMemPool) DecrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.DecrIntByShading(key, num) })
+}
+
+func (pool *routedMemPool) SetExpire(key string, value string, d time.Duration) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetExpire(key, value, d) })
+}
+
+func (pool *routedMemPool) SetIntExpire(key string, value int64, d time.Duration) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetIntExpire(key, value, d) })
+}
+
+func (pool *routedMemPool) SetExpireShading(key string, value string, d time.Duration) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetExpireShading(key, value, d) })
+}
+
+func (pool *routedMemPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.SetIntExpireShading(key, value, d) })
+}
+
+func (pool *routedMemPool) Get(key string) (string, error) {
+
+	return pool.doString(key, func(memPool MemPool) (string, error) { return memPool.Get(key) })
+}
+
+func (pool *routedMemPool) GetInt(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.GetInt(key) })
+}
+
+func (pool *routedMemPool) GetShading(key string) (string, error) {
+
+	return pool.doString(key, func(memPool MemPool) (string, error) { return memPool.GetShading(key) })
+}
+
+func (pool *routedMemPool) GetIntShading(key string) (int64, error) {
+
+	return pool.doInt(key, func(memPool MemPool) (int64, error) { return memPool.GetIntShading(key) })
+}
+
+func (pool *routedMemPool) Del(key string) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.Del(key) })
+}
+
+func (pool *routedMemPool) DelShading(key string) error {
+
+	return pool.do(key, func(memPool MemPool) error { return memPool.DelShading(key) })
+}
+
+func (pool *routedMemPool) FindKey(keyPattern string) ([]string, error) {
+
+	prefix := keyPattern
+	if wildcard := strings.IndexAny(keyPattern, "*?[\\"); wildcard >= 0 {
+		prefix = keyPattern[:wildcard]
+	}
+	memPool, err := pool.route(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return memPool.FindKey(keyPattern)
+}
+
+//IsNotExistedError check if any routed pool report err as not existed
+func (pool *routedMemPool) IsNotExistedError(err error) bool {
+
+	for _, memPool := range pool.all() {
+
+		if memPool.(MemPool).IsNotExistedError(err) {
+
+			return true
+		}
+	}
+	return false
+}
+
+type routedFilePool struct {
+	engine *Engine
+}
+
+func (pool *routedFilePool) route(path string) (FilePool, error) {
+
+	filePool := pool.engine.FilePoolFor(path)
+	if filePool == nil {
+
+		return nil, NoPool
+	}
+	return filePool, nil
+}
+
+func (pool *routedFilePool) Ping(ctx context.Context) error {
+
+	pools := []Lifecycle{}
+	_, _, filePools := pool.engine.PoolNames()
+
+	for _, name := range filePools {
+
+		pools = append(pools, pool.engine.GetFilePoolNamed(name))
+	}
+	return pingPools(ctx, pools)
+}
+
+func (pool *routedFilePool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *routedFilePool) Read(path string) (*[]byte, error) {
+
+	filePool, err := pool.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return filePool.Read(path)
+}
+
+func (pool *routedFilePool) Write(path string, content *[]byte) error {
+
+	filePool, err := pool.route(path)
+	if err != nil {
+		return err
+	}
+	return filePool.Write(path, content)
+}
+
+func (pool *routedFilePool) Delete(path string) error {
+
+	filePool, err := pool.route(path)
+	if err != nil {
+		return err
+	}
+	return filePool.Delete(path)
+}
@@ -0,0 +1,701 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var InvalidTenant = errors.New("invalid tenant id")
+var TenantDatabaseUnsupported = errors.New("document pool can not serve a database per tenant")
+
+//__tenant_collection_separator separate tenant id and collection, tenant id has no _ so the prefix is not ambiguous
+const __tenant_collection_separator = "__"
+
+//DatabaseScoper is implemented by document pools those can serve another database with the same connections
+type DatabaseScoper interface {
+	WithDatabase(database string) DocumentPool
+}
+
+//TenantOptions configure ForTenant
+type TenantOptions struct {
+	//Database serve documents of the tenant from the database DatabasePrefix+id of the document pool
+	//instead of prefixing collections, the document pool must implement DatabaseScoper.
+	//Middlewares those wrap it must implement DocumentPoolRewrapper to be kept for the tenant.
+	Database bool
+	//DatabasePrefix default is "tenant_"
+	DatabasePrefix string
+}
+
+//Tenant is a view of the default pools of an engine where collections, mem keys and file paths
+//are namespaced by the tenant id, a tenant can not reach the data of other tenants through it.
+//Collections are prefixed by id__, keys by id: and paths by /id.
+type Tenant struct {
+	engine       *Engine
+	id           string
+	database     string
+	documentPool DocumentPool
+	memPool      MemPool
+	filePool     FilePool
+}
+
+func isValidTenantID(id string) bool {
+
+	if id == "" || len(id) > 64 {
+
+		return false
+	}
+	for _, char := range id {
+
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-') {
+
+			return false
+		}
+	}
+	return true
+}
+
+//ForTenant make tenant view of the default pools of engine, id can have letters, digits and - only.
+//Pools those are not set in engine are nil in the view.
+func (engine *Engine) ForTenant(id string, options ...TenantOptions) (*Tenant, error) {
+
+	if !isValidTenantID(id) {
+
+		return nil, InvalidTenant
+	}
+	option := TenantOptions{}
+	for _, other := range options {
+		option = other
+	}
+	tenant := &Tenant{engine: engine, id: id}
+
+	if documentPool := engine.GetDocumentPool(); documentPool != nil {
+
+		prefix := id + __tenant_collection_separator
+
+		if option.Database {
+
+			tenant.database = option.DatabasePrefix + id
+			if option.DatabasePrefix == "" {
+				tenant.database = "tenant_" + id
+			}
+			scoped, err := scopeDatabase(documentPool, tenant.database)
+			if err != nil {
+
+				return nil, err
+			}
+			documentPool = scoped
+			if engine.hasAdminKey() {
+				documentPool = guardPool(documentPool)
+			}
+			prefix = ""
+		}
+		tenant.documentPool = newTenantDocumentPool(documentPool, prefix)
+	}
+	if memPool := engine.GetMemPool(); memPool != nil {
+
+		tenant.memPool = &tenantMemPool{MemPoolWrapper: NewMemPoolWrapper(memPool), prefix: id + ":"}
+	}
+	if filePool := engine.GetFilePool(); filePool != nil {
+
+		tenant.filePool = &tenantFilePool{FilePoolWrapper: NewFilePoolWrapper(filePool), prefix: "/" + id}
+	}
+	return tenant, nil
+}
+
+//scopeDatabase find the DatabaseScoper wrapped by pool and wrap its pool of database by the middlewares
+//those wrap it in pool, every middleware on the way must implement DocumentPoolRewrapper
+func scopeDatabase(pool DocumentPool, database string) (DocumentPool, error) {
+
+	var scoper DatabaseScoper = nil
+	rewrappers := []DocumentPoolRewrapper{}
+
+	walkPool(pool, func(inner interface{}) bool {
+
+		if found, ok := inner.(DatabaseScoper); ok {
+
+			scoper = found
+			return false
+		}
+		rewrapper, ok := inner.(DocumentPoolRewrapper)
+		if ok {
+			rewrappers = append(rewrappers, rewrapper)
+		}
+		return ok
+	})
+	if scoper == nil {
+
+		return nil, TenantDatabaseUnsupported
+	}
+	scoped := scoper.WithDatabase(database)
+
+	for i := len(rewrappers) - 1; i >= 0; i-- {
+
+		scoped = rewrappers[i].Rewrap(scoped)
+	}
+	return scoped, nil
+}
+
+//ID get id of tenant
+func (tenant *Tenant) ID() string {
+
+	return tenant.id
+}
+
+//GetDocumentPool get document pool of tenant, its Close does nothing because the pool is owned by engine
+func (tenant *Tenant) GetDocumentPool() DocumentPool {
+
+	return tenant.documentPool
+}
+
+//GetMemPool get mem pool of tenant, see GetDocumentPool
+func (tenant *Tenant) GetMemPool() MemPool {
+
+	return tenant.memPool
+}
+
+//GetFilePool get file pool of tenant, see GetDocumentPool
+func (tenant *Tenant) GetFilePool() FilePool {
+
+	return tenant.filePool
+}
+
+//Collection get the name of collection of tenant that admin commands are signed for,
+//it is database.collection when the tenant has its own database
+func (tenant *Tenant) Collection(collection string) string {
+
+	if tenant.database != "" {
+
+		return tenant.database + "." + collection
+	}
+	return tenant.id + __tenant_collection_separator + collection
+}
+
+//TenantData select the data of a tenant for Export and Delete, document pools and file pools can not list
+//their collections and files so they must be given
+type TenantData struct {
+	Collections []string
+	Paths       []string
+	//Keys select every mem key of tenant
+	Keys bool
+}
+
+//TenantRecord is a line of the json lines written by Export
+type TenantRecord struct {
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection,omitempty"`
+	Document   map[string]interface{} `json:"document,omitempty"`
+	Key        string                 `json:"key,omitempty"`
+	Value      string                 `json:"value,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+	Content    []byte                 `json:"content,omitempty"`
+}
+
+//Export write data of tenant to writer as json lines of TenantRecord, names are not prefixed by tenant
+func (tenant *Tenant) Export(data TenantData, writer io.Writer) error {
+
+	encoder := json.NewEncoder(writer)
+
+	for _, collection := range data.Collections {
+
+		if tenant.documentPool == nil {
+			return NoPool
+		}
+		result := tenant.documentPool.Query(MakeDBQuery(collection, false))
+		if err := result.Error(); err != nil {
+			return err
+		}
+		for {
+			document := map[string]interface{}{}
+			err := result.Next(&document)
+			if err == NoDocument {
+				break
+			}
+			if err != nil {
+				result.Close()
+				return err
+			}
+			if err := encoder.Encode(TenantRecord{Type: "document", Collection: collection, Document: document}); err != nil {
+				result.Close()
+				return err
+			}
+		}
+		result.Close()
+	}
+	if data.Keys {
+
+		if tenant.memPool == nil {
+			return NoPool
+		}
+		keys, err := tenant.memPool.FindKey("*")
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			value, err := tenant.memPool.Get(key)
+			if tenant.memPool.IsNotExistedError(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(TenantRecord{Type: "mem", Key: key, Value: value}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, filePath := range data.Paths {
+
+		if tenant.filePool == nil {
+			return NoPool
+		}
+		content, err := tenant.filePool.Read(filePath)
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			continue
+		}
+		if err := encoder.Encode(TenantRecord{Type: "file", Path: filePath, Content: *content}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Delete delete data of tenant. When engine has an admin public key every collection needs a command
+//for AdminOperationDelCollection signed for Collection(collection), see Engine.SetAdminPublicKey.
+func (tenant *Tenant) Delete(data TenantData, commands ...AdminCommand) error {
+
+	for _, collection := range data.Collections {
+
+		if tenant.documentPool == nil {
+			return NoPool
+		}
+		if !tenant.engine.hasAdminKey() {
+
+			if err := tenant.documentPool.DelCollection(collection); err != nil {
+				return err
+			}
+			continue
+		}
+		var command *AdminCommand = nil
+		for i := range commands {
+			if commands[i].Collection == tenant.Collection(collection) {
+				command = &commands[i]
+			}
+		}
+		if command == nil {
+			return AdminRequired
+		}
+		if err := tenant.engine.VerifyAdminCommand(*command, AdminOperationDelCollection); err != nil {
+			return err
+		}
+		inner := tenant.documentPool.(*tenantDocumentPool)
+		if err := unguardPool(inner.DocumentPool).DelCollection(inner.collection(collection)); err != nil {
+			return err
+		}
+	}
+	if data.Keys {
+
+		if tenant.memPool == nil {
+			return NoPool
+		}
+		keys, err := tenant.memPool.FindKey("*")
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tenant.memPool.Del(key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, filePath := range data.Paths {
+
+		if tenant.filePool == nil {
+			return NoPool
+		}
+		if err := tenant.filePool.Delete(filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//tenantDocumentPool prefix collections, prefix is empty when the tenant has its own database
+type tenantDocumentPool struct {
+	*DocumentPoolWrapper
+	prefix string
+}
+
+func newTenantDocumentPool(next DocumentPool, prefix string) *tenantDocumentPool {
+
+	pool := &tenantDocumentPool{DocumentPoolWrapper: NewDocumentPoolWrapper(next), prefix: prefix}
+	pool.WrapTransaction = func(tx DBTransaction) DBTransaction {
+
+		return &tenantTransaction{DBTransaction: tx, pool: pool}
+	}
+	return pool
+}
+
+func (pool *tenantDocumentPool) collection(collection string) string {
+
+	return pool.prefix + collection
+}
+
+func (pool *tenantDocumentPool) query(query DBQuery) DBQuery {
+
+	query.Collection = pool.collection(query.Collection)
+	return query
+}
+
+func (pool *tenantDocumentPool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *tenantDocumentPool) Put(collection string, document Document) error {
+
+	return pool.DocumentPool.Put(pool.collection(collection), document)
+}
+
+func (pool *tenantDocumentPool) Get(collection string, id string, document interface{}) error {
+
+	return pool.DocumentPool.Get(pool.collection(collection), id, document)
+}
+
+func (pool *tenantDocumentPool) PutRaw(collection string, id string, document interface{}) error {
+
+	return pool.DocumentPool.PutRaw(pool.collection(collection), id, document)
+}
+
+func (pool *tenantDocumentPool) Del(collection string, id string) error {
+
+	return pool.DocumentPool.Del(pool.collection(collection), id)
+}
+
+func (pool *tenantDocumentPool) Update(collection string, id string, update Update) error {
+
+	return pool.DocumentPool.Update(pool.collection(collection), id, update)
+}
+
+func (pool *tenantDocumentPool) Query(query DBQuery) DBQueryResult {
+
+	return pool.DocumentPoolWrapper.Query(pool.query(query))
+}
+
+func (pool *tenantDocumentPool) UpdateWhere(query DBQuery, update Update) (int64, error) {
+
+	return pool.DocumentPool.UpdateWhere(pool.query(query), update)
+}
+
+func (pool *tenantDocumentPool) DeleteWhere(query DBQuery) (int64, error) {
+
+	return pool.DocumentPool.DeleteWhere(pool.query(query))
+}
+
+func (pool *tenantDocumentPool) CleanPagingInfo(query DBQuery) {
+
+	pool.DocumentPool.CleanPagingInfo(pool.query(query))
+}
+
+//Watch report collections of events without the tenant prefix
+func (pool *tenantDocumentPool) Watch(ctx context.Context, collection string, query *DBQuery, resumeToken string) <-chan ChangeEvent {
+
+	if query != nil {
+		scoped := pool.query(*query)
+		query = &scoped
+	}
+	events := pool.DocumentPool.Watch(ctx, pool.collection(collection), query, resumeToken)
+	if pool.prefix == "" {
+		return events
+	}
+	tenantEvents := make(chan ChangeEvent)
+
+	go func() {
+		defer close(tenantEvents)
+
+		for event := range events {
+			event.Collection = strings.TrimPrefix(event.Collection, pool.prefix)
+			select {
+			case tenantEvents <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return tenantEvents
+}
+
+func (pool *tenantDocumentPool) CreateCollection(collection string) error {
+
+	return pool.DocumentPool.CreateCollection(pool.collection(collection))
+}
+
+func (pool *tenantDocumentPool) DelCollection(collection string) error {
+
+	return pool.DocumentPool.DelCollection(pool.collection(collection))
+}
+
+func (pool *tenantDocumentPool) EnsureIndex(collection string, index IndexSpec) error {
+
+	return pool.DocumentPool.EnsureIndex(pool.collection(collection), index)
+}
+
+func (pool *tenantDocumentPool) ListIndexes(collection string) ([]IndexSpec, error) {
+
+	return pool.DocumentPool.ListIndexes(pool.collection(collection))
+}
+
+func (pool *tenantDocumentPool) DropIndex(collection string, name string) error {
+
+	return pool.DocumentPool.DropIndex(pool.collection(collection), name)
+}
+
+func (pool *tenantDocumentPool) CollectVaryInt(collection string, field string) (map[string]int, error) {
+
+	return pool.DocumentPool.CollectVaryInt(pool.collection(collection), field)
+}
+
+func (pool *tenantDocumentPool) CollectVaryString(collection string, field string) (map[string]int, error) {
+
+	return pool.DocumentPool.CollectVaryString(pool.collection(collection), field)
+}
+
+func (pool *tenantDocumentPool) CollectVaryQueryInt(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.DocumentPool.CollectVaryQueryInt(pool.query(query), field)
+}
+
+func (pool *tenantDocumentPool) CollectVaryQueryString(query DBQuery, field string) (map[string]int, error) {
+
+	return pool.DocumentPool.CollectVaryQueryString(pool.query(query), field)
+}
+
+//tenantTransaction prefix collections of a transaction, Items report them without the prefix
+type tenantTransaction struct {
+	DBTransaction
+	pool *tenantDocumentPool
+}
+
+func (tx *tenantTransaction) Get(collection string, id string, document interface{}) error {
+
+	return tx.DBTransaction.Get(tx.pool.collection(collection), id, document)
+}
+
+func (tx *tenantTransaction) Query(query DBQuery) DBQueryResult {
+
+	return tx.DBTransaction.Query(tx.pool.query(query))
+}
+
+func (tx *tenantTransaction) Put(collection string, document Document) {
+
+	tx.DBTransaction.Put(tx.pool.collection(collection), document)
+}
+
+func (tx *tenantTransaction) PutRaw(collection string, id string, document interface{}) {
+
+	tx.DBTransaction.PutRaw(tx.pool.collection(collection), id, document)
+}
+
+func (tx *tenantTransaction) Del(collection string, id string) {
+
+	tx.DBTransaction.Del(tx.pool.collection(collection), id)
+}
+
+func (tx *tenantTransaction) Update(collection string, id string, update Update) {
+
+	tx.DBTransaction.Update(tx.pool.collection(collection), id, update)
+}
+
+func (tx *tenantTransaction) CreateCollection(collection string) {
+
+	tx.DBTransaction.CreateCollection(tx.pool.collection(collection))
+}
+
+func (tx *tenantTransaction) DelCollection(collection string) {
+
+	tx.DBTransaction.DelCollection(tx.pool.collection(collection))
+}
+
+func (tx *tenantTransaction) Items() []TransactionItem {
+
+	items := append([]TransactionItem{}, tx.DBTransaction.Items()...)
+	for i := range items {
+		items[i].Collection = strings.TrimPrefix(items[i].Collection, tx.pool.prefix)
+	}
+	return items
+}
+
+//tenantMemPool prefix keys
+type tenantMemPool struct {
+	*MemPoolWrapper
+	prefix string
+}
+
+func (pool *tenantMemPool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *tenantMemPool) Set(key string, value string) error {
+
+	return pool.MemPool.Set(pool.prefix+key, value)
+}
+
+func (pool *tenantMemPool) SetInt(key string, value int64) error {
+
+	return pool.MemPool.SetInt(pool.prefix+key, value)
+}
+
+func (pool *tenantMemPool) IncrInt(key string) (int64, error) {
+
+	return pool.MemPool.IncrInt(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) DecrInt(key string) (int64, error) {
+
+	return pool.MemPool.DecrInt(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) IncrIntBy(key string, num int64) (int64, error) {
+
+	return pool.MemPool.IncrIntBy(pool.prefix+key, num)
+}
+
+func (pool *tenantMemPool) DecrIntBy(key string, num int64) (int64, error) {
+
+	return pool.MemPool.DecrIntBy(pool.prefix+key, num)
+}
+
+func (pool *tenantMemPool) SetShading(key string, value string) error {
+
+	return pool.MemPool.SetShading(pool.prefix+key, value)
+}
+
+func (pool *tenantMemPool) SetIntShading(key string, value int64) error {
+
+	return pool.MemPool.SetIntShading(pool.prefix+key, value)
+}
+
+func (pool *tenantMemPool) IncrIntShading(key string) (int64, error) {
+
+	return pool.MemPool.IncrIntShading(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) DescIntShading(key string) (int64, error) {
+
+	return pool.MemPool.DescIntShading(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) IncrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.MemPool.IncrIntByShading(pool.prefix+key, num)
+}
+
+func (pool *tenantMemPool) DecrIntByShading(key string, num int64) (int64, error) {
+
+	return pool.MemPool.DecrIntByShading(pool.prefix+key, num)
+}
+
+func (pool *tenantMemPool) SetExpire(key string, value string, d time.Duration) error {
+
+	return pool.MemPool.SetExpire(pool.prefix+key, value, d)
+}
+
+func (pool *tenantMemPool) SetIntExpire(key string, value int64, d time.Duration) error {
+
+	return pool.MemPool.SetIntExpire(pool.prefix+key, value, d)
+}
+
+func (pool *tenantMemPool) SetExpireShading(key string, value string, d time.Duration) error {
+
+	return pool.MemPool.SetExpireShading(pool.prefix+key, value, d)
+}
+
+func (pool *tenantMemPool) SetIntExpireShading(key string, value int64, d time.Duration) error {
+
+	return pool.MemPool.SetIntExpireShading(pool.prefix+key, value, d)
+}
+
+func (pool *tenantMemPool) Get(key string) (string, error) {
+
+	return pool.MemPool.Get(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) GetInt(key string) (int64, error) {
+
+	return pool.MemPool.GetInt(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) GetShading(key string) (string, error) {
+
+	return pool.MemPool.GetShading(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) GetIntShading(key string) (int64, error) {
+
+	return pool.MemPool.GetIntShading(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) Del(key string) error {
+
+	return pool.MemPool.Del(pool.prefix + key)
+}
+
+func (pool *tenantMemPool) DelShading(key string) error {
+
+	return pool.MemPool.DelShading(pool.prefix + key)
+}
+
+//FindKey find keys of tenant those match keyPattern, keys are returned without the tenant prefix
+func (pool *tenantMemPool) FindKey(keyPattern string) ([]string, error) {
+
+	keys, err := pool.MemPool.FindKey(pool.prefix + keyPattern)
+	if err != nil {
+		return nil, err
+	}
+	tenantKeys := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, pool.prefix) {
+			tenantKeys = append(tenantKeys, strings.TrimPrefix(key, pool.prefix))
+		}
+	}
+	return tenantKeys, nil
+}
+
+//tenantFilePool put files of tenant in its folder, paths can not escape it with ..
+type tenantFilePool struct {
+	*FilePoolWrapper
+	prefix string
+}
+
+func (pool *tenantFilePool) path(filePath string) string {
+
+	return pool.prefix + path.Clean("/"+filePath)
+}
+
+func (pool *tenantFilePool) Close(ctx context.Context) error {
+
+	return nil
+}
+
+func (pool *tenantFilePool) Read(filePath string) (*[]byte, error) {
+
+	return pool.FilePool.Read(pool.path(filePath))
+}
+
+func (pool *tenantFilePool) Write(filePath string, content *[]byte) error {
+
+	return pool.FilePool.Write(pool.path(filePath), content)
+}
+
+func (pool *tenantFilePool) Delete(filePath string) error {
+
+	return pool.FilePool.Delete(pool.path(filePath))
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func publicKeyPEM(t *testing.T, key interface{}) string {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func adminCommand(operation string, collection string) engine.AdminCommand {
+
+	return engine.AdminCommand{Operation: operation, Collection: collection, ExpiresAt: time.Now().Add(time.Minute), Nonce: uuid.New().String()}
+}
+
+func TestAdminCommand(t *testing.T) {
+
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+	documentPool.PutRaw("test_admin", "1", map[string]interface{}{"Number": 1})
+	documentPool.PutRaw("test_admin", "2", map[string]interface{}{"Number": 2})
+
+	eng := engine.NewEngine(nil, documentPool, nil)
+
+	if err := eng.AdminDelCollection(adminCommand(engine.AdminOperationDelCollection, "test_admin")); err != engine.AdminKeyNotSet {
+		t.Error("expect admin key is not set", err)
+	}
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	if err := eng.SetAdminPublicKey(publicKeyPEM(t, publicKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := eng.GetDocumentPool()
+	if err := pool.DelCollection("test_admin"); err != engine.AdminRequired {
+		t.Error("expect del collection is guarded", err)
+	}
+	if _, err := pool.DeleteWhere(engine.MakeDBQuery("test_admin", false)); err != engine.AdminRequired {
+		t.Error("expect delete where is guarded", err)
+	}
+	tx := pool.MakeTransaction()
+	tx.DelCollection("test_admin")
+	if err := tx.Commit(); err != engine.AdminRequired {
+		t.Error("expect transaction is guarded", err)
+	}
+
+	command := adminCommand(engine.AdminOperationDelCollection, "test_admin")
+	if err := eng.AdminDelCollection(command); err != engine.InvalidAdminSignature {
+		t.Error("expect unsigned command is rejected", err)
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	engine.SignAdminCommand(&command, otherKey)
+	if err := eng.AdminDelCollection(command); err != engine.InvalidAdminSignature {
+		t.Error("expect command signed by other key is rejected", err)
+	}
+
+	query := engine.MakeDBQuery("test_admin", false)
+	query.Filter("Number", "=", 1)
+	deleteWhere := adminCommand(engine.AdminOperationDeleteWhere, "test_admin")
+	deleteWhere.Query, _ = engine.AdminQuery(query)
+	engine.SignAdminCommand(&deleteWhere, privateKey)
+	if err := eng.AdminDelCollection(deleteWhere); err != engine.InvalidAdminCommand {
+		t.Error("expect command of other operation is rejected", err)
+	}
+	if _, err := eng.AdminDeleteWhere(deleteWhere, engine.MakeDBQuery("test_admin", false)); err != engine.InvalidAdminCommand {
+		t.Error("expect query that is not signed is rejected", err)
+	}
+	tampered := deleteWhere
+	tampered.Query, _ = engine.AdminQuery(engine.MakeDBQuery("test_admin", false))
+	if _, err := eng.AdminDeleteWhere(tampered, engine.MakeDBQuery("test_admin", false)); err != engine.InvalidAdminSignature {
+		t.Error("expect changed query is rejected", err)
+	}
+	if count, err := eng.AdminDeleteWhere(deleteWhere, query); err != nil || count != 1 {
+		t.Error("expect delete where is authorized", count, err)
+	}
+	if _, err := eng.AdminDeleteWhere(deleteWhere, query); err != engine.AdminCommandReplayed {
+		t.Error("expect replay is rejected", err)
+	}
+	document := map[string]interface{}{}
+	if err := documentPool.Get("test_admin", "2", &document); err != nil {
+		t.Error("expect document out of the signed query is kept", err)
+	}
+
+	expired := adminCommand(engine.AdminOperationDelCollection, "test_admin")
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	engine.SignAdminCommand(&expired, privateKey)
+	if err := eng.AdminDelCollection(expired); err != engine.AdminCommandExpired {
+		t.Error("expect expired command is rejected", err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if err := eng.SetAdminPublicKey(publicKeyPEM(t, &rsaKey.PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+	command = adminCommand(engine.AdminOperationDelCollection, "test_admin")
+	if err := engine.SignAdminCommand(&command, rsaKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.AdminDelCollection(command); err != nil {
+		t.Error("expect rsa signed command is authorized", err)
+	}
+
+	if err := eng.SetAdminPublicKey("not a key"); err != engine.InvalidAdminKey {
+		t.Error("expect invalid key", err)
+	}
+}
+
+func TestAdminNoncePool(t *testing.T) {
+
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+
+	noncePool := &adapter.LocalMemDB{}
+	noncePool.Init("")
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	workers := []*engine.Engine{}
+	for i := 0; i < 2; i++ {
+		eng := engine.NewEngine(nil, documentPool, nil)
+		if err := eng.SetAdminPublicKey(publicKeyPEM(t, publicKey)); err != nil {
+			t.Fatal(err)
+		}
+		eng.SetAdminNoncePool(noncePool)
+		workers = append(workers, eng)
+	}
+
+	command := adminCommand(engine.AdminOperationDelCollection, "test_admin_nonce")
+	engine.SignAdminCommand(&command, privateKey)
+	if err := workers[0].AdminDelCollection(command); err != nil {
+		t.Error("expect command is authorized", err)
+	}
+	if err := workers[1].AdminDelCollection(command); err != engine.AdminCommandReplayed {
+		t.Error("expect replay on other engine is rejected", err)
+	}
+}
+
+func TestAdminGuardSharedPool(t *testing.T) {
+
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+
+	eng := engine.NewEngine(nil, documentPool, nil)
+	eng.RegisterDocumentPool("before", documentPool)
+
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := eng.SetAdminPublicKey(publicKeyPEM(t, publicKey)); err != nil {
+		t.Fatal(err)
+	}
+	eng.RegisterDocumentPool("after", documentPool)
+
+	for _, name := range []string{"before", "after"} {
+		if eng.GetDocumentPoolNamed(name) != eng.GetDocumentPool() {
+			t.Error("expect one guard of the shared pool", name)
+		}
+	}
+	if health := eng.Health(context.Background()); len(health.Pools) != 1 {
+		t.Error("expect shared pool is listed once", health.Pools)
+	}
+}
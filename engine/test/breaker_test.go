@@ -0,0 +1,191 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+//downMemDB fail every operation with errTransient while down is set
+type downMemDB struct {
+	adapter.LocalMemDB
+	down  bool
+	calls int
+}
+
+func (db *downMemDB) IsTransientError(err error) bool {
+
+	return err == errTransient
+}
+
+func (db *downMemDB) Set(key string, value string) error {
+
+	db.calls++
+	if db.down {
+		return errTransient
+	}
+	return db.LocalMemDB.Set(key, value)
+}
+
+func (db *downMemDB) Get(key string) (string, error) {
+
+	db.calls++
+	if db.down {
+		return "", errTransient
+	}
+	return db.LocalMemDB.Get(key)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	db := &downMemDB{down: true}
+	db.Init("")
+
+	transitions := []engine.BreakerState{}
+	policy := engine.BreakerPolicy{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange: func(shard int, from engine.BreakerState, to engine.BreakerState) {
+			transitions = append(transitions, to)
+		},
+	}
+	pool := engine.ChainMemPool(db, engine.CircuitBreakerMemPool(policy, engine.MemBreakerOptions{}))
+
+	pool.Set("key", "value")
+	pool.Set("key", "value")
+
+	if err := pool.Set("key", "value"); err != engine.CircuitOpen || db.calls != 2 {
+		t.Error("expect breaker open after 2 failures", err, db.calls)
+	}
+
+	db.down = false
+	time.Sleep(30 * time.Millisecond)
+
+	if err := pool.Set("key", "value"); err != nil {
+		t.Error("expect probe succeed", err)
+	}
+	if value, err := pool.Get("key"); err != nil || value != "value" {
+		t.Error("expect breaker closed after probe", value, err)
+	}
+	if len(transitions) != 3 || transitions[0] != engine.BreakerOpen || transitions[1] != engine.BreakerHalfOpen || transitions[2] != engine.BreakerClosed {
+		t.Error("unexpected transitions", transitions)
+	}
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+
+	db := &downMemDB{down: true}
+	db.Init("")
+
+	fallback := &adapter.LocalMemDB{}
+	fallback.Init("")
+
+	policy := engine.BreakerPolicy{FailureThreshold: 1}
+	pool := engine.ChainMemPool(db, engine.CircuitBreakerMemPool(policy, engine.MemBreakerOptions{Fallback: fallback}))
+
+	pool.Set("key", "value")
+
+	if err := pool.Set("key", "fallback"); err != nil || db.calls != 1 {
+		t.Error("expect set served by fallback", err, db.calls)
+	}
+	if value, err := pool.Get("key"); err != nil || value != "fallback" {
+		t.Error("expect get served by fallback", value, err)
+	}
+	if _, err := pool.Get("missing"); !pool.IsNotExistedError(err) {
+		t.Error("expect not existed error of fallback", err)
+	}
+}
+
+func TestCircuitBreakerSkip(t *testing.T) {
+
+	db := &downMemDB{down: true}
+	db.Init("")
+
+	policy := engine.BreakerPolicy{FailureThreshold: 1}
+	pool := engine.ChainMemPool(db, engine.CircuitBreakerMemPool(policy, engine.MemBreakerOptions{SkipWhenOpen: true}))
+
+	pool.Set("key", "value")
+
+	if err := pool.Set("key", "value"); err != nil {
+		t.Error("expect set skipped", err)
+	}
+	if _, err := pool.Get("key"); err != engine.CircuitOpen || !pool.IsNotExistedError(err) {
+		t.Error("expect get miss", err)
+	}
+}
+
+func TestCircuitBreakerDocumentPool(t *testing.T) {
+
+	db := &flakyDocDB{}
+	db.Init("")
+
+	pool := engine.Chain(db, engine.CircuitBreakerDocumentPool(engine.BreakerPolicy{FailureThreshold: 2}))
+
+	document := map[string]interface{}{"Number": 1}
+	var result map[string]interface{}
+
+	if err := pool.Get("test_breaker", "missing", &result); !pool.IsNoRecordError(err) {
+		t.Error("expect no record error", err)
+	}
+
+	db.failures = 2
+	pool.PutRaw("test_breaker", "1", document)
+	pool.PutRaw("test_breaker", "1", document)
+
+	if err := pool.PutRaw("test_breaker", "1", document); err != engine.CircuitOpen || db.calls != 2 {
+		t.Error("expect breaker open after 2 failures", err, db.calls)
+	}
+	if err := pool.Query(engine.MakeDBQuery("test_breaker", false)).Error(); err != engine.CircuitOpen {
+		t.Error("expect query rejected", err)
+	}
+}
+
+//operations of a transaction inside RunInTransaction are guarded by the run, a half open breaker close only when the run reach the backend
+func TestCircuitBreakerRunInTransaction(t *testing.T) {
+
+	db := &flakyDocDB{}
+	db.Init("")
+	db.PutRaw("test_breaker", "1", map[string]interface{}{"Number": 1})
+
+	transitions := []engine.BreakerState{}
+	policy := engine.BreakerPolicy{
+		FailureThreshold: 1,
+		OpenTimeout:      10 * time.Millisecond,
+		HalfOpenProbes:   1,
+		IsFailure:        func(err error) bool { return err == errTransient },
+		OnStateChange: func(shard int, from engine.BreakerState, to engine.BreakerState) {
+			transitions = append(transitions, to)
+		},
+	}
+	pool := engine.Chain(db, engine.CircuitBreakerDocumentPool(policy))
+
+	db.failures = 1
+	pool.PutRaw("test_breaker", "2", map[string]interface{}{"Number": 2})
+	time.Sleep(20 * time.Millisecond)
+
+	err := pool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		document := map[string]interface{}{}
+		if err := tx.Get("test_breaker", "1", &document); err != nil {
+			return err
+		}
+		if count := tx.Query(engine.MakeDBQuery("test_breaker", false)).Count(); count != 1 {
+			t.Error("expect 1 document", count)
+		}
+		tx.PutRaw("test_breaker", "3", map[string]interface{}{"Number": 3})
+		return nil
+	})
+	if err != nil {
+		t.Error("expect transaction of the probe succeed", err)
+	}
+	if len(transitions) != 3 || transitions[2] != engine.BreakerClosed {
+		t.Error("expect breaker closed after the probe", transitions)
+	}
+	document := map[string]interface{}{}
+	if err := db.Get("test_breaker", "3", &document); err != nil {
+		t.Error("expect write of the probe committed", err)
+	}
+}
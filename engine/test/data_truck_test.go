@@ -0,0 +1,62 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+//limitedDocDB a local db that accept only 2 writes per transaction
+type limitedDocDB struct {
+	adapter.LocalDocDB
+}
+
+func (db *limitedDocDB) MaxBatchSize() int {
+	return 2
+}
+
+func TestDataTruck(t *testing.T) {
+
+	db := &limitedDocDB{}
+	db.Init("")
+
+	index := engine.MakeIndexSpec("Number")
+	index.Unique = true
+	db.EnsureIndex("test", index)
+
+	db.Put("test", &testStruct{ID: 9, Number: 9})
+
+	truck := engine.CreateDataStruck()
+	truck.Append("test", &testStruct{ID: 1, Number: 1})
+	truck.AppendRaw("test", "2", map[string]interface{}{"Number": 2})
+	truck.AppendDel("test", "9")
+	truck.Append("test", &testStruct{ID: 4, Number: 4})
+
+	if err := truck.Commit(db); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Get("test", "9", &testStruct{}); !db.IsNoRecordError(err) {
+		t.Error("document 9 is not deleted", err)
+	}
+	truck = engine.CreateDataStruck()
+	truck.Append("test", &testStruct{ID: 5, Number: 5})
+	truck.Append("test", &testStruct{ID: 6, Number: 6})
+	truck.Append("test", &testStruct{ID: 7, Number: 7})
+	truck.Append("test", &testStruct{ID: 8, Number: 1})
+
+	err := truck.Commit(db)
+	truckErr := &engine.DataTruckError{}
+	if !errors.As(err, &truckErr) || truckErr.Committed != 2 || len(truckErr.Failed) != 2 {
+		t.Error("expect second part fail", err)
+		return
+	}
+	if err := db.Get("test", "7", &testStruct{}); !db.IsNoRecordError(err) {
+		t.Error("document 7 is not rolled back", err)
+	}
+	if err := db.Get("test", "6", &testStruct{}); err != nil {
+		t.Error("document 6 is not committed", err)
+	}
+}
@@ -0,0 +1,305 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type encryptedAddress struct {
+	City   string `json:"City" bson:"City"`
+	Street string `json:"Street" bson:"Street" godb:"encrypt"`
+}
+
+type encryptedUser struct {
+	ID      string            `json:"ID" bson:"ID"`
+	Name    string            `json:"Name" bson:"Name" godb:"encrypt"`
+	Email   string            `json:"Email" bson:"Email" godb:"encrypt,deterministic"`
+	Secret  []byte            `json:"Secret" bson:"Secret" godb:"encrypt"`
+	Address *encryptedAddress `json:"Address" bson:"Address"`
+}
+
+func (user *encryptedUser) GetID() string {
+
+	return user.ID
+}
+
+func TestFieldEncryption(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	keys := engine.NewKeyRing()
+	if err := keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.AddKey("bad:id", make([]byte, 32)); !errors.Is(err, engine.InvalidEncryptionKey) {
+		t.Error("expect invalid key id", err)
+	}
+	encryption := engine.NewFieldEncryption(keys)
+	pool := engine.Chain(db, engine.EncryptFieldsDocumentPool(encryption))
+
+	user := &encryptedUser{ID: "1", Name: "Alice", Email: "alice@example.com", Secret: []byte("s1"), Address: &encryptedAddress{City: "Hanoi", Street: "1 Trang Tien"}}
+	if err := pool.Put("user", user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Alice" || user.Address.Street != "1 Trang Tien" {
+		t.Error("expect document of caller is not modified", user)
+	}
+	raw := map[string]interface{}{}
+	if err := db.Get("user", "1", &raw); err != nil {
+		t.Fatal(err)
+	}
+	address, _ := raw["Address"].(map[string]interface{})
+	if !engine.IsEncrypted(raw["Name"].(string)) || !engine.IsEncrypted(raw["Email"].(string)) || address == nil || !engine.IsEncrypted(address["Street"].(string)) || address["City"] != "Hanoi" {
+		t.Error("expect encrypted fields in database", raw)
+	}
+	loaded := &encryptedUser{}
+	if err := pool.Get("user", "1", loaded); err != nil || loaded.Name != "Alice" || loaded.Email != "alice@example.com" || string(loaded.Secret) != "s1" || loaded.Address.Street != "1 Trang Tien" {
+		t.Error("expect decrypted document", loaded, err)
+	}
+
+	//plaintext documents written before encryption are read as they are
+	db.PutRaw("user", "2", map[string]interface{}{"ID": "2", "Name": "Bob", "Email": "bob@example.com"})
+	loaded = &encryptedUser{}
+	if err := pool.Get("user", "2", loaded); err != nil || loaded.Name != "Bob" {
+		t.Error("expect plaintext document", loaded, err)
+	}
+	db.Del("user", "2")
+
+	//key rotation
+	if err := keys.Rotate("k2", []byte("fedcba9876543210fedcba9876543210")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Put("user", &encryptedUser{ID: "3", Name: "Carol", Email: "carol@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	raw = map[string]interface{}{}
+	db.Get("user", "3", &raw)
+	if !strings.Contains(raw["Name"].(string), ":k2:") {
+		t.Error("expect value encrypted by the current key", raw["Name"])
+	}
+	loaded = &encryptedUser{}
+	if err := pool.Get("user", "1", loaded); err != nil || loaded.Name != "Alice" {
+		t.Error("expect value encrypted by the old key is still readable", loaded, err)
+	}
+
+	//equality on deterministic field match values of every key
+	query := engine.MakeDBQuery("user", false)
+	query.Filter("Email", "in", []string{"alice@example.com", "carol@example.com"})
+	result := pool.Query(query)
+	if result.Error() != nil || result.Count() != 2 {
+		t.Error("expect 2 documents", result.Error(), result.Count())
+	}
+	query = engine.MakeDBQuery("user", true)
+	query.Filter("Email", "=", "alice@example.com")
+	loaded = &encryptedUser{}
+	if err := pool.Query(query).GetOne(loaded); err != nil || loaded.ID != "1" || loaded.Name != "Alice" {
+		t.Error("expect alice", loaded, err)
+	}
+	query = engine.MakeDBQuery("user", false)
+	query.Filter("Email", "!=", "alice@example.com")
+	if count := pool.Query(query).Count(); count != 1 {
+		t.Error("expect 1 document", count)
+	}
+	query = engine.MakeDBQuery("user", false)
+	query.Filter("Name", "=", "Alice")
+	if err := pool.Query(query).Error(); !errors.Is(err, engine.EncryptedFieldOperation) {
+		t.Error("expect random field can not be queried", err)
+	}
+
+	//update
+	update := engine.MakeUpdate()
+	update.Set("Name", "Alicia")
+	if err := pool.Update("user", "1", update); err != nil {
+		t.Error(err)
+	}
+	update = engine.MakeUpdate()
+	update.Push("Name", "x")
+	if err := pool.Update("user", "1", update); !errors.Is(err, engine.EncryptedFieldOperation) {
+		t.Error("expect push on encrypted field fail", err)
+	}
+
+	//transaction
+	err := pool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		current := &encryptedUser{}
+		if err := tx.Get("user", "1", current); err != nil {
+			return err
+		}
+		if current.Name != "Alicia" {
+			t.Error("expect decrypted document in transaction", current)
+		}
+		current.ID = "4"
+		tx.Put("user", current)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	raw = map[string]interface{}{}
+	db.Get("user", "4", &raw)
+	if !engine.IsEncrypted(raw["Name"].(string)) {
+		t.Error("expect transaction write is encrypted", raw)
+	}
+
+	//rekey
+	count, err := encryption.Rekey(pool, engine.MakeDBQuery("user", false), func() engine.Document { return &encryptedUser{} })
+	if err != nil || count != 3 {
+		t.Error("expect 3 documents are rekeyed", count, err)
+	}
+	raw = map[string]interface{}{}
+	db.Get("user", "1", &raw)
+	if !strings.Contains(raw["Email"].(string), ":k2:") {
+		t.Error("expect value encrypted by the current key after rekey", raw["Email"])
+	}
+
+	//a tampered value fail to decrypt
+	raw["Name"] = strings.Replace(raw["Name"].(string), ":k2:", ":k1:", 1)
+	db.PutRaw("user", "1", raw)
+	if err := pool.Get("user", "1", &encryptedUser{}); !errors.Is(err, engine.InvalidCiphertext) {
+		t.Error("expect invalid ciphertext", err)
+	}
+}
+
+func TestFieldEncryptionRestart(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	keys := engine.NewKeyRing()
+	keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+
+	//a process that just started has not put a user yet
+	encryption := engine.NewFieldEncryption(keys)
+	pool := engine.Chain(db, engine.EncryptFieldsDocumentPool(encryption))
+
+	update := engine.Update{}
+	update.Set("Name", "Alice")
+	if err := pool.Update("user", "1", update); !errors.Is(err, engine.UnregisteredEncryptedCollection) {
+		t.Error("expect update of unregistered collection is rejected", err)
+	}
+	query := engine.MakeDBQuery("user", false)
+	query.Filter("Email", "=", "alice@example.com")
+	if err := pool.Query(query).Error(); !errors.Is(err, engine.UnregisteredEncryptedCollection) {
+		t.Error("expect query of unregistered collection is rejected", err)
+	}
+	if err := pool.PutRaw("user", "1", map[string]interface{}{"ID": "1", "Name": "Alice"}); !errors.Is(err, engine.UnregisteredEncryptedCollection) {
+		t.Error("expect map of unregistered collection is rejected", err)
+	}
+
+	if err := encryption.Register("user", &encryptedUser{}); err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]interface{}{"ID": "1", "Name": "Alice", "Email": "alice@example.com", "Address": map[string]interface{}{"City": "Hanoi", "Street": "1 Trang Tien"}}
+	if err := pool.PutRaw("user", "1", document); err != nil {
+		t.Fatal(err)
+	}
+	if document["Name"] != "Alice" || document["Address"].(map[string]interface{})["Street"] != "1 Trang Tien" {
+		t.Error("expect map of caller is not modified", document)
+	}
+	raw := map[string]interface{}{}
+	db.Get("user", "1", &raw)
+	address, _ := raw["Address"].(map[string]interface{})
+	if !engine.IsEncrypted(raw["Name"].(string)) || !engine.IsEncrypted(raw["Email"].(string)) || address == nil || !engine.IsEncrypted(address["Street"].(string)) {
+		t.Error("expect encrypted fields of map in database", raw)
+	}
+	if err := pool.Update("user", "1", update); err != nil {
+		t.Error(err)
+	}
+	user := &encryptedUser{}
+	query.SelectOne = true
+	if err := pool.Query(query).GetOne(user); err != nil || user.Name != "Alice" || user.Address.Street != "1 Trang Tien" {
+		t.Error("expect map document is found and decrypted", user, err)
+	}
+	if err := pool.PutRaw("user", "2", map[string]interface{}{"ID": "2", "Name": 2}); !errors.Is(err, engine.UnsupportedEncryptedField) {
+		t.Error("expect value that can not be encrypted is rejected", err)
+	}
+
+	if err := encryption.Register("log", struct{ Message string }{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.PutRaw("log", "1", map[string]interface{}{"Message": "hello"}); err != nil {
+		t.Error("expect collection without encrypted fields is written", err)
+	}
+
+	//queries without filters do not need the collection to be registered, maps with encrypted values do
+	restarted := engine.Chain(db, engine.EncryptFieldsDocumentPool(engine.NewFieldEncryption(keys)))
+	result := restarted.Query(engine.MakeDBQuery("log", false))
+	message := map[string]interface{}{}
+	if err := result.Next(&message); err != nil || message["Message"] != "hello" {
+		t.Error("expect query without filter on unregistered collection", message, err)
+	}
+	result.Close()
+	if err := restarted.Get("user", "1", &map[string]interface{}{}); !errors.Is(err, engine.UnregisteredEncryptedCollection) {
+		t.Error("expect map with encrypted values of unregistered collection is rejected", err)
+	}
+	if count, err := restarted.DeleteWhere(engine.MakeDBQuery("log", false)); err != nil || count != 1 {
+		t.Error("expect delete without filter on unregistered collection", count, err)
+	}
+}
+
+//bsonDocDB decode maps into bson.M at every level like MongoPool
+type bsonDocDB struct {
+	*adapter.LocalDocDB
+}
+
+func toBSON(document map[string]interface{}) bson.M {
+
+	converted := bson.M{}
+	for key, value := range document {
+		if child, ok := value.(map[string]interface{}); ok {
+			value = toBSON(child)
+		}
+		converted[key] = value
+	}
+	return converted
+}
+
+func (db *bsonDocDB) Get(collection string, id string, document interface{}) error {
+
+	if err := db.LocalDocDB.Get(collection, id, document); err != nil {
+		return err
+	}
+	if documentMap, ok := document.(*bson.M); ok {
+		*documentMap = toBSON(*documentMap)
+	}
+	return nil
+}
+
+func TestFieldEncryptionBSON(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	keys := engine.NewKeyRing()
+	keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+	encryption := engine.NewFieldEncryption(keys)
+	encryption.Register("user", &encryptedUser{})
+	pool := engine.Chain(&bsonDocDB{LocalDocDB: db}, engine.EncryptFieldsDocumentPool(encryption))
+
+	document := bson.M{"ID": "1", "Name": "Alice", "Address": bson.M{"City": "Hanoi", "Street": "1 Trang Tien"}}
+	if err := pool.PutRaw("user", "1", document); err != nil {
+		t.Fatal(err)
+	}
+	raw := map[string]interface{}{}
+	db.Get("user", "1", &raw)
+	address, _ := raw["Address"].(map[string]interface{})
+	if !engine.IsEncrypted(raw["Name"].(string)) || address == nil || !engine.IsEncrypted(address["Street"].(string)) {
+		t.Error("expect encrypted fields of bson.M in database", raw)
+	}
+
+	read := bson.M{}
+	if err := pool.Get("user", "1", &read); err != nil {
+		t.Fatal(err)
+	}
+	readAddress, _ := read["Address"].(bson.M)
+	if read["Name"] != "Alice" || readAddress == nil || readAddress["Street"] != "1 Trang Tien" {
+		t.Error("expect bson.M is decrypted", read)
+	}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	engines "github.com/tapvanvn/godbengine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestNewFromConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "godbengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("TEST_ENGINE_FILE_ROOT", dir)
+	defer os.Unsetenv("TEST_ENGINE_FILE_ROOT")
+
+	path := filepath.Join(dir, "engine.yaml")
+	ioutil.WriteFile(path, []byte(`
+document:
+  default:
+    url: mem://
+  analytics:
+    adapter: mem
+mem:
+  session:
+    adapter: mem
+    settings:
+      clients: 2
+file:
+  default:
+    adapter: file
+    database: ${TEST_ENGINE_FILE_ROOT}
+`), 0644)
+
+	eng, err := engines.NewFromConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close(context.Background())
+
+	if eng.GetDocumentPool() == nil || eng.GetDocumentPoolNamed("analytics") == nil || eng.GetDocumentPool() == eng.GetDocumentPoolNamed("analytics") {
+		t.Error("expect 2 document pools")
+	}
+	if eng.GetMemPool() == nil || eng.GetMemPool() != eng.GetMemPoolNamed("session") {
+		t.Error("expect the only mem pool is default")
+	}
+	if _, ok := eng.GetFilePool().(*adapter.FileClient); !ok {
+		t.Error("expect file client")
+	}
+	if health := eng.Health(context.Background()); !health.Healthy || len(health.Pools) != 4 {
+		t.Error("expect 4 healthy pools", health)
+	}
+
+	config, err := engines.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools, err := engines.OpenPools(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools.Document) != 2 || pools.Document["analytics"] == nil || pools.Mem["session"] == nil {
+		t.Error("expect every pool by name", pools)
+	}
+	if err := pools.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	config, err := engines.ParseConfig([]byte(`
+document:
+  main:
+    adapter: redis
+  other:
+    url: mem://
+    hosts: [localhost]
+mem:
+  cache:
+    adapter: unknown
+file:
+  default: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = config.Validate()
+
+	configErr := &engines.ConfigError{}
+	if !errors.Is(err, engines.ErrInvalidConfig) || !errors.As(err, &configErr) || len(configErr.Problems) != 5 {
+		t.Fatal("expect 5 problems", err)
+	}
+	for _, expect := range []string{"document.main: adapter \"redis\"", "document.other: url can not", "document: one of 2 pools", "mem.cache: unknown adapter", "file.default: url or adapter"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Error("expect problem", expect)
+		}
+	}
+	if _, err := engines.ParseConfig([]byte("documents: {}")); !errors.Is(err, engines.ErrInvalidConfig) {
+		t.Error("expect unknown field", err)
+	}
+	if _, err := engines.NewFromConfig(config); !errors.Is(err, engines.ErrInvalidConfig) {
+		t.Error("expect engine is not built", err)
+	}
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	engines "github.com/tapvanvn/godbengine"
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func newLocalEngine() *engine.Engine {
+
+	memPool := &adapter.LocalMemDB{}
+	memPool.Init("")
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+	return engine.NewEngine(memPool, documentPool, nil)
+}
+
+func TestIndependentEngines(t *testing.T) {
+
+	var wait sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+
+			eng := newLocalEngine()
+			ctx := engine.WithEngine(context.Background(), eng)
+
+			engine.FromContext(ctx).GetMemPool().Set("key", fmt.Sprint(i))
+
+			if value, _ := eng.GetMemPool().Get("key"); value != fmt.Sprint(i) {
+				t.Error("expect engines are independent", i, value)
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	if engine.FromContext(context.Background()) != nil {
+		t.Error("expect no engine in context")
+	}
+}
+
+func TestEngineRegistry(t *testing.T) {
+
+	eng := newLocalEngine()
+	engines.RegisterEngine("test_registry", eng)
+	defer engines.RegisterEngine("test_registry", nil)
+
+	if engines.GetEngineNamed("test_registry") != eng || engines.GetEngineNamed("missing") != nil {
+		t.Error("unexpected named engine")
+	}
+
+	ctx := engine.WithEngine(context.Background(), eng)
+	if engines.FromContext(ctx) != eng || engines.FromContext(context.Background()) != engines.GetEngine() {
+		t.Error("expect context engine or default engine")
+	}
+
+	var wait sync.WaitGroup
+	found := make([]*engine.Engine, 8)
+	for i := range found {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			found[i] = engines.GetEngine()
+		}(i)
+	}
+	wait.Wait()
+
+	for _, other := range found {
+		if other != found[0] {
+			t.Error("expect one default engine")
+		}
+	}
+}
+
+//InitEngineFunc can use the registry while the default engine is made
+func TestInitEngineFuncRegistry(t *testing.T) {
+
+	named := newLocalEngine()
+	initFunc := engines.InitEngineFunc
+
+	engines.SetEngine(nil)
+	engines.InitEngineFunc = func(eng *engine.Engine) {
+
+		engines.RegisterEngine("test_init", named)
+		if engines.GetEngineNamed("test_init") != named {
+			t.Error("expect named engine is registered")
+		}
+		engines.FromContext(engine.WithEngine(context.Background(), named))
+	}
+	done := make(chan *engine.Engine)
+	go func() { done <- engines.GetEngine() }()
+
+	select {
+	case eng := <-done:
+		if eng == nil || eng == named {
+			t.Error("expect a new default engine", eng)
+		}
+	case <-time.After(time.Second):
+		//the registry is locked forever, it can not be restored
+		t.Fatal("GetEngine deadlocked")
+	}
+	engines.InitEngineFunc = initFunc
+	engines.SetEngine(nil)
+	engines.RegisterEngine("test_init", nil)
+}
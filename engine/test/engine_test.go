@@ -0,0 +1,50 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestEngineHealth(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "godbengine")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	memPool := &adapter.LocalMemDB{}
+	memPool.Init("")
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+	filePool, err := adapter.NewFileClient(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	eng := &engine.Engine{}
+	eng.Init(memPool, documentPool, filePool)
+
+	health := eng.Health(context.Background())
+	if !health.Healthy || len(health.Pools) != 3 {
+		t.Error("expect healthy", health)
+	}
+	os.RemoveAll(dir)
+
+	health = eng.Health(context.Background())
+	if health.Healthy || health.Pools[2].Pool != "file" || health.Pools[2].Healthy {
+		t.Error("expect file pool unhealthy", health)
+	}
+	if err := eng.Ping(context.Background()); err == nil {
+		t.Error("expect ping error")
+	}
+	if err := eng.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,11 +1,15 @@
 package test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/tapvanvn/godbengine/engine"
 	adapter "github.com/tapvanvn/godbengine/engine/adapter"
 )
 
@@ -57,3 +61,378 @@ func TestFileDocDB(t *testing.T) {
 		return
 	}
 }
+
+func TestFileDocDBUpdate(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doc := &testStruct{
+		ID:     11,
+		Number: 10,
+	}
+	err = __file_docdb.Put("test_collection", doc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.Del("test_collection", "11")
+
+	update := engine.MakeUpdate()
+	update.Inc("Number", 5)
+	update.AddToSet("Tags", "a")
+	update.AddToSet("Tags", "a")
+	update.Push("Tags", "b")
+	update.Max("Best", 3)
+	update.Max("Best", 1)
+
+	err = __file_docdb.Update("test_collection", "11", update)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := map[string]interface{}{}
+	err = __file_docdb.Get("test_collection", "11", &result)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result["Number"] != float64(15) || result["Best"] != float64(3) {
+		t.Error(result)
+	}
+	if tags, ok := result["Tags"].([]interface{}); !ok || len(tags) != 2 {
+		t.Error(result["Tags"])
+	}
+}
+
+func TestFileDocDBWhere(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := int64(20); i < 25; i++ {
+		if err := __file_docdb.Put("test_where", &testStruct{ID: i, Number: i}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	defer __file_docdb.DelCollection("test_where")
+
+	query := engine.MakeDBQuery("test_where", false)
+	query.Filter("Number", ">=", 22)
+
+	update := engine.MakeUpdate()
+	update.Set("Big", true)
+
+	count, err := __file_docdb.UpdateWhere(query, update)
+	if err != nil || count != 3 {
+		t.Error(count, err)
+		return
+	}
+	query = engine.MakeDBQuery("test_where", false)
+	query.Filter("Big", "=", true)
+	query.Filter("Number", "in", []int64{20, 23, 24})
+
+	count, err = __file_docdb.DeleteWhere(query)
+	if err != nil || count != 2 {
+		t.Error(count, err)
+		return
+	}
+	ids, err := __file_docdb.GetAllDocumentIDs("test_where")
+	if err != nil || len(ids) != 3 {
+		t.Error(ids, err)
+	}
+}
+
+//DelCollection remove the folder of a collection that has documents and reject names those escape the root folder
+func TestFileDocDBDelCollection(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := __file_docdb.Put("test_del_collection", &testStruct{ID: 1, Number: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := __file_docdb.DelCollection("test_del_collection"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := os.Stat(__file_docdb.GetCollectionPath("test_del_collection")); !os.IsNotExist(err) {
+		t.Error("expect collection folder is removed", err)
+	}
+	for _, collection := range []string{"", ".", "..", "../test", "a/b", ".journal", ".indexes"} {
+		if err := __file_docdb.DelCollection(collection); !errors.Is(err, adapter.ErrDBEngineInvalidPath) {
+			t.Error("expect invalid path", collection, err)
+		}
+	}
+	if err := __file_docdb.Put(".indexes", &testStruct{ID: 1, Number: 1}); !errors.Is(err, adapter.ErrDBEngineInvalidPath) {
+		t.Error("expect reserved collection", err)
+	}
+	if err := __file_docdb.Del(".journal", "1"); !errors.Is(err, adapter.ErrDBEngineInvalidPath) {
+		t.Error("expect reserved collection", err)
+	}
+	if result := __file_docdb.Query(engine.MakeDBQuery(".indexes", false)); !errors.Is(result.Error(), adapter.ErrDBEngineInvalidPath) {
+		t.Error("expect reserved collection", result.Error())
+	}
+	if err := __file_docdb.EnsureIndex(".journal", engine.IndexSpec{Fields: []engine.IndexField{{Field: "Number"}}}); !errors.Is(err, adapter.ErrDBEngineInvalidPath) {
+		t.Error("expect reserved collection", err)
+	}
+	transaction := __file_docdb.MakeTransaction()
+	transaction.Begin()
+	transaction.DelCollection(".journal")
+	if err := transaction.Commit(); !errors.Is(err, adapter.ErrDBEngineInvalidPath) {
+		t.Error("expect reserved collection", err)
+	}
+}
+
+func TestFileDocDBUniqueIndex(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.DelCollection("test_index")
+
+	index := engine.MakeIndexSpec("Number")
+	index.Unique = true
+
+	if err := __file_docdb.EnsureIndex("test_index", index); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := __file_docdb.Put("test_index", &testStruct{ID: 1, Number: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	err = __file_docdb.Put("test_index", &testStruct{ID: 2, Number: 1})
+	if !errors.Is(err, engine.DuplicateKey) {
+		t.Error("expect duplicate key", err)
+	}
+}
+
+func TestFileDocDBTTLIndex(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	index := engine.MakeIndexSpec("CreatedAt")
+	index.TTL = true
+	index.ExpireAfter = time.Hour
+
+	if err := __file_docdb.EnsureIndex("test_ttl", index); !errors.Is(err, engine.InvalidIndex) {
+		t.Error("expect ttl index is not supported", err)
+	}
+}
+
+func TestFileDocDBTransactionRollback(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.DelCollection("test_transaction")
+
+	if err := __file_docdb.Put("test_transaction", &testStruct{ID: 1, Number: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	transaction := __file_docdb.MakeTransaction()
+	transaction.Put("test_transaction", &testStruct{ID: 1, Number: 2})
+	transaction.Put("test_transaction", &testStruct{ID: 2, Number: 2})
+	update := engine.MakeUpdate()
+	update.Inc("Number", "not a number")
+	transaction.Update("test_transaction", "2", update)
+
+	if err := transaction.Commit(); !errors.Is(err, adapter.ErrFileDocTransactionFail) {
+		t.Error("expect transaction fail", err)
+		return
+	}
+	doc := &testStruct{}
+	if err := __file_docdb.Get("test_transaction", "1", doc); err != nil || doc.Number != 1 {
+		t.Error("document 1 is not rolled back", doc, err)
+	}
+	if err := __file_docdb.Get("test_transaction", "2", doc); !__file_docdb.IsNoRecordError(err) {
+		t.Error("document 2 is not rolled back", err)
+	}
+}
+
+func TestFileDocDBJournalRecover(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.DelCollection("test_journal")
+
+	if err := __file_docdb.Put("test_journal", &testStruct{ID: 1, Number: 2}); err != nil {
+		t.Error(err)
+		return
+	}
+	//simulate a crash after document 1 was written by a transaction
+	journal := []byte(`{"id":"crash","backups":[{"path":"/test_journal/1.json","existed":true,"content":"eyJJRCI6MSwiTnVtYmVyIjoxfQ=="}]}`)
+	rootPath, _ := os.Getwd()
+	if err := os.MkdirAll(rootPath+"/.journal", 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(rootPath + "/.journal")
+	if err := os.WriteFile(rootPath+"/.journal/crash.json", journal, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := initFileDB(); err != nil {
+		t.Error(err)
+		return
+	}
+	doc := &testStruct{}
+	if err := __file_docdb.Get("test_journal", "1", doc); err != nil || doc.Number != 1 {
+		t.Error("document is not recovered", doc, err)
+	}
+	if _, err := os.Stat(rootPath + "/.journal/crash.json"); !os.IsNotExist(err) {
+		t.Error("journal is not removed", err)
+	}
+}
+
+func TestFileDocDBRunInTransaction(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.DelCollection("test_run_transaction")
+
+	__file_docdb.Put("test_run_transaction", &testStruct{ID: 1, Number: 5})
+
+	debit := func(amount int64) error {
+		return __file_docdb.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+			account := &testStruct{}
+			if err := tx.Get("test_run_transaction", "1", account); err != nil {
+				return err
+			}
+			if account.Number < amount {
+				return errors.New("insufficient")
+			}
+			account.Number -= amount
+			tx.Put("test_run_transaction", account)
+			return nil
+		})
+	}
+	if err := debit(3); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := debit(3); err == nil {
+		t.Error("expect insufficient")
+	}
+	account := &testStruct{}
+	if err := __file_docdb.Get("test_run_transaction", "1", account); err != nil || account.Number != 2 {
+		t.Error("expect 2", account, err)
+	}
+}
+
+func TestFileDocDBTransactionDelCollection(t *testing.T) {
+	err := initFileDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer __file_docdb.DelCollection("test_tenant_a")
+	defer __file_docdb.DelCollection("test_tenant_b")
+	defer __file_docdb.DelCollection("test_tenant_index")
+
+	__file_docdb.Put("test_tenant_a", &testStruct{ID: 1, Number: 1})
+	__file_docdb.Put("test_tenant_b", &testStruct{ID: 1, Number: 1})
+	__file_docdb.EnsureIndex("test_tenant_a", engine.MakeIndexSpec("Number"))
+
+	transaction := __file_docdb.MakeTransaction()
+	transaction.DelCollection("test_tenant_a")
+	transaction.DelCollection("test_tenant_b")
+	update := engine.MakeUpdate()
+	update.Inc("Number", "not a number")
+	transaction.Update("test_tenant_index", "1", update)
+
+	if err := transaction.Commit(); !errors.Is(err, adapter.ErrFileDocTransactionFail) {
+		t.Error("expect transaction fail", err)
+		return
+	}
+	if err := __file_docdb.Get("test_tenant_a", "1", &testStruct{}); err != nil {
+		t.Error("collection is not restored", err)
+	}
+	if indexes, err := __file_docdb.ListIndexes("test_tenant_a"); err != nil || len(indexes) != 1 {
+		t.Error("index is not restored", indexes, err)
+	}
+	transaction = __file_docdb.MakeTransaction()
+	transaction.DelCollection("test_tenant_a")
+	transaction.DelCollection("test_tenant_b")
+
+	if err := transaction.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+	if ids, err := __file_docdb.GetAllDocumentIDs("test_tenant_b"); !os.IsNotExist(err) {
+		t.Error("collection is not deleted", ids, err)
+	}
+}
+
+func nextChangeEvent(t *testing.T, events <-chan engine.ChangeEvent) engine.ChangeEvent {
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect change event")
+	}
+	return engine.ChangeEvent{}
+}
+
+func TestFileDocDBWatch(t *testing.T) {
+
+	db := &adapter.FileDocDB{WatchInterval: 10 * time.Millisecond}
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	db.Put("test", &testStruct{ID: 1, Number: 1})
+	db.Put("test", &testStruct{ID: 2, Number: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := engine.MakeDBQuery("test", false)
+	query.Filter("Number", ">", 1)
+
+	events := db.Watch(ctx, "test", &query, "")
+	//let the first poll read the documents those exist
+	time.Sleep(100 * time.Millisecond)
+
+	db.Del("test", "1")
+	db.Del("test", "2")
+
+	event := nextChangeEvent(t, events)
+	if event.Type != engine.ChangeDelete || event.ID != "2" {
+		t.Error("expect delete of the document that matched query", event)
+	}
+
+	db.Put("test", &testStruct{ID: 3, Number: 0})
+	db.Put("test", &testStruct{ID: 4, Number: 4})
+
+	event = nextChangeEvent(t, events)
+	if event.Type != engine.ChangeInsert || event.ID != "4" {
+		t.Error("expect insert of the document that match query", event)
+	}
+	doc := &testStruct{}
+	if err := event.Decode(doc); err != nil || doc.Number != 4 {
+		t.Error(doc, err)
+	}
+	db.Del("test", "3")
+	db.Del("test", "4")
+
+	event = nextChangeEvent(t, events)
+	if event.Type != engine.ChangeDelete || event.ID != "4" {
+		t.Error("expect delete of the inserted document", event)
+	}
+}
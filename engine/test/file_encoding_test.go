@@ -0,0 +1,118 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestFileEncoding(t *testing.T) {
+
+	client, err := adapter.NewFileClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := engine.NewKeyRing()
+	keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+
+	plain := []byte("plain file written before encoding")
+	if err := client.Write("/old.txt", &plain); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(strings.Repeat("compressible content ", 100))
+
+	for _, codec := range []engine.FileCodec{engine.FileCodecNone, engine.FileCodecGzip, engine.FileCodecZstd} {
+
+		pool := engine.ChainFilePool(client, engine.EncodeFilePool(engine.FileEncodingOptions{Codec: codec, Keys: keys}))
+
+		if err := pool.Write("/new.txt", &content); err != nil {
+			t.Fatal(codec, err)
+		}
+		stored, _ := client.Read("/new.txt")
+		if bytes.Contains(*stored, []byte("compressible")) {
+			t.Error("expect encrypted content on disk", codec)
+		}
+		if codec != engine.FileCodecNone && len(*stored) >= len(content) {
+			t.Error("expect compressed content", codec, len(*stored))
+		}
+		read, err := pool.Read("/new.txt")
+		if err != nil || !bytes.Equal(*read, content) {
+			t.Error("expect decoded content", codec, err)
+		}
+		read, err = pool.Read("/old.txt")
+		if err != nil || !bytes.Equal(*read, plain) {
+			t.Error("expect unencoded file is read as it is", codec, err)
+		}
+	}
+
+	//compression only, then a rotated key still read files of the old key
+	compressed := engine.ChainFilePool(client, engine.EncodeFilePool(engine.FileEncodingOptions{Codec: engine.FileCodecGzip}))
+	if err := compressed.Write("/gzip.txt", &content); err != nil {
+		t.Fatal(err)
+	}
+	keys.Rotate("k2", []byte("fedcba9876543210fedcba9876543210"))
+	pool := engine.ChainFilePool(client, engine.EncodeFilePool(engine.FileEncodingOptions{Codec: engine.FileCodecZstd, Keys: keys}))
+
+	for _, path := range []string{"/gzip.txt", "/new.txt"} {
+		if read, err := pool.Read(path); err != nil || !bytes.Equal(*read, content) {
+			t.Error("expect decoded content", path, err)
+		}
+	}
+	if _, err := compressed.Read("/new.txt"); !errors.Is(err, engine.EncryptionKeyNotFound) {
+		t.Error("expect encrypted file need keys", err)
+	}
+
+	//a tampered file fail to decrypt
+	stored, _ := client.Read("/new.txt")
+	(*stored)[len(*stored)-1] ^= 1
+	client.Write("/new.txt", stored)
+	if _, err := pool.Read("/new.txt"); !errors.Is(err, engine.InvalidCiphertext) {
+		t.Error("expect invalid ciphertext", err)
+	}
+}
+
+func TestFileEncodingLimits(t *testing.T) {
+
+	client, err := adapter.NewFileClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := engine.NewKeyRing()
+	keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+
+	content := []byte(strings.Repeat("compressible content ", 100))
+	pool := engine.ChainFilePool(client, engine.EncodeFilePool(engine.FileEncodingOptions{Codec: engine.FileCodecGzip, Keys: keys}))
+	if err := pool.Write("/a/secret.txt", &content); err != nil {
+		t.Fatal(err)
+	}
+
+	//an encrypted file that is copied to another path fail to decrypt
+	stored, _ := client.Read("/a/secret.txt")
+	client.Write("/b/secret.txt", stored)
+	if _, err := pool.Read("/b/secret.txt"); !errors.Is(err, engine.InvalidCiphertext) {
+		t.Error("expect file copied to other path is rejected", err)
+	}
+
+	for _, codec := range []engine.FileCodec{engine.FileCodecGzip, engine.FileCodecZstd} {
+
+		pool := engine.ChainFilePool(client, engine.EncodeFilePool(engine.FileEncodingOptions{Codec: codec, MaxDecodedSize: int64(len(content))}))
+		if err := pool.Write("/limit.txt", &content); err != nil {
+			t.Fatal(codec, err)
+		}
+		if read, err := pool.Read("/limit.txt"); err != nil || !bytes.Equal(*read, content) {
+			t.Error("expect content of the limit size is read", codec, err)
+		}
+
+		large := append(content, 'x')
+		if err := pool.Write("/limit.txt", &large); err != nil {
+			t.Fatal(codec, err)
+		}
+		if _, err := pool.Read("/limit.txt"); !errors.Is(err, engine.DecodedFileTooLarge) {
+			t.Error("expect content larger than the limit is rejected", codec, err)
+		}
+	}
+}
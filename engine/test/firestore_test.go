@@ -1,6 +1,8 @@
 package test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -187,3 +189,47 @@ func TestFirestoreQueryPaging(t *testing.T) {
 		fmt.Println()
 	}
 }
+
+//newOfflineFirestorePool make a pool that point to an emulator that is not running, operations fail before sending a request are testable
+func newOfflineFirestorePool(t *testing.T) *adapter.FirestorePool {
+
+	os.Setenv("FIRESTORE_EMULATOR_HOST", "127.0.0.1:1")
+	defer os.Unsetenv("FIRESTORE_EMULATOR_HOST")
+
+	pool := &adapter.FirestorePool{}
+	if err := pool.Init("offline-project"); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestFirestoreUpdatePush(t *testing.T) {
+
+	pool := newOfflineFirestorePool(t)
+	defer pool.Close(context.Background())
+
+	update := engine.MakeUpdate()
+	update.Push("Tags", "a")
+
+	if err := pool.Update("test", "1", update); !errors.Is(err, engine.NotImplement) {
+		t.Error("expect push is not implemented on firestore", err)
+	}
+}
+
+func TestFirestoreDropIndex(t *testing.T) {
+
+	pool := newOfflineFirestorePool(t)
+	defer pool.Close(context.Background())
+
+	if err := pool.DropIndex("test", "Number_1"); err != nil {
+		t.Error("expect dropping an index that was not ensured succeed", err)
+	}
+	pool.EnsureIndex("test", engine.MakeIndexSpec("Number"))
+
+	if err := pool.DropIndex("test", "Number_1"); err != nil {
+		t.Error(err)
+	}
+	if indexes, _ := pool.ListIndexes("test"); len(indexes) != 0 {
+		t.Error("expect index is dropped", indexes)
+	}
+}
@@ -0,0 +1,34 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestInitError(t *testing.T) {
+
+	for _, connectionString := range []string{"localhost:6379[x]", "localhost:6379[2", "password@localhost:6379/db", "password@"} {
+
+		pool := &adapter.RedisPool{}
+		if err := pool.Init(connectionString); !errors.Is(err, adapter.ErrInvalidConnectionString) {
+			t.Error("expect invalid connection string", connectionString, err)
+		}
+	}
+	mongoPool := &adapter.MongoPool{}
+	if err := mongoPool.Init("localhost:27017"); !errors.Is(err, adapter.ErrInvalidConnectionString) {
+		t.Error("expect invalid connection string", err)
+	}
+	pool := &adapter.RedisPool{PingTimeout: 200 * time.Millisecond}
+	if err := pool.Init("127.0.0.1:1"); !errors.Is(err, adapter.ErrConnect) {
+		t.Error("expect connect error", err)
+	}
+	fileDB := &adapter.FileDocDB{}
+	err := fileDB.Init("/not/existed/path")
+	if !errors.Is(err, adapter.ErrInvalidConnectionString) || !errors.Is(err, os.ErrNotExist) {
+		t.Error("expect invalid connection string", err)
+	}
+}
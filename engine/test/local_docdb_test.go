@@ -0,0 +1,218 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestLocalDocDBUniqueIndex(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	index := engine.MakeIndexSpec("Number")
+	index.Unique = true
+
+	if err := db.EnsureIndex("test", index); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Put("test", &testStruct{ID: 1, Number: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Put("test", &testStruct{ID: 1, Number: 1}); err != nil {
+		t.Error("put same document again", err)
+	}
+	err := db.Put("test", &testStruct{ID: 2, Number: 1})
+	if !errors.Is(err, engine.DuplicateKey) {
+		t.Error("expect duplicate key", err)
+	}
+	indexes, err := db.ListIndexes("test")
+	if err != nil || len(indexes) != 1 || indexes[0].GetName() != "Number_1" {
+		t.Error(indexes, err)
+	}
+}
+
+func TestLocalDocDBQuery(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	for i := int64(0); i < 10; i++ {
+		db.Put("test", &testStruct{ID: i, Number: i % 5})
+	}
+	query := engine.MakeDBQuery("test", false)
+	query.Filter("Number", ">", 1)
+	query.Sort("Number", false)
+	query.Paging(0, 4)
+
+	result := db.Query(query)
+	defer result.Close()
+
+	if result.Error() != nil || result.Count() != 6 {
+		t.Error(result.Error(), result.Count())
+		return
+	}
+	numbers := []int64{}
+	for {
+		doc := &testStruct{}
+		if err := result.Next(doc); err != nil {
+			break
+		}
+		numbers = append(numbers, doc.Number)
+	}
+	if len(numbers) != 4 || numbers[0] != 4 || numbers[3] != 3 {
+		t.Error(numbers)
+	}
+}
+
+func TestLocalDocDBWatch(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := engine.MakeDBQuery("test", false)
+	query.Filter("Number", ">", 1)
+
+	events := db.Watch(ctx, "test", &query, "")
+
+	db.Put("test", &testStruct{ID: 1, Number: 1})
+	db.Put("test", &testStruct{ID: 2, Number: 2})
+	db.Put("other", &testStruct{ID: 3, Number: 3})
+	db.Del("test", "1")
+	db.Del("test", "2")
+
+	event := <-events
+	if event.Type != engine.ChangeInsert || event.ID != "2" {
+		t.Error(event)
+		return
+	}
+	doc := &testStruct{}
+	if err := event.Decode(doc); err != nil || doc.Number != 2 {
+		t.Error(doc, err)
+	}
+	event = <-events
+	if event.Type != engine.ChangeDelete || event.ID != "2" {
+		t.Error(event)
+	}
+	//resume after the delete of 1 replay the delete of 2
+	resumed := db.Watch(ctx, "test", nil, "4")
+	event = <-resumed
+	if event.Type != engine.ChangeDelete || event.ID != "2" {
+		t.Error(event)
+	}
+}
+
+func TestLocalDocDBRunInTransaction(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	db.Put("account", &testStruct{ID: 1, Number: 0})
+
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			done <- db.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+				account := &testStruct{}
+				if err := tx.Get("account", "1", account); err != nil {
+					return err
+				}
+				account.Number++
+				tx.Put("account", account)
+				return nil
+			})
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	account := &testStruct{}
+	if err := db.Get("account", "1", account); err != nil || account.Number != 10 {
+		t.Error("expect 10", account, err)
+	}
+	errInsufficient := errors.New("insufficient")
+	err := db.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+		tx.Put("account", &testStruct{ID: 2, Number: 1})
+
+		query := engine.MakeDBQuery("account", true)
+		query.Filter("Number", ">", 100)
+		if err := tx.Query(query).GetOne(&testStruct{}); err != nil {
+			return errInsufficient
+		}
+		return nil
+	})
+	if err != errInsufficient {
+		t.Error("expect insufficient", err)
+	}
+	if err := db.Get("account", "2", &testStruct{}); !db.IsNoRecordError(err) {
+		t.Error("write of failed transaction is committed", err)
+	}
+}
+
+func TestLocalDocDBTransactionRollback(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	transaction := db.MakeTransaction(&engine.TransactionOptions{ReadConcern: engine.ReadConcernSnapshot})
+	transaction.Put("test", &testStruct{ID: 1, Number: 1})
+	savepoint := transaction.Savepoint()
+	transaction.Put("test", &testStruct{ID: 2, Number: 2})
+	transaction.Del("test", "1")
+
+	if err := transaction.RollbackTo(savepoint); err != nil {
+		t.Error(err)
+		return
+	}
+	items := transaction.Items()
+	if transaction.Len() != 1 || items[0].Command != engine.TransactionPut || items[0].ID != "1" {
+		t.Error("unexpected items", items)
+		return
+	}
+	if err := transaction.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Get("test", "2", &testStruct{}); !db.IsNoRecordError(err) {
+		t.Error("item after savepoint is committed", err)
+	}
+	transaction = db.MakeTransaction()
+	transaction.Del("test", "1")
+	transaction.Rollback()
+
+	if err := transaction.Commit(); err != engine.TransactionClosed {
+		t.Error("expect transaction closed", err)
+	}
+	if err := db.Get("test", "1", &testStruct{}); err != nil {
+		t.Error("rolled back delete is committed", err)
+	}
+}
+
+func TestLocalDocDBTTLIndex(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	index := engine.MakeIndexSpec("CreatedAt")
+	index.TTL = true
+	index.ExpireAfter = time.Hour
+
+	if err := db.EnsureIndex("test", index); !errors.Is(err, engine.InvalidIndex) {
+		t.Error("expect ttl index is not supported", err)
+	}
+	if indexes, _ := db.ListIndexes("test"); len(indexes) != 0 {
+		t.Error("expect index is not recorded", indexes)
+	}
+}
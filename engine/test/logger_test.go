@@ -0,0 +1,62 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+type recordLogger struct {
+	mux      sync.Mutex
+	messages []string
+}
+
+func (logger *recordLogger) record(msg string) {
+
+	logger.mux.Lock()
+	logger.messages = append(logger.messages, msg)
+	logger.mux.Unlock()
+}
+
+func (logger *recordLogger) Debug(msg string, keyvals ...interface{}) { logger.record(msg) }
+func (logger *recordLogger) Info(msg string, keyvals ...interface{})  { logger.record(msg) }
+func (logger *recordLogger) Warn(msg string, keyvals ...interface{})  { logger.record(msg) }
+func (logger *recordLogger) Error(msg string, keyvals ...interface{}) { logger.record(msg) }
+
+func TestLogger(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "godbengine")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filePool, err := adapter.NewFileClient(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	content := []byte("content")
+	if err := filePool.Write("/silent.txt", &content); err != nil {
+		t.Error(err)
+		return
+	}
+
+	logger := &recordLogger{}
+	eng := &engine.Engine{}
+	eng.SetLogger(logger)
+	eng.Init(nil, nil, filePool)
+
+	if err := filePool.Write("/logged.txt", &content); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(logger.messages) != 1 || logger.messages[0] != "file write" {
+		t.Error("expect one file write log", logger.messages)
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+//countGet count Get of pool and of its transactions
+type countGet struct {
+	*engine.DocumentPoolWrapper
+	count *int
+}
+
+func (pool *countGet) Get(collection string, id string, document interface{}) error {
+
+	*pool.count++
+	return pool.DocumentPoolWrapper.Get(collection, id, document)
+}
+
+type countGetTransaction struct {
+	engine.DBTransaction
+	count *int
+}
+
+func (tx *countGetTransaction) Get(collection string, id string, document interface{}) error {
+
+	*tx.count++
+	return tx.DBTransaction.Get(collection, id, document)
+}
+
+//denyQueryResult fail every query result
+type denyQueryResult struct {
+	engine.DBQueryResult
+}
+
+var errDenied = errors.New("denied")
+
+func (result denyQueryResult) Error() error {
+
+	return errDenied
+}
+
+func TestMiddleware(t *testing.T) {
+
+	db := &limitedDocDB{}
+	db.Init("")
+
+	order := []string{}
+	count := 0
+	transactions := 0
+
+	named := func(name string) engine.DocumentPoolMiddleware {
+		return func(next engine.DocumentPool) engine.DocumentPool {
+			order = append(order, name)
+			return next
+		}
+	}
+	counting := func(next engine.DocumentPool) engine.DocumentPool {
+
+		wrapper := engine.NewDocumentPoolWrapper(next)
+		wrapper.WrapTransaction = func(tx engine.DBTransaction) engine.DBTransaction {
+			transactions++
+			return &countGetTransaction{DBTransaction: tx, count: &count}
+		}
+		wrapper.WrapQueryResult = func(result engine.DBQueryResult) engine.DBQueryResult {
+			return denyQueryResult{result}
+		}
+		return &countGet{DocumentPoolWrapper: wrapper, count: &count}
+	}
+	pool := engine.Chain(db, named("outer"), counting, named("inner"))
+
+	if len(order) != 2 || order[0] != "inner" || order[1] != "outer" {
+		t.Error("expect inner middleware is applied first", order)
+	}
+
+	pool.Put("test_middleware", &testStruct{ID: 1, Number: 1})
+	pool.Get("test_middleware", "1", &testStruct{})
+
+	err := pool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		if err := tx.Get("test_middleware", "1", &testStruct{}); err != nil {
+			return err
+		}
+		if result := tx.Query(engine.MakeDBQuery("test_middleware", false)); result.Error() != errDenied {
+			return errors.New("expect transaction query result is wrapped")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 2 {
+		t.Error("expect 2 gets", count)
+	}
+	if result := pool.Query(engine.MakeDBQuery("test_middleware", false)); result.Error() != errDenied {
+		t.Error("expect query result is wrapped")
+	}
+
+	truck := engine.CreateDataStruck()
+	truck.Append("test_middleware", &testStruct{ID: 2, Number: 2})
+	truck.Append("test_middleware", &testStruct{ID: 3, Number: 3})
+	truck.Append("test_middleware", &testStruct{ID: 4, Number: 4})
+
+	if err := truck.Commit(pool); err != nil {
+		t.Error(err)
+	}
+	if transactions != 3 {
+		t.Error("expect truck commit 2 transactions by the limit of wrapped pool", transactions-1)
+	}
+
+	memPool := &adapter.LocalMemDB{}
+	memPool.Init("")
+	observer := &recordObserver{}
+
+	eng := &engine.Engine{}
+	eng.SetObserver(observer)
+	eng.Init(engine.ChainMemPool(memPool, func(next engine.MemPool) engine.MemPool {
+		return engine.NewMemPoolWrapper(next)
+	}), nil, nil)
+
+	eng.GetMemPool().Set("key", "value")
+	if len(observer.operations) != 1 {
+		t.Error("expect observer is set behind middleware", observer.operations)
+	}
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+	"github.com/tapvanvn/godbengine/engine/migrate"
+)
+
+func TestMigrate(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	var lockErr error = nil
+
+	migrator := migrate.NewMigrator(db)
+	migrator.Register(migrate.Migration{
+		ID: "002_add_number",
+		Up: func(pool engine.DocumentPool) error {
+			update := engine.MakeUpdate()
+			update.Inc("Number", 1)
+			_, err := pool.UpdateWhere(engine.MakeDBQuery("test", false), update)
+			return err
+		},
+		Down: func(pool engine.DocumentPool) error {
+			update := engine.MakeUpdate()
+			update.Inc("Number", -1)
+			_, err := pool.UpdateWhere(engine.MakeDBQuery("test", false), update)
+			return err
+		},
+	})
+	migrator.Register(migrate.Migration{
+		ID: "001_create",
+		Up: func(pool engine.DocumentPool) error {
+			//another instance must not be able to migrate while this one hold the lock
+			_, lockErr = migrate.NewMigrator(pool).Up()
+			return pool.Put("test", &testStruct{ID: 1, Number: 1})
+		},
+	})
+	if err := migrator.Register(migrate.Migration{ID: "001_create"}); !errors.Is(err, migrate.ErrDuplicateID) {
+		t.Error("expect duplicate id", err)
+	}
+	migrator.DryRun = true
+	planned, err := migrator.Up()
+	if err != nil || len(planned) != 2 {
+		t.Error(planned, err)
+		return
+	}
+	migrator.DryRun = false
+	applied, err := migrator.Up()
+	if err != nil || len(applied) != 2 || applied[0] != "001_create" {
+		t.Error(applied, err)
+		return
+	}
+	if lockErr != migrate.ErrLocked {
+		t.Error("expect locked", lockErr)
+	}
+	doc := &testStruct{}
+	if err := db.Get("test", "1", doc); err != nil || doc.Number != 2 {
+		t.Error(doc, err)
+	}
+	reverted, err := migrator.Down(1)
+	if err != nil || len(reverted) != 1 || reverted[0] != "002_add_number" {
+		t.Error(reverted, err)
+		return
+	}
+	statuses, err := migrator.Status()
+	if err != nil || !statuses[0].Applied || statuses[1].Applied {
+		t.Error(statuses, err)
+	}
+	if _, err := migrator.Down(1); !errors.Is(err, migrate.ErrNoDown) {
+		t.Error("expect no down", err)
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+
+	db := &adapter.LocalDocDB{}
+	db.Init("")
+
+	var mux sync.Mutex
+	count := 0
+	errs := make(chan error)
+
+	for i := 0; i < 10; i++ {
+		go func() {
+			migrator := migrate.NewMigrator(db)
+			migrator.Register(migrate.Migration{
+				ID: "001_count",
+				Up: func(pool engine.DocumentPool) error {
+					mux.Lock()
+					count++
+					mux.Unlock()
+					return nil
+				},
+			})
+			_, err := migrator.Up()
+			errs <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil && err != migrate.ErrLocked {
+			t.Error(err)
+		}
+	}
+	if count != 1 {
+		t.Error("expect migration is applied once", count)
+	}
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+//openTestMongo open the mongodb of MONGO_TEST_URL like mongodb://localhost:27017/test, the test is skipped when it is not set
+func openTestMongo(t *testing.T) engine.DocumentPool {
+
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+	pool, err := adapter.OpenDocumentPool(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+//regex filter compare the field with the pattern, not the whole document
+func TestMongoRegexFilter(t *testing.T) {
+
+	pool := openTestMongo(t)
+
+	pool.DelCollection("test_regex")
+	defer pool.DelCollection("test_regex")
+
+	for id, name := range []string{"alice", "alfred", "bob"} {
+		if err := pool.PutRaw("test_regex", name, map[string]interface{}{"ID": id, "Name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := engine.MakeDBQuery("test_regex", false)
+	query.Filter("Name", "regex", "^al")
+
+	if count := pool.Query(query).Count(); count != 2 {
+		t.Error("expect 2 documents", count)
+	}
+}
+
+//tenants of database mode do not share collections of the same name
+func TestMongoTenantDatabase(t *testing.T) {
+
+	pool := openTestMongo(t)
+	eng := engine.NewEngine(nil, pool, nil)
+
+	tenantA, err := eng.ForTenant("a", engine.TenantOptions{Database: true, DatabasePrefix: "test_tenant_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantB, _ := eng.ForTenant("b", engine.TenantOptions{Database: true, DatabasePrefix: "test_tenant_"})
+	defer tenantA.GetDocumentPool().DelCollection("user")
+	defer tenantB.GetDocumentPool().DelCollection("user")
+
+	if err := tenantA.GetDocumentPool().PutRaw("user", "1", map[string]interface{}{"Name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.GetDocumentPool().PutRaw("user", "2", map[string]interface{}{"Name": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]interface{}{}
+	if err := tenantB.GetDocumentPool().Get("user", "1", &document); !pool.IsNoRecordError(err) {
+		t.Error("expect tenant b can not read tenant a", document, err)
+	}
+	if err := tenantA.GetDocumentPool().Get("user", "2", &document); !pool.IsNoRecordError(err) {
+		t.Error("expect tenant a can not read tenant b", document, err)
+	}
+	if err := tenantA.GetDocumentPool().Get("user", "1", &document); err != nil || document["Name"] != "alice" {
+		t.Error("expect tenant a read its document", document, err)
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+	"github.com/tapvanvn/godbengine/engine/promobserver"
+)
+
+type recordObserver struct {
+	mux        sync.Mutex
+	before     int
+	operations []engine.Operation
+}
+
+func (observer *recordObserver) Before(ctx context.Context, op *engine.Operation) context.Context {
+
+	observer.mux.Lock()
+	observer.before++
+	observer.mux.Unlock()
+	return ctx
+}
+
+func (observer *recordObserver) After(ctx context.Context, op *engine.Operation) {
+
+	observer.mux.Lock()
+	observer.operations = append(observer.operations, *op)
+	observer.mux.Unlock()
+}
+
+func (observer *recordObserver) find(pool string, name string) *engine.Operation {
+
+	for i := range observer.operations {
+
+		if observer.operations[i].Pool == pool && observer.operations[i].Name == name {
+
+			return &observer.operations[i]
+		}
+	}
+	return nil
+}
+
+func TestObserver(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "godbengine")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	memPool := &adapter.LocalMemDB{}
+	memPool.Init("")
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+	filePool, err := adapter.NewFileClient(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	registry := prometheus.NewRegistry()
+	metrics, err := promobserver.New(registry)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	observer := &recordObserver{}
+
+	eng := &engine.Engine{}
+	eng.SetObserver(engine.Observers{observer, metrics})
+	eng.Init(memPool, documentPool, filePool)
+
+	memPool.Set("key", "value")
+	memPool.Get("key")
+
+	doc := &testStruct{ID: 1, Number: 1}
+	documentPool.Put("test_observer", doc)
+	documentPool.Get("test_observer", "1", &testStruct{})
+	documentPool.Get("test_observer", "2", &testStruct{})
+
+	content := []byte("content")
+	filePool.Write("/observer.txt", &content)
+	filePool.Read("/observer.txt")
+
+	if observer.before != len(observer.operations) || len(observer.operations) != 7 {
+		t.Error("expect 7 operations", observer.before, observer.operations)
+		return
+	}
+	if op := observer.find("local_memdb", "get"); op == nil || op.ID != "key" || op.ResultSize != 5 {
+		t.Error("expect mem get", op)
+	}
+	if op := observer.find("local_docdb", "put"); op == nil || op.Collection != "test_observer" || op.ID != "1" || op.Err != nil {
+		t.Error("expect doc put", op)
+	}
+	if op := observer.operations[4]; op.Name != "get" || op.Err != engine.NoDocument || op.ResultSize != 0 {
+		t.Error("expect doc get miss", op)
+	}
+	if op := observer.find("file", "read"); op == nil || op.ResultSize != len(content) {
+		t.Error("expect file read", op)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(families) != 2 {
+		t.Error("expect 2 metric families", len(families))
+	}
+}
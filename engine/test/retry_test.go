@@ -0,0 +1,190 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+var errTransient = errors.New("transient")
+
+//flakyDocDB fail the next writes with errTransient
+type flakyDocDB struct {
+	adapter.LocalDocDB
+	failures int
+	calls    int
+	closed   int
+}
+
+func (db *flakyDocDB) IsTransientError(err error) bool {
+
+	return err == errTransient
+}
+
+func (db *flakyDocDB) fail() bool {
+
+	db.calls++
+	if db.failures > 0 {
+		db.failures--
+		return true
+	}
+	return false
+}
+
+func (db *flakyDocDB) PutRaw(collection string, id string, document interface{}) error {
+
+	if db.fail() {
+		return errTransient
+	}
+	return db.LocalDocDB.PutRaw(collection, id, document)
+}
+
+func (db *flakyDocDB) Update(collection string, id string, update engine.Update) error {
+
+	if db.fail() {
+		return errTransient
+	}
+	return db.LocalDocDB.Update(collection, id, update)
+}
+
+//failedQueryResult count Close of results those failed with errTransient
+type failedQueryResult struct {
+	closed *int
+}
+
+func (result *failedQueryResult) Error() error                      { return errTransient }
+func (result *failedQueryResult) Next(document interface{}) error   { return errTransient }
+func (result *failedQueryResult) GetOne(document interface{}) error { return errTransient }
+func (result *failedQueryResult) Close()                            { *result.closed++ }
+func (result *failedQueryResult) IsAvailable() bool                 { return false }
+func (result *failedQueryResult) Count() int64                      { return 0 }
+
+func (db *flakyDocDB) Query(query engine.DBQuery) engine.DBQueryResult {
+
+	if db.fail() {
+		return &failedQueryResult{closed: &db.closed}
+	}
+	return db.LocalDocDB.Query(query)
+}
+
+func TestRetry(t *testing.T) {
+
+	db := &flakyDocDB{}
+	db.Init("")
+
+	policy := engine.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxAttempts = 3
+
+	pool := engine.Chain(db, engine.RetryDocumentPool(policy))
+
+	db.failures = 2
+	if err := pool.PutRaw("test_retry", "1", map[string]interface{}{"Number": 1}); err != nil || db.calls != 3 {
+		t.Error("expect put succeed on third attempt", err, db.calls)
+	}
+
+	db.failures, db.calls = 3, 0
+	if err := pool.PutRaw("test_retry", "1", map[string]interface{}{"Number": 1}); err != errTransient || db.calls != 3 {
+		t.Error("expect put fail after 3 attempts", err, db.calls)
+	}
+
+	update := engine.MakeUpdate()
+	update.Inc("Number", 1)
+
+	db.failures, db.calls = 1, 0
+	if err := pool.Update("test_retry", "1", update); err != errTransient || db.calls != 1 {
+		t.Error("expect inc is not retried", err, db.calls)
+	}
+
+	update = engine.MakeUpdate()
+	update.Set("Number", 5)
+
+	db.failures, db.calls = 1, 0
+	if err := pool.Update("test_retry", "1", update); err != nil || db.calls != 2 {
+		t.Error("expect set is retried", err, db.calls)
+	}
+
+	if err := pool.Get("test_retry", "2", &testStruct{}); err != engine.NoDocument {
+		t.Error("expect no document is returned as it is", err)
+	}
+
+	policy.MaxAttempts = 0
+	policy.MaxElapsed = 20 * time.Millisecond
+	policy.InitialBackoff = 5 * time.Millisecond
+	policy.Jitter = 0
+	pool = engine.Chain(db, engine.RetryDocumentPool(policy))
+
+	db.failures, db.calls = 100, 0
+	if err := pool.PutRaw("test_retry", "1", map[string]interface{}{"Number": 1}); err != errTransient || db.calls < 2 || db.calls > 4 {
+		t.Error("expect put stop by deadline", err, db.calls)
+	}
+
+	if backoff := policy.Backoff(3); backoff != 20*time.Millisecond {
+		t.Error("expect exponential backoff", backoff)
+	}
+	policy.MaxBackoff = 10 * time.Millisecond
+	if backoff := policy.Backoff(3); backoff != 10*time.Millisecond {
+		t.Error("expect backoff is capped", backoff)
+	}
+}
+
+func TestUpdateIsIdempotent(t *testing.T) {
+
+	cases := []struct {
+		name       string
+		build      func(update *engine.Update)
+		idempotent bool
+	}{
+		{"set", func(update *engine.Update) { update.Set("Number", 1) }, true},
+		{"unset", func(update *engine.Update) { update.Unset("Number") }, true},
+		{"inc", func(update *engine.Update) { update.Inc("Number", 1) }, false},
+		{"push", func(update *engine.Update) { update.Push("Tags", "a") }, false},
+		{"pull", func(update *engine.Update) { update.Pull("Tags", "a") }, true},
+		{"add to set", func(update *engine.Update) { update.AddToSet("Tags", "a") }, true},
+		{"min", func(update *engine.Update) { update.Min("Number", 1) }, true},
+		{"max", func(update *engine.Update) { update.Max("Number", 1) }, true},
+		{"current date", func(update *engine.Update) { update.CurrentDate("UpdatedAt") }, false},
+		{"set and current date", func(update *engine.Update) {
+			update.Set("Number", 1)
+			update.CurrentDate("UpdatedAt")
+		}, false},
+	}
+	for _, c := range cases {
+
+		update := engine.MakeUpdate()
+		c.build(&update)
+		if update.IsIdempotent() != c.idempotent {
+			t.Error("unexpected idempotent", c.name, c.idempotent)
+		}
+	}
+}
+
+func TestRetryQueryClose(t *testing.T) {
+
+	db := &flakyDocDB{}
+	db.Init("")
+	db.PutRaw("test_retry_query", "1", map[string]interface{}{"Number": 1})
+
+	policy := engine.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxAttempts = 3
+
+	pool := engine.Chain(db, engine.RetryDocumentPool(policy))
+
+	db.failures, db.calls = 2, 0
+	result := pool.Query(engine.MakeDBQuery("test_retry_query", false))
+	if result.Error() != nil || result.Count() != 1 || db.closed != 2 {
+		t.Error("expect results of failed attempts are closed", result.Error(), db.closed)
+	}
+	result.Close()
+
+	db.failures, db.calls, db.closed = 3, 0, 0
+	result = pool.Query(engine.MakeDBQuery("test_retry_query", false))
+	if result.Error() != errTransient || db.closed != 2 {
+		t.Error("expect the last failed result is returned open", result.Error(), db.closed)
+	}
+	result.Close()
+}
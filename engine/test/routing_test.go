@@ -0,0 +1,124 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestRouting(t *testing.T) {
+
+	primary := &adapter.LocalDocDB{}
+	primary.Init("")
+	analytics := &adapter.LocalDocDB{}
+	analytics.Init("")
+	session := &adapter.LocalMemDB{}
+	session.Init("")
+	cache := &adapter.LocalMemDB{}
+	cache.Init("")
+
+	eng := &engine.Engine{}
+	eng.Init(session, primary, nil)
+	eng.RegisterDocumentPool("analytics", analytics)
+	eng.RegisterMemPool("cache", cache)
+	eng.RouteDocument("event_", "analytics")
+	eng.RouteDocument("event_user_", engine.DefaultPoolName)
+	eng.RouteMem("cache:", "cache")
+	eng.RouteMem("lost:", "lost")
+
+	if eng.DocumentPoolFor("event_click") != analytics || eng.DocumentPoolFor("event_user_login") != primary || eng.DocumentPoolFor("user") != primary {
+		t.Error("unexpected document route")
+	}
+
+	documentPool := eng.GetRoutedDocumentPool()
+	documentPool.PutRaw("event_click", "1", map[string]interface{}{"Number": 1})
+
+	var document map[string]interface{}
+	if err := analytics.Get("event_click", "1", &document); err != nil {
+		t.Error("expect document in analytics pool", err)
+	}
+	if err := primary.Get("event_click", "1", &document); !primary.IsNoRecordError(err) {
+		t.Error("expect no document in primary pool", err)
+	}
+
+	memPool := eng.GetRoutedMemPool()
+	memPool.Set("cache:1", "value")
+	if value, err := cache.Get("cache:1"); err != nil || value != "value" {
+		t.Error("expect key in cache pool", value, err)
+	}
+	if err := memPool.Set("lost:1", "value"); err != engine.NoPool {
+		t.Error("expect no pool", err)
+	}
+	if _, err := memPool.Get("missing"); !memPool.IsNotExistedError(err) {
+		t.Error("expect not existed", err)
+	}
+
+	tx := documentPool.MakeTransaction()
+	tx.PutRaw("event_view", "2", map[string]interface{}{"Number": 2})
+	tx.PutRaw("user", "2", map[string]interface{}{"Number": 2})
+	if err := tx.Commit(); err != engine.CrossPoolTransaction {
+		t.Error("expect cross pool transaction", err)
+	}
+	if err := analytics.Get("event_view", "2", &document); !analytics.IsNoRecordError(err) {
+		t.Error("expect nothing is committed", err)
+	}
+
+	tx = documentPool.MakeTransaction()
+	tx.PutRaw("event_view", "2", map[string]interface{}{"Number": 2})
+	if err := tx.Commit(); err != nil {
+		t.Error(err)
+	}
+	if err := analytics.Get("event_view", "2", &document); err != nil {
+		t.Error("expect transaction committed in analytics pool", err)
+	}
+
+	err := documentPool.RunInTransaction(context.Background(), func(tx engine.DBTransaction) error {
+
+		tx.PutRaw("event_view", "3", map[string]interface{}{"Number": 3})
+		tx.PutRaw("user", "3", map[string]interface{}{"Number": 3})
+		return nil
+	})
+	if err != engine.CrossPoolTransaction {
+		t.Error("expect run fail with cross pool transaction", err)
+	}
+	if err := primary.Get("user", "3", &document); !primary.IsNoRecordError(err) {
+		t.Error("expect nothing is committed by the run", err)
+	}
+
+	if health := eng.Health(context.Background()); len(health.Pools) != 4 {
+		t.Error("expect named pools in health", health)
+	}
+}
+
+//pools can be registered while they are read, run with -race
+func TestNamedPoolsConcurrent(t *testing.T) {
+
+	eng := &engine.Engine{}
+	done := make(chan bool)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			db := &adapter.LocalDocDB{}
+			db.Init("")
+			eng.RegisterDocumentPool(engine.DefaultPoolName, db)
+			eng.RegisterDocumentPool("other", db)
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			if eng.GetDocumentPool() == nil {
+				t.Error("expect default pool")
+			}
+			return
+		default:
+			eng.GetDocumentPool()
+			eng.GetMemPool()
+			eng.GetFilePool()
+			eng.Health(context.Background())
+		}
+	}
+}
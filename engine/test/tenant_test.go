@@ -0,0 +1,174 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tapvanvn/godbengine/engine"
+	"github.com/tapvanvn/godbengine/engine/adapter"
+)
+
+func TestTenant(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "godbengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memPool := &adapter.LocalMemDB{}
+	memPool.Init("")
+	documentPool := &adapter.LocalDocDB{}
+	documentPool.Init("")
+	filePool, _ := adapter.NewFileClient(dir)
+
+	eng := engine.NewEngine(memPool, documentPool, filePool)
+
+	if _, err := eng.ForTenant("a__b"); err != engine.InvalidTenant {
+		t.Error("expect invalid tenant", err)
+	}
+	if _, err := eng.ForTenant("a", engine.TenantOptions{Database: true}); err != engine.TenantDatabaseUnsupported {
+		t.Error("expect local doc db has no database", err)
+	}
+
+	tenantA, _ := eng.ForTenant("a")
+	tenantB, _ := eng.ForTenant("b")
+
+	tenantA.GetDocumentPool().PutRaw("user", "1", map[string]interface{}{"Name": "alice"})
+	tenantA.GetMemPool().Set("session", "a")
+	tenantB.GetMemPool().Set("session", "b")
+	content := []byte("a")
+	if err := tenantA.GetFilePool().Write("../../avatar", &content); err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]interface{}
+	if err := documentPool.Get("a__user", "1", &document); err != nil || document["Name"] != "alice" {
+		t.Error("expect prefixed collection", document, err)
+	}
+	if err := tenantB.GetDocumentPool().Get("user", "1", &document); !documentPool.IsNoRecordError(err) {
+		t.Error("expect tenant b can not read tenant a", err)
+	}
+	if value, _ := tenantB.GetMemPool().Get("session"); value != "b" {
+		t.Error("expect tenant keys are separated", value)
+	}
+	if _, err := os.Stat(dir + "/a/avatar"); err != nil {
+		t.Error("expect file in tenant folder", err)
+	}
+
+	if keys, err := memPool.FindKey("[ab]:sess*"); err != nil || len(keys) != 2 || keys[0] != "a:session" {
+		t.Error("expect glob match keys of both tenants", keys, err)
+	}
+	if keys, _ := tenantB.GetMemPool().FindKey("*"); len(keys) != 1 || keys[0] != "session" {
+		t.Error("expect keys of tenant b only", keys)
+	}
+
+	tx := tenantA.GetDocumentPool().MakeTransaction()
+	tx.PutRaw("user", "2", map[string]interface{}{"Name": "bob"})
+	if items := tx.Items(); len(items) != 1 || items[0].Collection != "user" {
+		t.Error("expect items without prefix", items)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := engine.TenantData{Collections: []string{"user"}, Paths: []string{"avatar"}, Keys: true}
+
+	buffer := &bytes.Buffer{}
+	if err := tenantA.Export(data, buffer); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	decoder := json.NewDecoder(buffer)
+	for decoder.More() {
+		record := engine.TenantRecord{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		counts[record.Type]++
+		if record.Type == "mem" && (record.Key != "session" || record.Value != "a") {
+			t.Error("unexpected mem record", record)
+		}
+	}
+	if counts["document"] != 2 || counts["mem"] != 1 || counts["file"] != 1 {
+		t.Error("unexpected export", counts)
+	}
+
+	if err := tenantA.Delete(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := documentPool.Get("a__user", "1", &document); !documentPool.IsNoRecordError(err) {
+		t.Error("expect tenant collection is deleted", err)
+	}
+	if _, err := memPool.Get("a:session"); !memPool.IsNotExistedError(err) {
+		t.Error("expect tenant key is deleted", err)
+	}
+	if value, _ := memPool.Get("b:session"); value != "b" {
+		t.Error("expect other tenant is kept", value)
+	}
+}
+
+//scopedDocDB serve a local doc db per database like MongoPool.WithDatabase
+type scopedDocDB struct {
+	*adapter.LocalDocDB
+	databases map[string]*adapter.LocalDocDB
+}
+
+func (db *scopedDocDB) WithDatabase(database string) engine.DocumentPool {
+
+	if _, ok := db.databases[database]; !ok {
+		scoped := &adapter.LocalDocDB{}
+		scoped.Init("")
+		db.databases[database] = scoped
+	}
+	return db.databases[database]
+}
+
+func TestTenantDatabaseMiddleware(t *testing.T) {
+
+	root := &adapter.LocalDocDB{}
+	root.Init("")
+	scoper := &scopedDocDB{LocalDocDB: root, databases: map[string]*adapter.LocalDocDB{}}
+
+	keys := engine.NewKeyRing()
+	keys.AddKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+	encryption := engine.NewFieldEncryption(keys)
+
+	documentPool := engine.Chain(scoper, engine.RetryDocumentPool(engine.RetryPolicy{MaxAttempts: 2}), engine.EncryptFieldsDocumentPool(encryption))
+	eng := engine.NewEngine(nil, documentPool, nil)
+
+	tenant, err := eng.ForTenant("a", engine.TenantOptions{Database: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant.GetDocumentPool().Put("user", &encryptedUser{ID: "1", Name: "Alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	raw := map[string]interface{}{}
+	if err := scoper.databases["tenant_a"].Get("user", "1", &raw); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := raw["Name"].(string); !engine.IsEncrypted(name) {
+		t.Error("expect middlewares of engine's pool are kept in tenant database", raw)
+	}
+
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	if err := eng.SetAdminPublicKey(publicKeyPEM(t, privateKey.Public())); err != nil {
+		t.Fatal(err)
+	}
+	tenant, _ = eng.ForTenant("a", engine.TenantOptions{Database: true})
+	if err := tenant.GetDocumentPool().DelCollection("user"); err != engine.AdminRequired {
+		t.Error("expect tenant database is guarded", err)
+	}
+
+	custom := func(next engine.DocumentPool) engine.DocumentPool { return engine.NewDocumentPoolWrapper(next) }
+	eng = engine.NewEngine(nil, engine.Chain(scoper, custom), nil)
+	if _, err := eng.ForTenant("a", engine.TenantOptions{Database: true}); err != engine.TenantDatabaseUnsupported {
+		t.Error("expect middleware that can not rewrap is not dropped", err)
+	}
+}
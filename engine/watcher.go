@@ -1,7 +1,6 @@
 package engine
 
 import (
-	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +15,13 @@ type Watcher struct {
 	tick      map[string]int64
 	dirty     map[string]bool
 	pool      DocumentPool
+	logger    Logger
+}
+
+//SetLogger set logger that receive errors of background writes
+func (watcher *Watcher) SetLogger(logger Logger) {
+
+	watcher.logger = logger
 }
 
 func NewWatcher(timeRange time.Duration, pool DocumentPool) *Watcher {
@@ -58,8 +64,7 @@ func (watcher *Watcher) run() {
 
 						err := transaction.Commit()
 						if err != nil {
-							//TODO: report error
-							fmt.Println("Watcher", err)
+							LoggerOrNop(watcher.logger).Error("watcher commit fail", "error", err)
 						}
 						transaction = watcher.pool.MakeTransaction()
 						transaction.Begin()
@@ -72,9 +77,7 @@ func (watcher *Watcher) run() {
 		err := transaction.Commit()
 		if err != nil {
 
-			fmt.Println("Watcher", err)
-
-			//TODO: report error
+			LoggerOrNop(watcher.logger).Error("watcher commit fail", "error", err)
 		}
 	}
 }
@@ -94,7 +97,7 @@ func (watcher *Watcher) UpdateForce(collection string, docID string) {
 	watcher.docMux.Lock()
 	if doc, ok := watcher.documents[mapID]; ok {
 		if err := watcher.pool.Put(collection, doc); err != nil {
-			fmt.Println("Watcher", collection, docID, err)
+			LoggerOrNop(watcher.logger).Error("watcher put fail", "collection", collection, "id", docID, "error", err)
 		}
 	}
 	watcher.docMux.Unlock()
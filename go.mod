@@ -6,8 +6,13 @@ require (
 	cloud.google.com/go/firestore v1.5.0
 	github.com/go-redis/redis/v8 v8.11.0
 	github.com/google/uuid v1.1.5
+	github.com/klauspost/compress v1.13.6
+	github.com/prometheus/client_golang v1.11.1
 	github.com/tapvanvn/gocondition v1.0.0-alpha.1
 	go.mongodb.org/mongo-driver v1.7.4
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	google.golang.org/api v0.40.0
 	google.golang.org/grpc v1.35.0
+	gopkg.in/yaml.v2 v2.3.0
 )